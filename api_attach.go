@@ -15,36 +15,34 @@
 package main
 
 import (
-	"fmt"
-	"time"
+	"os"
 
 	"github.com/dim-an/cod/server"
+	"github.com/dim-an/cod/util"
 )
 
-func getLogFileBaseName(t time.Time) string {
-	return fmt.Sprintf("cod.%v.log", t.Format("2006-01-02"))
-}
-
 func shellApiAttachMain(pid uint, shell string) {
 	config, err := server.DefaultConfiguration()
 	if err != nil {
 		fatal(err)
 	}
 
-	// Trying to daemonize.
-	err = daemonize(&config)
-	verifyFatal(err)
-
-	client, err := server.NewClient(config)
+	client, err := connectDaemon(&config)
 	if err != nil {
 		fatal(err)
 	}
 
+	dir, err := os.Getwd()
+	verifyFatal(err)
+
 	rsp := server.AttachResponse{}
 	req := server.AttachRequest{
-		Pid:           int(pid),
-		Shell:         shell,
-		CodBinaryPath: CodBinaryPath,
+		ProtocolVersion: server.ProtocolVersion,
+		Pid:             int(pid),
+		Shell:           shell,
+		CodBinaryPath:   CodBinaryPath,
+		Dir:             dir,
+		PathVar:         util.GetPathVar(os.Environ()),
 	}
 
 	err = client.Request(&req, &rsp)
@@ -45,8 +45,9 @@ func (a *applicationImpl) Config() *server.Configuration {
 
 func (a *applicationImpl) Client() *server.Client {
 	if a.client == nil {
+		// Commands don't need attached shells, daemon is started if it is not running.
 		var err error
-		a.client, err = server.NewClient(*a.Config())
+		a.client, err = connectDaemon(a.Config())
 		verifyFatal(err)
 	}
 	return a.client
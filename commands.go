@@ -17,10 +17,15 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
+	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/dim-an/cod/datastore"
 	"github.com/dim-an/cod/server"
@@ -28,8 +33,18 @@ import (
 	"github.com/dim-an/cod/util"
 )
 
+// requester is implemented by server.Client and by shell session that reconnects to restarted daemon.
+type requester interface {
+	Request(req interface{}, rsp interface{}) error
+}
+
 func summarizeLearning(rsp *server.AddHelpPageResponse) (err error) {
-	var msg string
+	ui := NewUI()
+	_, err = fmt.Print(ui.Styled("green", learningSummary(rsp)))
+	return
+}
+
+func learningSummary(rsp *server.AddHelpPageResponse) (msg string) {
 	if rsp.Status == datastore.AddHelpPageStatusNew {
 		examples := ""
 
@@ -53,9 +68,6 @@ func summarizeLearning(rsp *server.AddHelpPageResponse) (err error) {
 		}
 		msg = fmt.Sprintf("cod: updated completions\n")
 	}
-
-	ui := NewUI()
-	_, err = fmt.Print(ui.Styled("green", msg))
 	return
 }
 
@@ -96,40 +108,19 @@ func apiPostexecMain(pid uint, command string) {
 		fatal(err)
 	}
 
-	req := server.ParseCommandLineRequest{
-		Pid:         int(pid),
-		CommandLine: command,
-		Dir:         dir,
-		Env:         os.Environ(),
-	}
-	rsp := server.ParseCommandLineResponse{}
-
-	err = app.Client().Request(&req, &rsp)
-	if err != nil {
-		if server.GetErrorCode(err) == server.BinaryNotFound {
-			return
-		}
-		fatal(err)
-	}
+	env := os.Environ()
+	rsp, err := parseExecutedCommand(app.Client(), pid, command, dir, env)
+	verifyFatal(err)
 
-	if len(rsp.Args) == 0 || !rsp.IsHelpCommand || rsp.PolicyMode == datastore.PolicyIgnore {
+	if !isLearnable(&rsp) {
 		return
 	}
 
 	if rsp.PolicyMode == datastore.PolicyTrust {
-		// send update request
-		req := server.AddHelpPageRequest{
-			Command: datastore.Command{
-				Args: rsp.Args,
-				Env:  append(os.Environ(), rsp.Env...),
-				Dir:  dir,
-			},
-		}
-		rsp := server.AddHelpPageResponse{}
-
-		err = app.Client().Request(&req, &rsp)
+		var addRsp server.AddHelpPageResponse
+		addRsp, err = learnExecutedCommand(app.Client(), &rsp, dir, env, datastore.PolicyUnknown)
 		verifyFatal(err)
-		err = summarizeLearning(&rsp)
+		err = summarizeLearning(&addRsp)
 		verifyFatal(err)
 		return
 	}
@@ -163,21 +154,10 @@ loop:
 
 		switch r {
 		case 'y':
-			var policy datastore.Policy
-			policy = datastore.PolicyTrust
-			req := server.AddHelpPageRequest{
-				Command: datastore.Command{
-					Args: rsp.Args,
-					Env:  append(os.Environ(), rsp.Env...),
-					Dir:  dir,
-				},
-				Policy: policy,
-			}
-			rsp := server.AddHelpPageResponse{}
-
-			err = app.Client().Request(&req, &rsp)
+			var addRsp server.AddHelpPageResponse
+			addRsp, err = learnExecutedCommand(app.Client(), &rsp, dir, env, datastore.PolicyTrust)
 			verifyFatal(err)
-			err = summarizeLearning(&rsp)
+			err = summarizeLearning(&addRsp)
 			verifyFatal(err)
 			break loop
 		case 'n':
@@ -192,6 +172,136 @@ loop:
 	}
 }
 
+// parseExecutedCommand asks daemon whether the command executed by the shell is a help command.
+// Commands that cannot be resolved to a binary are never help commands.
+func parseExecutedCommand(client requester, pid uint, command, dir string, env []string) (rsp server.ParseCommandLineResponse, err error) {
+	req := server.ParseCommandLineRequest{
+		Pid:         int(pid),
+		CommandLine: command,
+		Dir:         dir,
+		Env:         env,
+	}
+	err = client.Request(&req, &rsp)
+	if server.GetErrorCode(err) == server.BinaryNotFound {
+		err = nil
+		rsp = server.ParseCommandLineResponse{}
+	}
+	return
+}
+
+func isLearnable(rsp *server.ParseCommandLineResponse) bool {
+	return len(rsp.Args) > 0 && rsp.IsHelpCommand && rsp.PolicyMode != datastore.PolicyIgnore
+}
+
+func learnExecutedCommand(
+	client requester,
+	parsed *server.ParseCommandLineResponse,
+	dir string,
+	env []string,
+	policy datastore.Policy,
+) (rsp server.AddHelpPageResponse, err error) {
+	req := server.AddHelpPageRequest{
+		Command: datastore.Command{
+			Args: parsed.Args,
+			Env:  append(env, parsed.Env...),
+			Dir:  dir,
+		},
+		Policy: policy,
+	}
+	err = client.Request(&req, &rsp)
+	return
+}
+
+func apiPromptHookMain(pid uint, command string) {
+	app := NewApplication()
+	defer app.Close()
+
+	dir, err := os.Getwd()
+	verifyFatal(err)
+	script, err := promptHookScript(app.Client(), pid, command, dir, os.Environ())
+	verifyFatal(err)
+	for _, line := range script {
+		fmt.Println(line)
+	}
+}
+
+func apiLazyCompletionsMain(pid uint, name string) {
+	app := NewApplication()
+	defer app.Close()
+
+	script, err := lazyCompletionsScript(app.Client(), pid, name)
+	verifyFatal(err)
+	for _, line := range script {
+		fmt.Println(line)
+	}
+}
+
+func lazyCompletionsScript(client requester, pid uint, name string) (script []string, err error) {
+	req := server.LazyCompletionsRequest{
+		Pid:  int(pid),
+		Name: name,
+	}
+	rsp := server.LazyCompletionsResponse{}
+	err = client.Request(&req, &rsp)
+	script = rsp.Script
+	return
+}
+
+// promptHookScript returns script sourced by bash, zsh or fish at prompt.
+// It applies pending updates and learns executed help command if policy allows it,
+// learning that requires user interaction is left to `cod api postexec` run by the script.
+func promptHookScript(client requester, pid uint, command, dir string, env []string) (script []string, err error) {
+	req := server.PromptHookRequest{
+		Pid:         int(pid),
+		CommandLine: command,
+		Dir:         dir,
+		Env:         env,
+	}
+	rsp := server.PromptHookResponse{}
+	err = client.Request(&req, &rsp)
+	if err != nil {
+		return
+	}
+	script = rsp.Script
+	if !isLearnable(&rsp.Command) {
+		return
+	}
+
+	quote := quotePosix
+	pollUpdates := fmt.Sprintf("source <(command $__COD_BINARY api poll-updates -- %v)", pid)
+	if rsp.Shell == "fish" {
+		quote = shells.QuoteFishString
+		pollUpdates = fmt.Sprintf("command $__COD_BINARY api poll-updates -- %v | source", pid)
+	}
+
+	if rsp.Command.PolicyMode != datastore.PolicyTrust {
+		script = append(script,
+			fmt.Sprintf("command $__COD_BINARY api postexec -- %v %v", pid, quote(command)),
+			pollUpdates,
+		)
+		return
+	}
+
+	addRsp, err := learnExecutedCommand(client, &rsp.Command, dir, env, datastore.PolicyUnknown)
+	if err != nil {
+		return
+	}
+	// Script is sourced by interactive shell, so the message is always styled.
+	summary := strings.TrimSuffix(learningSummary(&addRsp), "\n")
+	script = append(script, "printf '%s\\n' "+quote(TerminalUI(0).Styled("green", summary)))
+
+	// Completions of the learned command are available at once.
+	pollRsp := server.PollUpdatesResponse{}
+	err = client.Request(&server.PollUpdatesRequest{Pid: int(pid)}, &pollRsp)
+	script = append(script, pollRsp.Script...)
+	return
+}
+
+// quotePosix quotes argument for bash and zsh.
+func quotePosix(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
 func apiListClientsMain() {
 	app := NewApplication()
 	defer app.Close()
@@ -207,7 +317,21 @@ func apiListClientsMain() {
 	}
 }
 
-func initMain(pid uint, shell string) {
+func apiVersionMain() {
+	app := NewApplication()
+	defer app.Close()
+
+	req := server.VersionRequest{}
+	rsp := server.VersionResponse{}
+
+	err := app.Client().Request(&req, &rsp)
+	verifyFatal(err)
+
+	fmt.Printf("client\t%v\t%v\n", server.ProtocolVersion, Version)
+	fmt.Printf("daemon\t%v\t%v\tpid %v\n", rsp.ProtocolVersion, rsp.Version, rsp.Pid)
+}
+
+func initMain(pid uint, shell string, lazy bool) {
 	app := NewApplication()
 	defer app.Close()
 
@@ -216,18 +340,21 @@ func initMain(pid uint, shell string) {
 	verifyFatal(err)
 
 	runDir := app.Config().GetRunDir()
-	err = util.CreateDirIfNotExists(runDir)
-	verifyFatal(err)
-
-	err = daemonize(app.Config())
+	err = util.CreatePrivateDir(runDir)
 	verifyFatal(err)
 
 	{ // attach
+		dir, err := os.Getwd()
+		verifyFatal(err)
+
 		rsp := server.AttachResponse{}
 		req := server.AttachRequest{
-			Pid:           int(pid),
-			Shell:         shell,
-			CodBinaryPath: CodBinaryPath,
+			ProtocolVersion: server.ProtocolVersion,
+			Pid:             int(pid),
+			Shell:           shell,
+			CodBinaryPath:   CodBinaryPath,
+			Dir:             dir,
+			PathVar:         util.GetPathVar(os.Environ()),
 		}
 
 		err = app.Client().Request(&req, &rsp)
@@ -238,7 +365,8 @@ func initMain(pid uint, shell string) {
 
 	{ // init script
 		req := server.InitScriptRequest{
-			Pid: int(pid),
+			Pid:  int(pid),
+			Lazy: lazy,
 		}
 		rsp := server.InitScriptResponse{}
 		err = app.Client().Request(&req, &rsp)
@@ -252,30 +380,325 @@ func initMain(pid uint, shell string) {
 	}
 }
 
-func apiCompleteWordsMain(_ uint, cword int, words []string) {
+func deinitMain(pid uint, shell string) {
+	app := NewApplication()
+	defer app.Close()
+
+	var script []string
+	{ // deinit script
+		req := server.DeinitScriptRequest{
+			Pid:   int(pid),
+			Shell: shell,
+		}
+		rsp := server.DeinitScriptResponse{}
+		err := app.Client().Request(&req, &rsp)
+		verifyFatal(err)
+		script = rsp.Script
+	}
+
+	{ // detach
+		req := server.DetachRequest{
+			Pid: int(pid),
+		}
+		rsp := server.DetachResponse{}
+		err := app.Client().Request(&req, &rsp)
+		verifyFatal(err)
+	}
+
+	for _, line := range script {
+		fmt.Println(line)
+	}
+}
+
+func uninstallMain(purge bool, assumeYes bool) {
+	configuration, err := server.DefaultConfiguration()
+	verifyFatal(err)
+
+	isRunning, err := isDaemonRunning(&configuration)
+	verifyFatal(err)
+	if isRunning {
+		// Plain connection is used, daemon of any version is stopped anyway.
+		client, err := server.NewClient(configuration)
+		verifyFatal(err)
+		req := server.ListClientsRequest{}
+		rsp := server.ListClientsResponse{}
+		err = client.Request(&req, &rsp)
+		_ = client.Close()
+		verifyFatal(err)
+		for _, client := range rsp.Clients {
+			log.Printf("warn: %v shell (pid %v) is still attached, run 'cod deinit' in it", client.Shell, client.Pid)
+		}
+
+		_, err = stopDaemon(&configuration)
+		verifyFatal(err)
+		fmt.Println("cod: daemon is stopped")
+	}
+
+	if purge {
+		var dirs []string
+		candidates := []string{configuration.GetDataDir(), configuration.GetConfigDir()}
+		// Run directory is outside of the data directory if $XDG_RUNTIME_DIR is used.
+		if !strings.HasPrefix(configuration.GetRunDir(), configuration.GetDataDir()+"/") {
+			candidates = append(candidates, configuration.GetRunDir())
+		}
+		for _, dir := range candidates {
+			if _, err := os.Stat(dir); err == nil {
+				dirs = append(dirs, dir)
+			}
+		}
+		if len(dirs) > 0 && !assumeYes {
+			ui := NewUI()
+			fmt.Printf("cod: remove %v? [yn] > ", strings.Join(dirs, ", "))
+			r, err := ui.GetKeystroke("yn")
+			verifyFatal(err)
+			if r == 'n' {
+				dirs = nil
+			}
+		}
+		for _, dir := range dirs {
+			err = os.RemoveAll(dir)
+			verifyFatal(err)
+			fmt.Printf("cod: removed %v\n", dir)
+		}
+	}
+
+	fmt.Println("cod: remove 'cod init' from your shell init script to finish uninstallation")
+}
+
+// connectRunningDaemon connects to the daemon without upgrading it, client is nil if daemon is not running.
+func connectRunningDaemon(config *server.Configuration) (client *server.Client) {
+	isRunning, err := isDaemonRunning(config)
+	verifyFatal(err)
+	if !isRunning {
+		isRunning, err = handoverLegacyDaemon(config)
+		verifyFatal(err)
+	}
+	if !isRunning {
+		return
+	}
+	client, err = server.NewClient(*config)
+	verifyFatal(err)
+	return
+}
+
+func daemonStatusMain() {
+	config, err := server.DefaultConfiguration()
+	verifyFatal(err)
+
+	client := connectRunningDaemon(&config)
+	if client == nil {
+		fmt.Println("cod: daemon is not running")
+		os.Exit(1)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	req := server.StatusRequest{}
+	rsp := server.StatusResponse{}
+	err = client.Request(&req, &rsp)
+	verifyFatal(err)
+
+	printField := func(name string, value interface{}) {
+		fmt.Printf("%-15v%v\n", name+":", value)
+	}
+	printField("pid", rsp.Pid)
+	printField("version", fmt.Sprintf("%v (protocol %v)", rsp.Version, rsp.ProtocolVersion))
+	printField("uptime", time.Since(rsp.StartTime).Round(time.Second))
+	printField("socket", rsp.SocketPath)
+	printField("database", fmt.Sprintf("%v (%v bytes)", rsp.DbPath, rsp.DbSize))
+	printField("help pages", rsp.HelpPageCount)
+	printField("pending jobs", rsp.PendingJobs)
+	printField("shells", len(rsp.Shells))
+	for _, shell := range rsp.Shells {
+		fmt.Printf("  %v\t%v\n", shell.Pid, shell.Shell)
+	}
+	printField("last errors", len(rsp.LastErrors))
+	for _, e := range rsp.LastErrors {
+		fmt.Printf("  %v\t%v\t%v\n", e.Time.Format(time.RFC3339), e.Request, e.Message)
+	}
+}
+
+func statsMain(prometheus bool) {
+	app := NewApplication()
+	defer app.Close()
+
+	req := server.StatsRequest{}
+	rsp := server.StatsResponse{}
+	err := app.Client().Request(&req, &rsp)
+	verifyFatal(err)
+
+	if prometheus {
+		server.WritePrometheusMetrics(os.Stdout, &rsp)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "counters since daemon start %v ago\n", time.Since(rsp.StartTime).Round(time.Second))
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "request\tcount\terrors\tavg\tp50\tp99")
+	for _, r := range rsp.Requests {
+		var avg time.Duration
+		if r.Count > 0 {
+			avg = r.TotalDuration / time.Duration(r.Count)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n",
+			r.Request, r.Count, r.Errors, avg.Round(time.Microsecond),
+			latencyQuantile(&r, 0.5), latencyQuantile(&r, 0.99))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "parser\tsuccesses\tfailures")
+	for _, p := range rsp.Parsers {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", p.Parser, p.Successes, p.Failures)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "learn failures: %v\n", rsp.LearnFailures)
+	fmt.Fprintf(w, "update failures: %v\n", rsp.UpdateFailures)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "executable\thelp pages")
+	for _, e := range rsp.Executables {
+		fmt.Fprintf(w, "%v\t%v\n", e.ExecutablePath, e.HelpPages)
+	}
+	err = w.Flush()
+	verifyFatal(err)
+}
+
+// latencyQuantile returns upper bound of histogram bucket containing the quantile.
+func latencyQuantile(r *server.RequestStats, q float64) string {
+	if r.Count == 0 {
+		return "-"
+	}
+	rank := uint64(math.Ceil(q * float64(r.Count)))
+	for _, b := range r.Buckets {
+		if b.Count >= rank {
+			return fmt.Sprintf("<=%v", b.UpperBound)
+		}
+	}
+	return fmt.Sprintf(">%v", r.Buckets[len(r.Buckets)-1].UpperBound)
+}
+
+func daemonStopMain() {
+	config, err := server.DefaultConfiguration()
+	verifyFatal(err)
+
+	client := connectRunningDaemon(&config)
+	if client == nil {
+		fmt.Println("cod: daemon is not running")
+		return
+	}
+	err = client.Request(&server.StopRequest{}, &server.StopResponse{})
+	_ = client.Close()
+	var errorResponse *server.ErrorResponse
+	if err != nil && !errors.As(err, &errorResponse) {
+		fatal(err)
+	}
+
+	// Daemons that don't know StopRequest are terminated by signal.
+	exited, err := waitDaemonExit(&config)
+	verifyFatal(err)
+	if !exited {
+		_, err = stopDaemon(&config)
+		verifyFatal(err)
+	}
+	fmt.Println("cod: daemon is stopped")
+}
+
+func daemonRestartMain() {
+	config, err := server.DefaultConfiguration()
+	verifyFatal(err)
+
+	client := connectRunningDaemon(&config)
+	if client == nil {
+		fmt.Println("cod: daemon is not running")
+		return
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	rsp, err := requestDaemonVersion(client)
+	verifyFatal(err)
+	if rsp.ProtocolVersion > server.ProtocolVersion {
+		fatal(fmt.Errorf("daemon (pid %v) is started from newer cod %v", rsp.Pid, rsp.Version))
+	}
+
+	// Restart is a handover to the daemon started from this binary.
+	err = handoverDaemon(&config, &config, client, rsp.ProtocolVersion)
+	verifyFatal(err)
+	fmt.Println("cod: daemon is restarted")
+}
+
+func apiCompleteWordsMain(pid uint, cword int, words []string, format string) {
 	app := NewApplication()
 	defer app.Close()
 
 	if len(words) == 0 {
 		fatal(fmt.Errorf("command line cannot be empty"))
 	}
+	if format != "plain" && format != "v1" {
+		fatal(fmt.Errorf("unknown format: %v", format))
+	}
 
-	env := os.Environ()
 	dir, err := os.Getwd()
 	verifyFatal(err)
-	executablePath, err := datastore.CanonizeExecutablePath(words[0], dir, util.GetPathVar(env), util.GetHomeVar(env))
+	rsp, err := completeWords(app.Client(), pid, cword, words, dir, os.Environ())
 	verifyFatal(err)
 
+	switch format {
+	case "plain":
+		for _, item := range rsp.Items {
+			fmt.Println(item.Value)
+		}
+	case "v1":
+		writeCompletionItemsV1(os.Stdout, rsp.ValueKind, rsp.Items)
+	}
+}
+
+func completeWords(client requester, pid uint, cword int, words []string, dir string, env []string) (rsp server.CompleteWordsResponse, err error) {
+	executablePath, err := datastore.CanonizeExecutablePath(words[0], dir, util.GetPathVar(env), util.GetHomeVar(env))
+	if err != nil {
+		return
+	}
+
 	req := server.CompleteWordsRequest{
 		Words: append([]string{executablePath}, words[1:]...),
 		CWord: cword,
+		Dir:   dir,
+		Env:   env,
+		Pid:   int(pid),
+	}
+	err = client.Request(&req, &rsp)
+	if err != nil {
+		return
 	}
-	rsp := server.CompleteWordsResponse{}
-	err = app.Client().Request(&req, &rsp)
-	verifyFatal(err)
 
-	for _, c := range rsp.Completions {
-		fmt.Println(c)
+	if rsp.Version != server.CompletionItemVersion {
+		err = fmt.Errorf("daemon responded with unsupported completion version %v, expected %v", rsp.Version, server.CompletionItemVersion)
+	}
+	return
+}
+
+var completionFieldReplacer = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+// writeCompletionItemsV1 writes completions in the format consumed by shell glue.
+// First line is the kind of the value being completed (might be empty).
+// Each following line describes one item, fields are separated by tab:
+//
+//	value, display, kind, group, nospace (0 or 1), description
+//
+// All fields except description are never empty.
+func writeCompletionItemsV1(w io.Writer, valueKind datastore.ValueKind, items []server.CompletionItem) {
+	_, _ = fmt.Fprintln(w, completionFieldReplacer.Replace(string(valueKind)))
+	for _, item := range items {
+		noSpace := "0"
+		if item.NoSpace {
+			noSpace = "1"
+		}
+		fields := []string{item.Value, item.Display, string(item.Kind), item.Group, noSpace, item.Description}
+		for i := range fields {
+			fields[i] = completionFieldReplacer.Replace(fields[i])
+		}
+		_, _ = fmt.Fprintln(w, strings.Join(fields, "\t"))
 	}
 }
 
@@ -296,7 +719,7 @@ func learnMain(helpCommand []string) {
 		Command: command,
 	}
 
-	client, err := server.NewClient(config)
+	client, err := connectDaemon(&config)
 	if err != nil {
 		fatal(fmt.Errorf("cannot connect to daemon: %w", err))
 	}
@@ -351,14 +774,20 @@ func listMain(selectors []string) {
 	app := NewApplication()
 	defer app.Close()
 
-	req := server.ListCommandsRequest{}
+	dir, err := os.Getwd()
+	verifyFatal(err)
+
+	req := server.ListCommandsRequest{
+		Dir:     dir,
+		PathVar: util.GetPathVar(os.Environ()),
+	}
 	if len(selectors) > 0 {
 		req.Selectors = selectors
 	} else {
 		req.Selectors = []string{"/**"}
 	}
 	rsp := server.ListCommandsResponse{}
-	err := app.Client().Request(&req, &rsp)
+	err = app.Client().Request(&req, &rsp)
 	verifyFatal(err)
 
 	sort.Sort(byApplication(rsp.CommandItems))
@@ -368,7 +797,13 @@ func listMain(selectors []string) {
 			quoted = shells.Quote(item.Command.Args)
 		}
 
-		fmt.Printf("%v\t%v\n", item.Id, quoted)
+		// Status is shown only for executables that share the name with other learned executables
+		// or are not the ones found in PATH.
+		if item.Status != server.ExecutableStatusUnknown {
+			fmt.Printf("%v\t%v\t(%v)\n", item.Id, quoted, item.Status)
+		} else {
+			fmt.Printf("%v\t%v\n", item.Id, quoted)
+		}
 	}
 }
 
@@ -425,6 +860,116 @@ func updateMain(selectors []string) {
 	}
 }
 
+func exportCompletionsMain(shell string, outDir string, selectors []string) {
+	generator, err := shells.NewStaticScriptGenerator(shell)
+	verifyFatal(err)
+
+	app := NewApplication()
+	defer app.Close()
+
+	req := server.ListCompletionsRequest{}
+	if len(selectors) > 0 {
+		req.Selectors = selectors
+	} else {
+		req.Selectors = []string{"/**"}
+	}
+	rsp := server.ListCompletionsResponse{}
+	err = app.Client().Request(&req, &rsp)
+	verifyFatal(err)
+
+	err = os.MkdirAll(outDir, 0755)
+	verifyFatal(err)
+
+	// Executables with the same name are resolved like the daemon does when completing:
+	// the one preferred by user configuration wins, otherwise the first one is exported.
+	exported := make(map[string]*server.ExecutableCompletions)
+	var fileNames []string
+	for idx := range rsp.Executables {
+		item := &rsp.Executables[idx]
+		if len(item.Completions) == 0 {
+			continue
+		}
+		fileName := generator.StaticFileName(filepath.Base(item.ExecutablePath))
+		other, ok := exported[fileName]
+		if !ok {
+			exported[fileName] = item
+			fileNames = append(fileNames, fileName)
+			continue
+		}
+		if item.Preferred && !other.Preferred {
+			exported[fileName] = item
+			item, other = other, item
+		}
+		log.Printf("warn: skipping %v: %v is already exported from %v", item.ExecutablePath, fileName, other.ExecutablePath)
+	}
+
+	for _, fileName := range fileNames {
+		item := exported[fileName]
+		filePath := filepath.Join(outDir, fileName)
+		script := generator.GenerateStaticScript(item.ExecutablePath, item.Completions)
+		err = os.WriteFile(filePath, []byte(script), 0644)
+		verifyFatal(err)
+		fmt.Println(filePath)
+	}
+}
+
+func exportMain(selectors []string) {
+	app := NewApplication()
+	defer app.Close()
+
+	req := server.ExportRequest{}
+	if len(selectors) > 0 {
+		req.Selectors = selectors
+	} else {
+		req.Selectors = []string{"/**"}
+	}
+	rsp := server.ExportResponse{}
+	err := app.Client().Request(&req, &rsp)
+	verifyFatal(err)
+
+	err = datastore.WriteBundle(os.Stdout, datastore.NewBundle(rsp.HelpPages))
+	verifyFatal(err)
+}
+
+func importMain(fileName string, strategy string, keepTrust bool) {
+	var bundle *datastore.Bundle
+	var err error
+	if fileName == "-" {
+		bundle, err = datastore.ReadBundle(os.Stdin)
+	} else {
+		var f *os.File
+		f, err = os.Open(fileName)
+		verifyFatal(err)
+		bundle, err = datastore.ReadBundle(f)
+		_ = f.Close()
+	}
+	verifyFatal(err)
+
+	app := NewApplication()
+	defer app.Close()
+
+	req := server.ImportRequest{
+		HelpPages: bundle.HelpPages,
+		Strategy:  server.ImportStrategy(strategy),
+		KeepTrust: keepTrust,
+		Env:       os.Environ(),
+	}
+	rsp := server.ImportResponse{}
+	err = app.Client().Request(&req, &rsp)
+	verifyFatal(err)
+
+	counts := make(map[server.ImportStatus]int)
+	for _, status := range rsp.Statuses {
+		counts[status] += 1
+	}
+	fmt.Printf(
+		"%v new, %v updated, %v skipped\n",
+		counts[server.ImportStatusNew],
+		counts[server.ImportStatusUpdated],
+		counts[server.ImportStatusSkipped],
+	)
+}
+
 func exampleConfigMain(createConfig bool) {
 	if !createConfig {
 		_, err := os.Stdout.WriteString(ExampleConfiguration)
@@ -17,12 +17,13 @@ package main
 import (
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"os"
 	"os/exec"
 	"os/signal"
-	"path"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dim-an/cod/server"
@@ -35,18 +36,21 @@ import (
 func daemonMain(foreground bool) {
 	configuration, err := server.DefaultConfiguration()
 	verifyFatal(err)
-	if foreground {
-		daemonProc(configuration, 0)
-	}
 
 	logDir := configuration.GetLogDir()
 	err = util.CreateDirIfNotExists(logDir)
 	verifyFatal(err)
 
 	runDir := configuration.GetRunDir()
-	err = util.CreateDirIfNotExists(runDir)
+	err = util.CreatePrivateDir(runDir)
 	verifyFatal(err)
 
+	if foreground {
+		// Service manager takes care of stderr of the daemon.
+		server.SetupLogging(os.Stderr)
+		daemonProc(configuration, 0)
+	}
+
 	executable, err := os.Executable()
 	verifyFatal(err)
 
@@ -72,50 +76,42 @@ func daemonMain(foreground bool) {
 	}
 }
 
-func cleanFile(file string) (err error) {
-	err = os.Remove(file)
-	if os.IsNotExist(err) {
-		err = nil
-	}
-	return
-}
-
 func daemonProc(configuration server.Configuration, pidToNotify int) {
 	err := os.Chdir("/")
 	verifyFatal(err)
 
-	log.SetPrefix(fmt.Sprintf("%v ", os.Getpid()))
-	log.Printf("Starting daemon. Version: %q", Version)
-
-	lockFileName := configuration.GetLockFile()
-	lockFileFd, err := unix.Open(lockFileName, os.O_CREATE|os.O_RDWR, 0600)
-	verifyFatal(err)
+	slog.Info("Starting daemon", "version", Version)
 
-	pidStr := []byte(strconv.Itoa(os.Getpid()))
-	_, err = unix.Write(lockFileFd, pidStr)
-	if err != nil {
-		log.Panic(fmt.Errorf("cannot write to %s: %w", lockFileName, err))
+	// Lock in the data directory keeps daemons that listen in different run directories,
+	// e.g. started with and without XDG_RUNTIME_DIR, from sharing the database.
+	for _, lockFileName := range []string{configuration.GetLockFile(), configuration.GetDataLockFile()} {
+		err = lockPidFile(lockFileName)
+		verifyFatal(err)
 	}
 
-	log.Printf("Locking file: %s", lockFileName)
-	err = unix.Flock(lockFileFd, unix.LOCK_EX|unix.LOCK_NB)
-	if err != nil {
-		log.Panic(fmt.Errorf("cannot lock file %s: %w", lockFileName, err))
-	}
+	slog.Debug("Launching server")
+	s, err := server.NewServer(&configuration, Version)
+	verifyFatal(err)
 
-	log.Printf("Removing old unix socket")
-	socketFile := configuration.GetSocketFile()
-	err = cleanFile(socketFile)
-	if err != nil {
-		log.Panic(fmt.Errorf("cannot remove %s: %w", socketFile, err))
-	}
+	stopSignals := make(chan os.Signal, 1)
+	signal.Notify(stopSignals, unix.SIGTERM, unix.SIGINT)
+	go func() {
+		sig := <-stopSignals
+		slog.Info("Got signal, stopping", "signal", sig)
+		s.Stop()
+
+		// Requests being processed are waited for unless the signal is repeated.
+		sig = <-stopSignals
+		slog.Warn("Got signal again, exiting immediately", "signal", sig)
+		os.Exit(1)
+	}()
 
-	log.Printf("Launching server")
-	s, err := server.NewServer(&configuration)
-	verifyFatal(err)
-	defer func() {
-		err = s.Close()
-		verifyFatal(err)
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, unix.SIGHUP)
+	go func() {
+		for range reloadSignals {
+			s.ReloadUserConfiguration()
+		}
 	}()
 
 	if pidToNotify != 0 {
@@ -125,7 +121,11 @@ func daemonProc(configuration server.Configuration, pidToNotify int) {
 	err = s.Serve()
 	verifyFatal(err)
 
-	log.Printf("Daemon is exiting normally")
+	// Deferred calls are not run by os.Exit, so server is closed explicitly.
+	err = s.Close()
+	verifyFatal(err)
+
+	slog.Info("Daemon is exiting normally")
 	os.Exit(0)
 }
 
@@ -136,10 +136,6 @@ func forkedDaemonMain(pidToNotify int) {
 	_, err = unix.Setsid()
 	verifyFatal(err)
 
-	sigqueue := make(chan os.Signal, 1)
-	go sighandler(sigqueue)
-	signal.Notify(sigqueue, unix.SIGHUP)
-
 	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
 	verifyFatal(err)
 	err = unix.Dup2(int(devNull.Fd()), 0)
@@ -149,59 +145,176 @@ func forkedDaemonMain(pidToNotify int) {
 	err = devNull.Close()
 	verifyFatal(err)
 
-	logFilePath := path.Join(configuration.GetLogDir(), getLogFileBaseName(time.Now()))
-	logFile, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	// Stderr follows rotations of the log file, so panics are written to the current one.
+	logFile, err := util.NewRotatingFile(configuration.GetLogFile(), server.MaxLogFileSize, server.LogFileBackups)
 	verifyFatal(err)
-	err = unix.Dup2(int(logFile.Fd()), 2)
-	verifyFatal(err)
-	err = logFile.Close()
+	err = logFile.RedirectFd(2)
 	verifyFatal(err)
+	server.SetupLogging(logFile)
 
 	daemonProc(configuration, pidToNotify)
 }
 
-func sighandler(queue chan os.Signal) {
-	for s := range queue {
-		log.Println("Got signal:", s)
+// lockPidFile locks file for the lifetime of the daemon and writes pid of the daemon into it.
+func lockPidFile(lockFileName string) (err error) {
+	lockFileFd, err := unix.Open(lockFileName, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return
+	}
+
+	slog.Debug("Locking file", "path", lockFileName)
+	err = unix.Flock(lockFileFd, unix.LOCK_EX|unix.LOCK_NB)
+	if err != nil {
+		err = fmt.Errorf("cannot lock file %s: %w", lockFileName, err)
+		return
+	}
+
+	// Pid is written only after lock is acquired, so pid of running daemon is never overwritten.
+	err = unix.Ftruncate(lockFileFd, 0)
+	if err != nil {
+		err = fmt.Errorf("cannot truncate %s: %w", lockFileName, err)
+		return
+	}
+	pidStr := []byte(strconv.Itoa(os.Getpid()))
+	_, err = unix.Write(lockFileFd, pidStr)
+	if err != nil {
+		err = fmt.Errorf("cannot write to %s: %w", lockFileName, err)
 	}
+	return
 }
 
-func daemonize(config *server.Configuration) (err error) {
-	checkDaemonIsRunning := func() (isRunning bool, err error) {
-		var fd int
-		fd, err = unix.Open(config.GetLockFile(), os.O_RDONLY, 0600)
-		if errors.Is(err, os.ErrNotExist) {
-			err = nil
-			isRunning = false
-			return
-		} else if err != nil {
-			return
-		}
+// isDaemonRunning checks if lock file is held by running daemon.
+func isDaemonRunning(config *server.Configuration) (isRunning bool, err error) {
+	return isFileLocked(config.GetLockFile())
+}
+
+func isFileLocked(lockFileName string) (isLocked bool, err error) {
+	var fd int
+	fd, err = unix.Open(lockFileName, os.O_RDONLY, 0600)
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+		isLocked = false
+		return
+	} else if err != nil {
+		return
+	}
 
-		defer func() {
-			err = unix.Close(fd)
-		}()
-		err = unix.Flock(fd, unix.LOCK_EX|unix.LOCK_NB)
-		if errors.Is(err, unix.EWOULDBLOCK) {
-			err = nil
-			isRunning = true
+	defer func() {
+		err = unix.Close(fd)
+	}()
+	err = unix.Flock(fd, unix.LOCK_EX|unix.LOCK_NB)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		err = nil
+		isLocked = true
+		return
+	} else if err != nil {
+		return
+	}
+	isLocked = false
+	err = unix.Flock(fd, unix.LOCK_UN)
+	return
+}
+
+// checkDatabaseNotLocked returns error if the database is used by the daemon listening in other run directory,
+// e.g. the one started with other XDG_RUNTIME_DIR. Starting daemon fails in this case.
+func checkDatabaseNotLocked(config *server.Configuration) (err error) {
+	isLocked, err := isFileLocked(config.GetDataLockFile())
+	if err != nil || !isLocked {
+		return
+	}
+	// Daemon might have been started in this run directory since it was checked.
+	isRunning, err := isDaemonRunning(config)
+	if err != nil || isRunning {
+		return
+	}
+	pidBytes, _ := os.ReadFile(config.GetDataLockFile())
+	err = fmt.Errorf(
+		"database %v is used by daemon (pid %v) that doesn't listen in %v, check XDG_RUNTIME_DIR: %w",
+		config.GetDataDir(), strings.TrimSpace(string(pidBytes)), config.GetRunDir(), errDatabaseLocked,
+	)
+	return
+}
+
+var errDatabaseLocked = errors.New("database is locked")
+
+// stopDaemon terminates running daemon and waits until it exits.
+func stopDaemon(config *server.Configuration) (stopped bool, err error) {
+	isRunning, err := isDaemonRunning(config)
+	if err != nil || !isRunning {
+		return
+	}
+
+	pidBytes, err := os.ReadFile(config.GetLockFile())
+	if err != nil {
+		return
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(pidBytes)))
+	if err != nil {
+		err = fmt.Errorf("bad pid in %s: %w", config.GetLockFile(), err)
+		return
+	}
+
+	err = unix.Kill(pid, unix.SIGTERM)
+	if err != nil {
+		err = fmt.Errorf("cannot stop daemon (pid %v): %w", pid, err)
+		return
+	}
+	stopped, err = waitDaemonExit(config)
+	if err == nil && !stopped {
+		err = fmt.Errorf("daemon (pid %v) didn't exit in time", pid)
+	}
+	return
+}
+
+// waitDaemonExit waits for a few seconds until running daemon exits.
+func waitDaemonExit(config *server.Configuration) (exited bool, err error) {
+	for i := 0; i != 50; i += 1 {
+		var isRunning bool
+		isRunning, err = isDaemonRunning(config)
+		if err != nil {
 			return
-		} else if err != nil {
+		}
+		if !isRunning {
+			exited = true
 			return
 		}
-		isRunning = false
-		err = unix.Flock(fd, unix.LOCK_UN)
-		return
+		time.Sleep(time.Millisecond * 100)
 	}
+	return
+}
 
+// isSocketActivated checks if somebody listens the socket while the daemon is not running,
+// i.e. the socket is held by service manager that starts the daemon on demand.
+func isSocketActivated(config *server.Configuration) bool {
+	conn, err := net.Dial("unix", config.GetSocketAddress())
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
+
+func daemonize(config *server.Configuration) (err error) {
 	var isRunning bool
-	isRunning, err = checkDaemonIsRunning()
+	isRunning, err = isDaemonRunning(config)
 	if err != nil {
 		return
 	}
 	if isRunning {
 		return
 	}
+	handedOver, err := handoverLegacyDaemon(config)
+	if err != nil || handedOver {
+		return
+	}
+	if isSocketActivated(config) {
+		// Daemon is started by service manager on the first connection.
+		return
+	}
+	err = checkDatabaseNotLocked(config)
+	if err != nil {
+		return
+	}
 
 	var executable string
 	executable, err = os.Executable()
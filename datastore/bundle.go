@@ -0,0 +1,148 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Bundle is the file written by `cod export` and read by `cod import`, format is described in README.
+type Bundle struct {
+	// Always BundleFormat.
+	Format string
+	// Bumped on incompatible changes, readers refuse bundles of versions they don't know.
+	Version   int
+	CreatedAt time.Time
+	HelpPages []BundleHelpPage
+}
+
+// BundleHelpPage is a learned help page. Help text itself is never stored by cod, only its checksum.
+type BundleHelpPage struct {
+	ExecutablePath string
+	// Command that printed the help page. Env is never exported, since it might contain secrets,
+	// and it is ignored on import.
+	Command     Command
+	CheckSum    string
+	Policy      Policy `json:",omitempty"`
+	LearnedAt   time.Time
+	Completions []Completion
+}
+
+const (
+	BundleFormat  = "cod-bundle"
+	BundleVersion = 1
+)
+
+func NewBundle(helpPages []BundleHelpPage) *Bundle {
+	return &Bundle{
+		Format:    BundleFormat,
+		Version:   BundleVersion,
+		CreatedAt: time.Unix(time.Now().Unix(), 0),
+		HelpPages: helpPages,
+	}
+}
+
+// NewBundleHelpPage converts stored help page, environment of the command is dropped.
+func NewBundleHelpPage(helpPage *HelpPage, policy Policy) BundleHelpPage {
+	command := helpPage.Command
+	command.Env = nil
+	return BundleHelpPage{
+		ExecutablePath: helpPage.ExecutablePath,
+		Command:        command,
+		CheckSum:       helpPage.CheckSum,
+		Policy:         policy,
+		LearnedAt:      helpPage.LearnedAt,
+		Completions:    helpPage.Completions,
+	}
+}
+
+// HelpPage returns help page that can be added to the storage, its command is run with env when help page is updated.
+func (p *BundleHelpPage) HelpPage(env []string) *HelpPage {
+	command := p.Command
+	command.Env = env
+	return &HelpPage{
+		ExecutablePath: p.ExecutablePath,
+		Completions:    p.Completions,
+		CheckSum:       p.CheckSum,
+		Command:        command,
+		LearnedAt:      p.LearnedAt,
+	}
+}
+
+// CheckBundleHelpPage checks that imported help page is learned from help command of its executable,
+// cod runs this command when the help page is updated.
+func CheckBundleHelpPage(p *BundleHelpPage) (err error) {
+	err = CheckHelpPage(p.HelpPage(nil))
+	if err != nil {
+		return
+	}
+	if len(p.Command.Args) == 0 || p.Command.Args[0] != p.ExecutablePath {
+		err = fmt.Errorf("command of %v doesn't start with its executable path", p.ExecutablePath)
+		return
+	}
+	if !IsHelpCommand(p.Command.Args) {
+		err = fmt.Errorf("command of %v is not a help command: %q", p.ExecutablePath, p.Command.Args)
+		return
+	}
+	if p.CheckSum == "" {
+		err = fmt.Errorf("help page of %v has no checksum", p.ExecutablePath)
+		return
+	}
+	switch p.Policy {
+	case PolicyUnknown, PolicyAsk, PolicyTrust, PolicyIgnore:
+	default:
+		err = fmt.Errorf("help page of %v has unknown policy %q", p.ExecutablePath, p.Policy)
+		return
+	}
+	return
+}
+
+// ReadBundle reads and validates bundle.
+func ReadBundle(r io.Reader) (bundle *Bundle, err error) {
+	bundle = &Bundle{}
+	err = json.NewDecoder(r).Decode(bundle)
+	if err != nil {
+		err = fmt.Errorf("cannot parse bundle: %w", err)
+		return
+	}
+	if bundle.Format != BundleFormat {
+		err = fmt.Errorf("not a cod bundle: format is %q instead of %q", bundle.Format, BundleFormat)
+		return
+	}
+	if bundle.Version < 1 || bundle.Version > BundleVersion {
+		err = fmt.Errorf("bundle has version %v, but this cod supports versions up to %v, please upgrade cod", bundle.Version, BundleVersion)
+		return
+	}
+	for i := range bundle.HelpPages {
+		err = CheckBundleHelpPage(&bundle.HelpPages[i])
+		if err != nil {
+			err = fmt.Errorf("bad help page #%v in bundle: %w", i+1, err)
+			return
+		}
+	}
+	return
+}
+
+// WriteBundle writes indented bundle.
+func WriteBundle(w io.Writer, bundle *Bundle) (err error) {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(bundle)
+	return
+}
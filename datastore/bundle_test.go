@@ -0,0 +1,108 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	helpPage := HelpPage{
+		ExecutablePath: "/usr/bin/tool",
+		Completions: []Completion{
+			{Flag: "--output", Metavar: "FILE", Description: "write <result> to FILE"},
+			{Flag: "--force", Context: FlagContext{SubCommand: []string{"run"}, Framework: "argparse"}},
+		},
+		CheckSum:  "a8cec314061364328be641e84ccc515ad1a4e9c7",
+		Command:   Command{Args: []string{"/usr/bin/tool", "--help"}, Env: []string{"TOKEN=secret"}, Dir: "/home/user"},
+		LearnedAt: time.Unix(1589711400, 0),
+	}
+
+	var buf bytes.Buffer
+	page := NewBundleHelpPage(&helpPage, PolicyTrust)
+	require.NoError(t, WriteBundle(&buf, NewBundle([]BundleHelpPage{page})))
+	require.NotContains(t, buf.String(), "secret")
+	require.Contains(t, buf.String(), "<result>")
+
+	bundle, err := ReadBundle(&buf)
+	require.NoError(t, err)
+	require.Equal(t, BundleVersion, bundle.Version)
+	require.Len(t, bundle.HelpPages, 1)
+	require.Equal(t, PolicyTrust, bundle.HelpPages[0].Policy)
+
+	// Imported command is run with environment of the importing client.
+	bundle.HelpPages[0].Command.Env = []string{"TOKEN=forged"}
+	imported := bundle.HelpPages[0].HelpPage([]string{"HOME=/home/user"})
+	require.True(t, helpPage.LearnedAt.Equal(imported.LearnedAt))
+	imported.LearnedAt = helpPage.LearnedAt
+	helpPage.Command.Env = []string{"HOME=/home/user"}
+	require.Equal(t, helpPage, *imported)
+
+	buf.Reset()
+	page = NewBundleHelpPage(&helpPage, PolicyUnknown)
+	require.NoError(t, WriteBundle(&buf, NewBundle([]BundleHelpPage{page})))
+	require.NotContains(t, buf.String(), "Policy")
+}
+
+func TestReadBadBundle(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"not json", `cod`, "cannot parse bundle"},
+		{"wrong format", `{"Format": "other", "Version": 1}`, "not a cod bundle"},
+		{"too new", `{"Format": "cod-bundle", "Version": 2}`, "please upgrade cod"},
+		{
+			"relative path",
+			`{"Format": "cod-bundle", "Version": 1, "HelpPages": [
+				{"ExecutablePath": "tool", "Command": {"Args": ["tool", "--help"]}, "CheckSum": "42"}
+			]}`,
+			"cannot be relative",
+		},
+		{
+			"foreign command",
+			`{"Format": "cod-bundle", "Version": 1, "HelpPages": [
+				{"ExecutablePath": "/bin/tool", "Command": {"Args": ["/bin/other", "--help"]}, "CheckSum": "42"}
+			]}`,
+			"doesn't start with its executable path",
+		},
+		{
+			"not a help command",
+			`{"Format": "cod-bundle", "Version": 1, "HelpPages": [
+				{"ExecutablePath": "/bin/rm", "Command": {"Args": ["/bin/rm", "-rf", "--", "--help"]}, "CheckSum": "42"}
+			]}`,
+			"is not a help command",
+		},
+		{
+			"unknown policy",
+			`{"Format": "cod-bundle", "Version": 1, "HelpPages": [
+				{"ExecutablePath": "/bin/tool", "Command": {"Args": ["/bin/tool", "--help"]}, "CheckSum": "42", "Policy": "always"}
+			]}`,
+			"unknown policy",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ReadBundle(strings.NewReader(tc.input))
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
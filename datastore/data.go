@@ -19,6 +19,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/dim-an/cod/util"
 )
@@ -52,6 +53,17 @@ type Command struct {
 type Completion struct {
 	Flag    string
 	Context FlagContext
+
+	// Name of the value required by the flag (e.g. `FILE` for `--input FILE`).
+	// Empty if flag doesn't require any value or value name is unknown.
+	Metavar string `json:",omitempty"`
+
+	// Short description of the flag or sub-command taken from the help page.
+	Description string `json:",omitempty"`
+}
+
+func (c *Completion) ValueKind() ValueKind {
+	return GuessValueKind(c.Metavar)
 }
 
 type HelpPage struct {
@@ -59,6 +71,10 @@ type HelpPage struct {
 	Completions    []Completion
 	CheckSum       string
 	Command        Command
+
+	// Time the help page was learned at. Zero time is replaced with current time when help page is added,
+	// it also means unknown time for help pages learned by older versions of cod.
+	LearnedAt time.Time
 }
 
 type FlagContext struct {
@@ -74,6 +90,19 @@ func CheckHelpPage(helpPage *HelpPage) (err error) {
 	return
 }
 
+// IsHelpCommand checks if command asks for help, i.e. it has "--help" argument before "--".
+func IsHelpCommand(args []string) bool {
+	for _, a := range args {
+		switch a {
+		case "--help":
+			return true
+		case "--":
+			return false
+		}
+	}
+	return false
+}
+
 func CheckExecutablePath(executablePath string) error {
 	if len(executablePath) == 0 {
 		return ErrAppPathIsEmpty
@@ -0,0 +1,188 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"sort"
+	"sync"
+)
+
+// CompletionIndex keeps completions of learned executables in memory.
+// Completions of each executable are stored in a trie keyed by sub-command path and then by flag prefix.
+type CompletionIndex struct {
+	mutex       sync.RWMutex
+	executables map[string]*executableIndex
+}
+
+// executableIndex is never modified after it is built, index is updated by replacing it as a whole.
+type executableIndex struct {
+	completions []Completion
+	root        *contextNode
+}
+
+type contextNode struct {
+	children map[string]*contextNode
+	flags    flagNode
+}
+
+type flagNode struct {
+	// Sorted by key.
+	children []*flagNode
+	key      byte
+	// Indexes of completions whose flag ends at this node.
+	completions []int
+}
+
+func NewCompletionIndex() *CompletionIndex {
+	return &CompletionIndex{
+		executables: make(map[string]*executableIndex),
+	}
+}
+
+// Set replaces all completions of the executable, empty completions remove the executable from index.
+func (idx *CompletionIndex) Set(executablePath string, completions []Completion) {
+	var executable *executableIndex
+	if len(completions) > 0 {
+		executable = buildExecutableIndex(completions)
+	}
+
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	if executable != nil {
+		idx.executables[executablePath] = executable
+	} else {
+		delete(idx.executables, executablePath)
+	}
+}
+
+// Get returns all completions of the executable, returned slice must not be modified.
+func (idx *CompletionIndex) Get(executablePath string) (completions []Completion, ok bool) {
+	executable, ok := idx.get(executablePath)
+	if ok {
+		completions = executable.completions
+	}
+	return
+}
+
+// Find returns completions of the executable that start with the prefix and whose context
+// matches the command (see IsCommandMatchingContext). Completions are returned in the order they were set.
+func (idx *CompletionIndex) Find(executablePath string, command []string, prefix string) (completions []Completion) {
+	executable, ok := idx.get(executablePath)
+	if !ok {
+		return
+	}
+
+	var found []int
+	executable.root.find(command, 1, prefix, &found)
+	sort.Ints(found)
+	for _, i := range found {
+		completions = append(completions, executable.completions[i])
+	}
+	return
+}
+
+func (idx *CompletionIndex) get(executablePath string) (executable *executableIndex, ok bool) {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+	executable, ok = idx.executables[executablePath]
+	return
+}
+
+func buildExecutableIndex(completions []Completion) *executableIndex {
+	executable := &executableIndex{
+		completions: completions,
+		root:        &contextNode{},
+	}
+	for i := range completions {
+		node := executable.root
+		for _, subCommand := range completions[i].Context.SubCommand {
+			child, ok := node.children[subCommand]
+			if !ok {
+				if node.children == nil {
+					node.children = make(map[string]*contextNode)
+				}
+				child = &contextNode{}
+				node.children[subCommand] = child
+			}
+			node = child
+		}
+		node.flags.insert(completions[i].Flag, i)
+	}
+	return executable
+}
+
+// find collects completions of this node and of the child nodes whose sub-commands are found in command
+// starting from position. Like IsCommandMatchingContext sub-command is matched by its first occurrence.
+func (n *contextNode) find(command []string, position int, prefix string, found *[]int) {
+	n.flags.find(prefix, found)
+	if len(n.children) == 0 {
+		return
+	}
+
+	var visited map[string]bool
+	for i := position; i < len(command); i += 1 {
+		child, ok := n.children[command[i]]
+		if !ok || visited[command[i]] {
+			continue
+		}
+		if visited == nil {
+			visited = make(map[string]bool)
+		}
+		visited[command[i]] = true
+		child.find(command, i+1, prefix, found)
+	}
+}
+
+func (n *flagNode) insert(flag string, completion int) {
+	node := n
+	for i := 0; i < len(flag); i += 1 {
+		node = node.child(flag[i], true)
+	}
+	node.completions = append(node.completions, completion)
+}
+
+func (n *flagNode) child(key byte, create bool) *flagNode {
+	pos := sort.Search(len(n.children), func(i int) bool {
+		return n.children[i].key >= key
+	})
+	if pos < len(n.children) && n.children[pos].key == key {
+		return n.children[pos]
+	}
+	if !create {
+		return nil
+	}
+	child := &flagNode{key: key}
+	n.children = append(n.children, nil)
+	copy(n.children[pos+1:], n.children[pos:])
+	n.children[pos] = child
+	return child
+}
+
+func (n *flagNode) find(prefix string, found *[]int) {
+	node := n
+	for i := 0; i < len(prefix) && node != nil; i += 1 {
+		node = node.child(prefix[i], false)
+	}
+	if node != nil {
+		node.collect(found)
+	}
+}
+
+func (n *flagNode) collect(found *[]int) {
+	*found = append(*found, n.completions...)
+	for _, child := range n.children {
+		child.collect(found)
+	}
+}
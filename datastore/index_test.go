@@ -0,0 +1,229 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func findLinear(completions []Completion, command []string, prefix string) (result []Completion) {
+	for _, c := range completions {
+		if strings.HasPrefix(c.Flag, prefix) && IsCommandMatchingContext(command, c.Context) {
+			result = append(result, c)
+		}
+	}
+	return
+}
+
+// generateCompletions makes completions of executable with sub-commands nested up to depth,
+// every sub-command has flagCount flags.
+func generateCompletions(subCommandCount, depth, flagCount int) (completions []Completion) {
+	var generate func(subCommand []string, level int)
+	generate = func(subCommand []string, level int) {
+		for i := 0; i < flagCount; i += 1 {
+			completions = append(completions, Completion{
+				Flag:    fmt.Sprintf("--flag-%v-%v", len(subCommand), i),
+				Context: FlagContext{SubCommand: subCommand},
+			})
+		}
+		if level == depth {
+			return
+		}
+		for i := 0; i < subCommandCount; i += 1 {
+			child := append(append([]string{}, subCommand...), fmt.Sprintf("cmd%v", i))
+			generate(child, level+1)
+		}
+	}
+	generate(nil, 0)
+	return
+}
+
+func TestCompletionIndexFind(t *testing.T) {
+	completions := []Completion{
+		{Flag: "--verbose"},
+		{Flag: "--version"},
+		{Flag: "-v"},
+		{Flag: "--force", Context: FlagContext{SubCommand: []string{"push"}}},
+		{Flag: "--verify", Context: FlagContext{SubCommand: []string{"push"}}},
+		{Flag: "--all", Context: FlagContext{SubCommand: []string{"remote", "update"}}},
+		{Flag: "--verbose"},
+	}
+	index := NewCompletionIndex()
+	index.Set("/bin/git", completions)
+
+	check := func(command []string, prefix string) {
+		require.Equal(t, findLinear(completions, command, prefix), index.Find("/bin/git", command, prefix),
+			"command: %v, prefix: %q", command, prefix)
+	}
+	check([]string{"git"}, "")
+	check([]string{"git"}, "--ver")
+	check([]string{"git"}, "--verbose")
+	check([]string{"git"}, "--verbose-and-more")
+	check([]string{"git", "push"}, "--")
+	check([]string{"git", "push"}, "--ver")
+	check([]string{"git", "origin", "push"}, "--f")
+	check([]string{"git", "remote"}, "--a")
+	check([]string{"git", "remote", "update"}, "--a")
+	check([]string{"git", "update", "remote"}, "--a")
+	check([]string{"git", "remote", "x", "update", "update"}, "")
+
+	require.Equal(t, []Completion{{Flag: "--verbose"}, {Flag: "--verbose"}}, index.Find("/bin/git", []string{"git"}, "--verb"))
+	require.Nil(t, index.Find("/bin/hg", []string{"hg"}, ""))
+}
+
+func TestCompletionIndexRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	words := []string{"a", "b", "c", "ab", "ba"}
+	randomWords := func(n int) (result []string) {
+		for i := 0; i < n; i += 1 {
+			result = append(result, words[rnd.Intn(len(words))])
+		}
+		return
+	}
+
+	var completions []Completion
+	for i := 0; i < 500; i += 1 {
+		completions = append(completions, Completion{
+			Flag:    "-" + strings.Join(randomWords(rnd.Intn(3)), ""),
+			Context: FlagContext{SubCommand: randomWords(rnd.Intn(3))},
+		})
+	}
+	index := NewCompletionIndex()
+	index.Set("/bin/random", completions)
+
+	for i := 0; i < 2000; i += 1 {
+		command := append([]string{"random"}, randomWords(rnd.Intn(5))...)
+		prefix := "-" + strings.Join(randomWords(rnd.Intn(2)), "")
+		require.Equal(t, findLinear(completions, command, prefix), index.Find("/bin/random", command, prefix),
+			"command: %v, prefix: %q", command, prefix)
+	}
+}
+
+func TestCompletionIndexSet(t *testing.T) {
+	index := NewCompletionIndex()
+	index.Set("/bin/foo", []Completion{{Flag: "--foo"}})
+
+	completions, ok := index.Get("/bin/foo")
+	require.True(t, ok)
+	require.Equal(t, []Completion{{Flag: "--foo"}}, completions)
+
+	index.Set("/bin/foo", []Completion{{Flag: "--bar"}})
+	require.Equal(t, []Completion{{Flag: "--bar"}}, index.Find("/bin/foo", []string{"foo"}, ""))
+
+	index.Set("/bin/foo", nil)
+	_, ok = index.Get("/bin/foo")
+	require.False(t, ok)
+}
+
+func TestIndexedStorage(t *testing.T) {
+	db := newTestSqliteStorage(t)
+	defer db.(*testSqliteStorage).CheckedClose()
+
+	_, err := db.AddHelpPage(&HelpPage{
+		ExecutablePath: "/bin/foo",
+		Command:        Command{Args: []string{"/bin/foo", "--help"}},
+		Completions:    []Completion{{Flag: "--foo"}, {Flag: "--bar"}},
+		CheckSum:       "1",
+	}, PolicyUnknown)
+	require.Nil(t, err)
+
+	storage, err := NewIndexedStorage(db)
+	require.Nil(t, err)
+	require.Equal(t, []Completion{{Flag: "--foo"}}, storage.FindCompletions("/bin/foo", []string{"foo"}, "--f"))
+
+	_, err = storage.AddHelpPage(&HelpPage{
+		ExecutablePath: "/bin/bar",
+		Command:        Command{Args: []string{"/bin/bar", "--help"}},
+		Completions:    []Completion{{Flag: "--baz"}},
+		CheckSum:       "2",
+	}, PolicyUnknown)
+	require.Nil(t, err)
+	completions, err := storage.GetCompletions("/bin/bar")
+	require.Nil(t, err)
+	require.Equal(t, []Completion{{Flag: "--baz"}}, completions)
+
+	_, err = storage.AddHelpPage(&HelpPage{
+		ExecutablePath: "/usr/local/bin/foo",
+		Command:        Command{Args: []string{"/usr/local/bin/foo", "--help"}},
+		CheckSum:       "3",
+	}, PolicyUnknown)
+	require.Nil(t, err)
+	require.Equal(t, map[string][]string{
+		"bar": {"/bin/bar"},
+		"foo": {"/bin/foo", "/usr/local/bin/foo"},
+	}, storage.GetExecutablesByName())
+	executables, err := storage.ListExecutables()
+	require.Nil(t, err)
+	require.Equal(t, []string{"/bin/bar", "/bin/foo", "/usr/local/bin/foo"}, executables)
+
+	commands, err := storage.ListCommands()
+	require.Nil(t, err)
+	require.Len(t, commands, 3)
+	for id, command := range commands {
+		if command.Args[0] != "/bin/bar" {
+			continue
+		}
+		var executablePath string
+		executablePath, err = storage.RemoveHelpPage(id)
+		require.Nil(t, err)
+		require.Equal(t, "/bin/bar", executablePath)
+	}
+	completions, err = storage.GetCompletions("/bin/bar")
+	require.Nil(t, err)
+	require.Empty(t, completions)
+	require.Equal(t, []Completion{{Flag: "--bar"}}, storage.FindCompletions("/bin/foo", []string{"foo"}, "--b"))
+	require.Equal(t, map[string][]string{
+		"foo": {"/bin/foo", "/usr/local/bin/foo"},
+	}, storage.GetExecutablesByName())
+}
+
+func benchmarkFind(b *testing.B, find func(command []string, prefix string) []Completion) {
+	command := []string{"tool", "cmd3", "cmd7", "cmd1"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i += 1 {
+		find(command, "--flag-2-1")
+	}
+}
+
+// 10 sub-commands on each of 3 levels with 20 flags each, about 22k completions.
+func BenchmarkCompletionIndexFind(b *testing.B) {
+	completions := generateCompletions(10, 3, 20)
+	index := NewCompletionIndex()
+	index.Set("/bin/tool", completions)
+	benchmarkFind(b, func(command []string, prefix string) []Completion {
+		return index.Find("/bin/tool", command, prefix)
+	})
+}
+
+func BenchmarkLinearFind(b *testing.B) {
+	completions := generateCompletions(10, 3, 20)
+	benchmarkFind(b, func(command []string, prefix string) []Completion {
+		return findLinear(completions, command, prefix)
+	})
+}
+
+func BenchmarkCompletionIndexSet(b *testing.B) {
+	completions := generateCompletions(10, 3, 20)
+	index := NewCompletionIndex()
+	b.ResetTimer()
+	for i := 0; i < b.N; i += 1 {
+		index.Set("/bin/tool", completions)
+	}
+}
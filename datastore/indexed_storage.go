@@ -0,0 +1,135 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// IndexedStorage serves completions from in-memory index, which is loaded on creation
+// and updated after every change of the underlying storage.
+type IndexedStorage struct {
+	Storage
+	index *CompletionIndex
+
+	// Paths of learned executables grouped by their names, replaced as a whole after every change of the storage.
+	executablesByName atomic.Pointer[map[string][]string]
+
+	// Writes are serialized, so index of each executable is updated in the order of storage changes.
+	writeMutex sync.Mutex
+}
+
+func NewIndexedStorage(storage Storage) (indexed *IndexedStorage, err error) {
+	index := NewCompletionIndex()
+	executablePaths, err := storage.ListExecutables()
+	if err != nil {
+		return
+	}
+	for _, executablePath := range executablePaths {
+		var completions []Completion
+		completions, err = storage.GetCompletions(executablePath)
+		if err != nil {
+			return
+		}
+		index.Set(executablePath, completions)
+	}
+
+	indexed = &IndexedStorage{
+		Storage: storage,
+		index:   index,
+	}
+	indexed.setExecutables(executablePaths)
+	return
+}
+
+func (s *IndexedStorage) setExecutables(executablePaths []string) {
+	byName := make(map[string][]string)
+	for _, executablePath := range executablePaths {
+		name := filepath.Base(executablePath)
+		byName[name] = append(byName[name], executablePath)
+	}
+	s.executablesByName.Store(&byName)
+}
+
+// ListExecutables returns sorted paths of learned executables from index.
+func (s *IndexedStorage) ListExecutables() (paths []string, err error) {
+	for _, executablePaths := range s.GetExecutablesByName() {
+		paths = append(paths, executablePaths...)
+	}
+	sort.Strings(paths)
+	return
+}
+
+// GetExecutablesByName returns sorted paths of learned executables grouped by their names.
+// Returned map is shared and must not be modified.
+func (s *IndexedStorage) GetExecutablesByName() map[string][]string {
+	return *s.executablesByName.Load()
+}
+
+// GetCompletions returns completions of the executable from index.
+// Returned slice is shared and must not be modified.
+func (s *IndexedStorage) GetCompletions(executablePath string) (completions []Completion, err error) {
+	completions, _ = s.index.Get(executablePath)
+	return
+}
+
+// FindCompletions returns completions of the executable that start with the prefix and match the command.
+func (s *IndexedStorage) FindCompletions(executablePath string, command []string, prefix string) []Completion {
+	return s.index.Find(executablePath, command, prefix)
+}
+
+func (s *IndexedStorage) AddHelpPage(helpPage *HelpPage, policy Policy) (status AddHelpPageStatus, err error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	status, err = s.Storage.AddHelpPage(helpPage, policy)
+	if err != nil {
+		return
+	}
+	err = s.reindex(helpPage.ExecutablePath)
+	return
+}
+
+func (s *IndexedStorage) RemoveHelpPage(helpPageId int64) (executablePath string, err error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	executablePath, err = s.Storage.RemoveHelpPage(helpPageId)
+	if err != nil || executablePath == "" {
+		return
+	}
+	err = s.reindex(executablePath)
+	return
+}
+
+// reindex replaces completions of the executable in index with committed ones.
+func (s *IndexedStorage) reindex(executablePath string) (err error) {
+	completions, err := s.Storage.GetCompletions(executablePath)
+	if err != nil {
+		return
+	}
+	s.index.Set(executablePath, completions)
+
+	// Writes are rare, so the list is simply reread.
+	executablePaths, err := s.Storage.ListExecutables()
+	if err != nil {
+		return
+	}
+	s.setExecutables(executablePaths)
+	return
+}
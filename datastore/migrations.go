@@ -0,0 +1,156 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// migration upgrades schema from version-1 to version.
+type migration struct {
+	version     int
+	description string
+	statements  []string
+}
+
+// migrations are applied in order, each one in its own transaction together with update of `PRAGMA user_version`.
+// Fresh database has version 0, so it is created by applying all of them.
+// Append new migrations to the end, never change the ones that are released.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create initial schema",
+		statements: []string{
+			`create table Completion (
+				CompletionId   integer not null primary key autoincrement,
+				HelpPageId     integer not null,
+				Flag           text not null,
+				Context        text,
+				foreign key (HelpPageId) references HelpPage(HelpPageId)
+			)`,
+			`create index Completion_SourceId ON Completion (HelpPageId)`,
+			`create table HelpPage (
+				HelpPageId          integer not null primary key autoincrement,
+				ExecutablePath      text,
+				HelpTextCheckSum    text,
+				CommandArgsCheckSum text,
+				CommandJson         text,
+				Policy              text,
+				unique              (ExecutablePath, HelpTextCheckSum),
+				unique              (ExecutablePath, CommandArgsCheckSum)
+			)`,
+			`create index HelpPage_ExecutablePath ON HelpPage (ExecutablePath)`,
+			`create index HelpPage_ExecutablePath_HelpTextCheckSum ON HelpPage (ExecutablePath, HelpTextCheckSum)`,
+			`create index HelpPage_ExecutablePath_CommandArgsCheckSum ON HelpPage (ExecutablePath, CommandArgsCheckSum)`,
+		},
+	},
+	{
+		version:     2,
+		description: "add metavars of flags",
+		statements: []string{
+			`alter table Completion add column Metavar text`,
+		},
+	},
+	{
+		version:     3,
+		description: "add descriptions of flags",
+		statements: []string{
+			`alter table Completion add column Description text`,
+		},
+	},
+	{
+		version:     4,
+		description: "add time help pages are learned at",
+		statements: []string{
+			// Unix time in seconds, null for help pages learned before this migration.
+			`alter table HelpPage add column LearnedAt integer`,
+		},
+	},
+}
+
+// CurrentSchemaVersion is the version of database schema created by this binary.
+var CurrentSchemaVersion = len(migrations)
+
+// DatabaseTooNewError is returned when database is created by newer version of cod.
+type DatabaseTooNewError struct {
+	FileName string
+	Version  int
+}
+
+func (e *DatabaseTooNewError) Error() string {
+	return fmt.Sprintf(
+		"database %v has schema version %v, but this cod supports versions up to %v, please upgrade cod",
+		e.FileName, e.Version, CurrentSchemaVersion,
+	)
+}
+
+// BackupFileName returns name of the copy of the database made before it is migrated from the version.
+func BackupFileName(fileName string, version int, t time.Time) string {
+	return fmt.Sprintf("%v.v%v-%v.backup", fileName, version, t.Format("20060102-150405"))
+}
+
+func getSchemaVersion(db *sql.DB) (version int, err error) {
+	err = db.QueryRow("PRAGMA user_version").Scan(&version)
+	return
+}
+
+// migrateDatabase applies migrations the database hasn't seen yet.
+// Copy of existing database is saved next to it before the first migration is applied.
+func migrateDatabase(db *sql.DB, fileName string) (err error) {
+	version, err := getSchemaVersion(db)
+	if err != nil {
+		return
+	}
+	if version > CurrentSchemaVersion {
+		err = &DatabaseTooNewError{FileName: fileName, Version: version}
+		return
+	}
+	if version == CurrentSchemaVersion {
+		return
+	}
+
+	if version > 0 {
+		backupFileName := BackupFileName(fileName, version, time.Now())
+		slog.Info("Backing up database before migration", "path", backupFileName)
+		// Unlike copying the file, VACUUM INTO makes consistent copy including changes kept in WAL.
+		_, err = db.Exec("VACUUM INTO ?", backupFileName)
+		if err != nil {
+			err = fmt.Errorf("cannot back up database to %v: %w", backupFileName, err)
+			return
+		}
+	}
+
+	for _, m := range migrations[version:] {
+		slog.Info("Migrating database", "version", m.version, "migration", m.description)
+		err = withTransaction(db, func(tx *sql.Tx) error {
+			for _, stmt := range m.statements {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			// Pragma doesn't support parameters.
+			_, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", m.version))
+			return err
+		})
+		if err != nil {
+			err = fmt.Errorf("cannot migrate database to version %v (%v): %w", m.version, m.description, err)
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,184 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// createFixtureDatabase creates database from testdata/schema-v<version>.sql,
+// every schema version but the current one must have a fixture.
+func createFixtureDatabase(t *testing.T, version int) (fileName string) {
+	script, err := os.ReadFile(filepath.Join("testdata", fmt.Sprintf("schema-v%v.sql", version)))
+	require.NoError(t, err)
+
+	fileName = filepath.Join(t.TempDir(), "db.sqlite3")
+	db, err := sql.Open("sqlite3", fileName)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+	_, err = db.Exec(string(script))
+	require.NoError(t, err)
+	return
+}
+
+func readSchemaVersion(t *testing.T, fileName string) int {
+	db, err := sql.Open("sqlite3", fileName)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+	version, err := getSchemaVersion(db)
+	require.NoError(t, err)
+	return version
+}
+
+func sortedFlags(completions []Completion) (flags []string) {
+	for _, c := range completions {
+		flags = append(flags, c.Flag)
+	}
+	sort.Strings(flags)
+	return
+}
+
+func TestMigrateFixtures(t *testing.T) {
+	for version := 1; version < CurrentSchemaVersion; version += 1 {
+		t.Run(fmt.Sprintf("v%v", version), func(t *testing.T) {
+			fileName := createFixtureDatabase(t, version)
+
+			storage, err := NewSqliteStorage(fileName)
+			require.NoError(t, err)
+			defer func() {
+				require.NoError(t, storage.Close())
+			}()
+			require.Equal(t, CurrentSchemaVersion, readSchemaVersion(t, fileName))
+
+			backups, err := filepath.Glob(fileName + ".v*.backup")
+			require.NoError(t, err)
+			require.Len(t, backups, 1)
+			require.Equal(t, version, readSchemaVersion(t, backups[0]))
+
+			executables, err := storage.ListExecutables()
+			require.NoError(t, err)
+			require.Equal(t, []string{"/opt/bin/other", "/usr/bin/tool"}, executables)
+
+			completions, err := storage.GetCompletions("/usr/bin/tool")
+			require.NoError(t, err)
+			require.Equal(t, []string{"--force", "--output", "--verbose"}, sortedFlags(completions))
+			for _, c := range completions {
+				if c.Flag == "--force" {
+					require.Equal(t, FlagContext{SubCommand: []string{"run"}, Framework: "argparse"}, c.Context)
+				}
+				if c.Flag == "--output" && version >= 2 {
+					require.Equal(t, "FILE", c.Metavar)
+				}
+			}
+
+			commands, err := storage.ListCommands()
+			require.NoError(t, err)
+			require.Len(t, commands, 3)
+			require.Equal(t, []string{"/usr/bin/tool", "--help"}, commands[1].Args)
+
+			policy, err := storage.GetCommandPolicy([]string{"/opt/bin/other", "-h"})
+			require.NoError(t, err)
+			require.Equal(t, PolicyIgnore, policy)
+
+			// Columns added by migrations are written and read.
+			_, err = storage.AddHelpPage(&HelpPage{
+				ExecutablePath: "/usr/bin/new",
+				Completions:    []Completion{{Flag: "--input", Metavar: "FILE", Description: "read FILE"}},
+				Command:        Command{Args: []string{"/usr/bin/new", "--help"}},
+				CheckSum:       "d4f6b8a0c2e4f6b8a0c2e4f6b8a0c2e4f6b8a0c2",
+			}, PolicyTrust)
+			require.NoError(t, err)
+			completions, err = storage.GetCompletions("/usr/bin/new")
+			require.NoError(t, err)
+			require.Equal(t, []Completion{{Flag: "--input", Metavar: "FILE", Description: "read FILE"}}, completions)
+		})
+	}
+}
+
+func TestFreshDatabaseIsNotBackedUp(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "db.sqlite3")
+	storage, err := NewSqliteStorage(fileName)
+	require.NoError(t, err)
+	require.NoError(t, storage.Close())
+
+	require.Equal(t, CurrentSchemaVersion, readSchemaVersion(t, fileName))
+	backups, err := filepath.Glob(fileName + ".v*.backup")
+	require.NoError(t, err)
+	require.Empty(t, backups)
+}
+
+func TestDatabaseTooNew(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "db.sqlite3")
+	storage, err := NewSqliteStorage(fileName)
+	require.NoError(t, err)
+	require.NoError(t, storage.Close())
+
+	db, err := sql.Open("sqlite3", fileName)
+	require.NoError(t, err)
+	_, err = db.Exec(fmt.Sprintf("PRAGMA user_version = %d", CurrentSchemaVersion+1))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = NewSqliteStorage(fileName)
+	var tooNew *DatabaseTooNewError
+	require.True(t, errors.As(err, &tooNew), "unexpected error: %v", err)
+	require.Equal(t, CurrentSchemaVersion+1, tooNew.Version)
+	require.Contains(t, err.Error(), "please upgrade cod")
+}
+
+func TestFailedMigrationIsRolledBack(t *testing.T) {
+	oldMigrations := migrations
+	oldCurrentSchemaVersion := CurrentSchemaVersion
+	defer func() {
+		migrations = oldMigrations
+		CurrentSchemaVersion = oldCurrentSchemaVersion
+	}()
+	migrations = append(migrations[:len(migrations):len(migrations)], migration{
+		version:     oldCurrentSchemaVersion + 1,
+		description: "broken migration",
+		statements: []string{
+			`alter table Completion add column Extra text`,
+			`alter table NoSuchTable add column Extra text`,
+		},
+	})
+	CurrentSchemaVersion = len(migrations)
+
+	fileName := createFixtureDatabase(t, 1)
+	_, err := NewSqliteStorage(fileName)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "broken migration")
+
+	// Migrations before the broken one are kept.
+	require.Equal(t, oldCurrentSchemaVersion, readSchemaVersion(t, fileName))
+	db, err := sql.Open("sqlite3", fileName)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+	_, err = db.Exec("select Extra from Completion")
+	require.Error(t, err)
+}
@@ -18,41 +18,59 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"time"
 
 	"github.com/dim-an/cod/util"
 	_ "github.com/ncruces/go-sqlite3/driver"
 )
 
-var CurrentSchemaVersion = 1
-
 type Storage interface {
 	GetCommandPolicy(args []string) (policy Policy, err error)
 
+	// GetCommandLearnedAt returns time the help page of the command was learned at.
+	// Found help page might have zero time if it was learned by older version of cod.
+	GetCommandLearnedAt(args []string) (learnedAt time.Time, found bool, err error)
+
 	GetAllCompletions() (pages []HelpPage, err error)
 	GetCompletions(path string) (completions []Completion, err error)
 
+	// ListExecutables returns sorted paths of all executables that have help pages.
+	ListExecutables() (paths []string, err error)
+
 	AddHelpPage(helpPage *HelpPage, policy Policy) (status AddHelpPageStatus, err error)
 
 	// NB. This command might return null pointers in case some help page is broken.
 	ListCommands() (result map[int64]*Command, err error)
 
+	// GetHelpPage returns help page with its own completions and policy, helpPage is nil if there is no such page.
+	GetHelpPage(helpPageId int64) (helpPage *HelpPage, policy Policy, err error)
+
 	RemoveHelpPage(commandId int64) (path string, err error)
 
 	Close() error
 }
 
+// Size of the pool of connections used by readers.
+const readConnectionCount = 4
+
+// NewSqliteStorage opens database in WAL mode. Writes are serialized over a single connection,
+// while reads use a separate pool and never wait for writes to complete.
 func NewSqliteStorage(fileName string) (storage Storage, err error) {
-	db, err := sql.Open("sqlite3", fileName)
-	if err != nil {
-		return
-	}
-	err = db.Ping()
+	// Write transactions take the lock at once, so they can't fail upgrading read lock to write one.
+	db, err := openSqlite(fileName, 1, "immediate", "journal_mode(wal)")
 	if err != nil {
 		return
 	}
+	defer func() {
+		if err != nil {
+			_ = db.Close()
+		}
+	}()
 
-	err = updateSchema(db)
+	err = migrateDatabase(db, fileName)
 	if err != nil {
 		return
 	}
@@ -62,17 +80,46 @@ func NewSqliteStorage(fileName string) (storage Storage, err error) {
 	if err != nil {
 		return
 	}
-	if foreignKey == "" {
+	if foreignKey != "1" {
 		err = fmt.Errorf("sqlite doesn't support foreign keys")
 		return
 	}
-	_, err = db.Exec("pragma foreign_keys = on;")
+
+	readDb, err := openSqlite(fileName, readConnectionCount, "deferred", "query_only(1)")
 	if err != nil {
 		return
 	}
 
 	storage = &sqliteStorage{
-		db: db,
+		db:     db,
+		readDb: readDb,
+	}
+	return
+}
+
+// openSqlite opens connection pool, pragmas are applied to every connection of the pool.
+func openSqlite(fileName string, maxConnections int, txLock string, pragmas ...string) (db *sql.DB, err error) {
+	query := url.Values{}
+	query.Add("_txlock", txLock)
+	for _, pragma := range append([]string{"busy_timeout(5000)", "foreign_keys(1)"}, pragmas...) {
+		query.Add("_pragma", pragma)
+	}
+	// Relative path would be taken for URI authority.
+	absPath, err := filepath.Abs(fileName)
+	if err != nil {
+		return
+	}
+	dsn := (&url.URL{Scheme: "file", Path: absPath, RawQuery: query.Encode()}).String()
+
+	db, err = sql.Open("sqlite3", dsn)
+	if err != nil {
+		return
+	}
+	db.SetMaxOpenConns(maxConnections)
+	err = db.Ping()
+	if err != nil {
+		_ = db.Close()
+		db = nil
 	}
 	return
 }
@@ -86,7 +133,7 @@ func withTransaction(db *sql.DB, f func(tx *sql.Tx) error) (err error) {
 	if err != nil {
 		err2 := tx.Rollback()
 		if err2 != nil {
-			log.Printf("error occurred while transaction rollback: %v", err2)
+			slog.Error("Transaction rollback failed", "error", err2)
 		}
 		return
 	}
@@ -96,22 +143,43 @@ func withTransaction(db *sql.DB, f func(tx *sql.Tx) error) (err error) {
 
 func getCompletionsForExecutable(tx *sql.Tx, executablePath string) (completions []Completion, err error) {
 	completionRows, err := tx.Query(`
-				select Completion.Flag, Completion.Context
+				select Completion.Flag, Completion.Context, Completion.Metavar, Completion.Description
 				from Completion inner join HelpPage on Completion.HelpPageId = HelpPage.HelpPageId
 				where HelpPage.ExecutablePath = ?
 			`, executablePath)
 	if err != nil {
 		return
 	}
+	completions, err = scanCompletions(completionRows)
+	return
+}
+
+func getCompletionsForHelpPage(tx *sql.Tx, helpPageId int64) (completions []Completion, err error) {
+	completionRows, err := tx.Query(`
+				select Flag, Context, Metavar, Description from Completion
+				where HelpPageId = ? order by CompletionId
+			`, helpPageId)
+	if err != nil {
+		return
+	}
+	completions, err = scanCompletions(completionRows)
+	return
+}
+
+func scanCompletions(completionRows *sql.Rows) (completions []Completion, err error) {
 	defer func() {
 		_ = completionRows.Close()
 	}()
 
 	for completionRows.Next() {
 		var contextBytes sql.NullString
+		var metavar sql.NullString
+		var description sql.NullString
 		completion := Completion{}
-		err = completionRows.Scan(&completion.Flag, &contextBytes)
+		err = completionRows.Scan(&completion.Flag, &contextBytes, &metavar, &description)
 		util.VerifyPanic(err)
+		completion.Metavar = metavar.String
+		completion.Description = description.String
 		if contextBytes.Valid {
 			err = json.Unmarshal([]byte(contextBytes.String), &completion.Context)
 			if err != nil {
@@ -125,7 +193,7 @@ func getCompletionsForExecutable(tx *sql.Tx, executablePath string) (completions
 }
 
 func (s *sqliteStorage) GetCompletions(executablePath string) (completions []Completion, err error) {
-	err = withTransaction(s.db, func(tx *sql.Tx) (err error) {
+	err = withTransaction(s.readDb, func(tx *sql.Tx) (err error) {
 		var cur []Completion
 		cur, err = getCompletionsForExecutable(tx, executablePath)
 		if err != nil {
@@ -146,9 +214,13 @@ func (s *sqliteStorage) AddHelpPage(helpPage *HelpPage, policy Policy) (status A
 	err = withTransaction(s.db, func(tx *sql.Tx) (err error) {
 		commandChecksum := util.HashStrings(helpPage.Command.Args)
 
+		if helpPage.LearnedAt.IsZero() {
+			helpPage.LearnedAt = time.Unix(time.Now().Unix(), 0)
+		}
+
 		if policy == PolicyUnknown {
 			checkSum := util.HashStrings(helpPage.Command.Args)
-			err = s.db.QueryRow(
+			err = tx.QueryRow(
 				`select Policy from HelpPage where CommandArgsCheckSum = ?`,
 				checkSum,
 			).Scan(&policy)
@@ -183,7 +255,7 @@ func (s *sqliteStorage) AddHelpPage(helpPage *HelpPage, policy Policy) (status A
 }
 
 func (s *sqliteStorage) ListCommands() (result map[int64]*Command, err error) {
-	rows, err := s.db.Query(`
+	rows, err := s.readDb.Query(`
 		select HelpPageId, CommandJson from HelpPage
 	`)
 	if err != nil {
@@ -206,7 +278,7 @@ func (s *sqliteStorage) ListCommands() (result map[int64]*Command, err error) {
 		var command Command
 		err = json.Unmarshal(commandJson, &command)
 		if err != nil {
-			log.Printf("HelpPage %v has broken commandJson field: %v", helpPageId, err)
+			slog.Warn("Help page has broken commandJson field", "helpPageId", helpPageId, "error", err)
 			result[helpPageId] = nil
 		} else {
 			result[helpPageId] = &command
@@ -216,8 +288,72 @@ func (s *sqliteStorage) ListCommands() (result map[int64]*Command, err error) {
 	return
 }
 
+func (s *sqliteStorage) GetHelpPage(helpPageId int64) (helpPage *HelpPage, policy Policy, err error) {
+	err = withTransaction(s.readDb, func(tx *sql.Tx) (err error) {
+		page := HelpPage{}
+		var commandJson []byte
+		var learnedAt sql.NullInt64
+		err = tx.QueryRow(`
+			select ExecutablePath, HelpTextCheckSum, CommandJson, Policy, LearnedAt from HelpPage where HelpPageId = ?
+			`, helpPageId,
+		).Scan(&page.ExecutablePath, &page.CheckSum, &commandJson, &policy, &learnedAt)
+		if err == sql.ErrNoRows {
+			err = nil
+			return
+		} else if err != nil {
+			return
+		}
+		err = json.Unmarshal(commandJson, &page.Command)
+		if err != nil {
+			err = fmt.Errorf("help page %v has broken command: %w", helpPageId, err)
+			return
+		}
+		if learnedAt.Valid {
+			page.LearnedAt = time.Unix(learnedAt.Int64, 0)
+		}
+		page.Completions, err = getCompletionsForHelpPage(tx, helpPageId)
+		if err != nil {
+			return
+		}
+		helpPage = &page
+		return
+	})
+	return
+}
+
+func (s *sqliteStorage) ListExecutables() (paths []string, err error) {
+	rows, err := s.readDb.Query(`
+		select distinct ExecutablePath from HelpPage order by ExecutablePath
+	`)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var executablePath string
+		err = rows.Scan(&executablePath)
+		if err != nil {
+			return
+		}
+		paths = append(paths, executablePath)
+	}
+	err = rows.Err()
+	return
+}
+
 func (s *sqliteStorage) RemoveHelpPage(helpPageId int64) (executablePath string, err error) {
 	err = withTransaction(s.db, func(tx *sql.Tx) (err error) {
+		err = tx.QueryRow(`select ExecutablePath from HelpPage where HelpPageId = ?`, helpPageId).Scan(&executablePath)
+		if err == sql.ErrNoRows {
+			// Nothing to remove.
+			err = nil
+			return
+		} else if err != nil {
+			return
+		}
 		err = removeHelpPage(tx, helpPageId)
 		if err != nil {
 			return
@@ -229,7 +365,7 @@ func (s *sqliteStorage) RemoveHelpPage(helpPageId int64) (executablePath string,
 
 func (s *sqliteStorage) GetCommandPolicy(args []string) (policy Policy, err error) {
 	checkSum := util.HashStrings(args)
-	err = s.db.QueryRow(`select Policy from HelpPage where CommandArgsCheckSum = ?`, checkSum).Scan(&policy)
+	err = s.readDb.QueryRow(`select Policy from HelpPage where CommandArgsCheckSum = ?`, checkSum).Scan(&policy)
 	if err == sql.ErrNoRows {
 		err = nil
 		policy = PolicyUnknown
@@ -239,8 +375,25 @@ func (s *sqliteStorage) GetCommandPolicy(args []string) (policy Policy, err erro
 	return
 }
 
+func (s *sqliteStorage) GetCommandLearnedAt(args []string) (learnedAt time.Time, found bool, err error) {
+	checkSum := util.HashStrings(args)
+	var unixTime sql.NullInt64
+	err = s.readDb.QueryRow(`select LearnedAt from HelpPage where CommandArgsCheckSum = ?`, checkSum).Scan(&unixTime)
+	if err == sql.ErrNoRows {
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+	found = true
+	if unixTime.Valid {
+		learnedAt = time.Unix(unixTime.Int64, 0)
+	}
+	return
+}
+
 func (s *sqliteStorage) GetAllCompletions() (pages []HelpPage, err error) {
-	rows, err := s.db.Query(`
+	rows, err := s.readDb.Query(`
 		select HelpPage.ExecutablePath, Completion.Flag
 		from Completion
 		inner join HelpPage on (HelpPage.HelpPageId = Completion.HelpPageId)
@@ -284,16 +437,24 @@ func (s *sqliteStorage) GetAllCompletions() (pages []HelpPage, err error) {
 	return
 }
 func (s *sqliteStorage) Close() error {
-	return s.db.Close()
+	readErr := s.readDb.Close()
+	err := s.db.Close()
+	if err == nil {
+		err = readErr
+	}
+	return err
 }
 
 type sqliteStorage struct {
+	// Single connection used for writes.
 	db *sql.DB
+	// Pool of read-only connections.
+	readDb *sql.DB
 }
 
 func insertCompletions(tx *sql.Tx, helpPageId int64, completions []Completion) (err error) {
 	completionStatement, err := tx.Prepare(`
-		insert into Completion(HelpPageId, Flag, Context) values (?, ?, ?)
+		insert into Completion(HelpPageId, Flag, Context, Metavar, Description) values (?, ?, ?, ?, ?)
 	`)
 	if err != nil {
 		return
@@ -305,7 +466,7 @@ func insertCompletions(tx *sql.Tx, helpPageId int64, completions []Completion) (
 		if err != nil {
 			return
 		}
-		_, err = completionStatement.Exec(helpPageId, completion.Flag, contextBytes)
+		_, err = completionStatement.Exec(helpPageId, completion.Flag, contextBytes, completion.Metavar, completion.Description)
 		if err != nil {
 			return
 		}
@@ -394,14 +555,16 @@ func insertHelpPage(tx *sql.Tx, executablePath string, rowIdToReplace *int64, co
 			                     HelpTextCheckSum,
 			                     CommandArgsCheckSum,
 			                     CommandJson,
-			                     Policy
-			) values (?, ?, ?, ?, ?, ?)
+			                     Policy,
+			                     LearnedAt
+			) values (?, ?, ?, ?, ?, ?, ?)
 		`, rowIdToReplace,
 		executablePath,
 		helpPage.CheckSum,
 		commandChecksum,
 		helpPageCommandJson,
-		policy)
+		policy,
+		helpPage.LearnedAt.Unix())
 	if err != nil {
 		return
 	}
@@ -431,60 +594,3 @@ func removeHelpPage(tx *sql.Tx, helpPageId int64) (err error) {
 	}
 	return
 }
-
-func updateSchema(db *sql.DB) (err error) {
-	userVersion := -1
-	err = db.QueryRow("PRAGMA user_version;").Scan(&userVersion)
-	if err != nil {
-		return
-	}
-
-	if userVersion != 0 {
-		err = migrateSchema(userVersion, db)
-		return
-	}
-
-	schemaStatements := []string{
-		`create table Completion (
-			CompletionId   integer not null primary key autoincrement,
-			HelpPageId     integer not null,
-			Flag           text not null,
-			Context        text,
-			foreign key (HelpPageId) references HelpPage(HelpPageId)
-		)`,
-		`create index Completion_SourceId ON Completion (HelpPageId)`,
-		`create table HelpPage (
-		    HelpPageId          integer not null primary key autoincrement,
-			ExecutablePath      text,
-			HelpTextCheckSum    text,
-			CommandArgsCheckSum text,
-			CommandJson         text,
-			Policy              text,
-			unique              (ExecutablePath, HelpTextCheckSum),
-			unique              (ExecutablePath, CommandArgsCheckSum)
-		)`,
-		`create index HelpPage_ExecutablePath ON HelpPage (ExecutablePath)`,
-		`create index HelpPage_ExecutablePath_HelpTextCheckSum ON HelpPage (ExecutablePath, HelpTextCheckSum)`,
-		`create index HelpPage_ExecutablePath_CommandArgsCheckSum ON HelpPage (ExecutablePath, CommandArgsCheckSum)`,
-		`PRAGMA user_version = 1`,
-	}
-	err = withTransaction(db, func(tx *sql.Tx) error {
-		for _, stmt := range schemaStatements {
-			if _, err := tx.Exec(stmt); err != nil {
-				return err
-			}
-		}
-		return nil
-	})
-	return
-}
-
-func migrateSchema(userVersion int, _ *sql.DB) (err error) {
-	switch userVersion {
-	case CurrentSchemaVersion:
-		break
-	default:
-		panic(fmt.Errorf("unknown db version: %v", userVersion))
-	}
-	return
-}
@@ -22,6 +22,7 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/dim-an/cod/util"
 	"github.com/stretchr/testify/require"
@@ -74,7 +75,7 @@ func TestCRUD(t *testing.T) {
 			ExecutablePath: "/my-test-command",
 			Completions: []Completion{
 				{Flag: "foo"},
-				{Flag: "bar"},
+				{Flag: "bar", Metavar: "FILE", Description: "read bar from FILE"},
 				{Flag: "baz"},
 				{Flag: "qux"},
 			},
@@ -106,7 +107,7 @@ func TestCRUD(t *testing.T) {
 	require.Equal(t,
 		[]Completion{
 			{Flag: "foo"},
-			{Flag: "bar"},
+			{Flag: "bar", Metavar: "FILE", Description: "read bar from FILE"},
 			{Flag: "baz"},
 			{Flag: "qux"},
 		},
@@ -201,6 +202,10 @@ func TestListCommands(t *testing.T) {
 	}
 
 	require.Equal(t, []*Command{&command2, &command1, &command3}, toValues(&commandMap))
+
+	executables, err := db.ListExecutables()
+	require.Nil(t, err)
+	require.Equal(t, []string{"/bar", "/foo"}, executables)
 }
 
 type SortableCommands []*Command
@@ -258,3 +263,61 @@ func (cs SortableCommands) Less(i, j int) bool {
 func (cs SortableCommands) Swap(i, j int) {
 	cs[i], cs[j] = cs[j], cs[i]
 }
+
+func TestGetHelpPage(t *testing.T) {
+	db := newTestSqliteStorage(t)
+
+	learnedAt := time.Date(2020, 5, 17, 10, 30, 0, 0, time.UTC)
+	helpPage := HelpPage{
+		ExecutablePath: "/foo",
+		Completions: []Completion{
+			{Flag: "--input", Metavar: "FILE", Description: "read FILE"},
+			{Flag: "--force", Context: FlagContext{SubCommand: []string{"run"}}},
+		},
+		CheckSum:  "100500",
+		Command:   Command{Args: []string{"/foo", "--help"}, Env: []string{"PATH=/bin"}, Dir: "/tmp"},
+		LearnedAt: learnedAt,
+	}
+	_, err := db.AddHelpPage(&helpPage, PolicyTrust)
+	require.Nil(t, err)
+
+	commands, err := db.ListCommands()
+	require.Nil(t, err)
+	require.Len(t, commands, 1)
+	var id int64
+	for id = range commands {
+	}
+
+	stored, policy, err := db.GetHelpPage(id)
+	require.Nil(t, err)
+	require.Equal(t, PolicyTrust, policy)
+	require.True(t, learnedAt.Equal(stored.LearnedAt))
+	stored.LearnedAt = helpPage.LearnedAt
+	require.Equal(t, helpPage, *stored)
+
+	stored, _, err = db.GetHelpPage(id + 1)
+	require.Nil(t, err)
+	require.Nil(t, stored)
+
+	storedLearnedAt, found, err := db.GetCommandLearnedAt([]string{"/foo", "--help"})
+	require.Nil(t, err)
+	require.True(t, found)
+	require.True(t, learnedAt.Equal(storedLearnedAt))
+
+	_, found, err = db.GetCommandLearnedAt([]string{"/foo", "-h"})
+	require.Nil(t, err)
+	require.False(t, found)
+
+	// Help pages without time are learned now.
+	before := time.Now().Add(-time.Second)
+	_, err = db.AddHelpPage(&HelpPage{
+		ExecutablePath: "/bar",
+		CheckSum:       "42",
+		Command:        Command{Args: []string{"/bar", "--help"}},
+	}, PolicyUnknown)
+	require.Nil(t, err)
+	storedLearnedAt, found, err = db.GetCommandLearnedAt([]string{"/bar", "--help"})
+	require.Nil(t, err)
+	require.True(t, found)
+	require.True(t, storedLearnedAt.After(before))
+}
@@ -0,0 +1,46 @@
+-- Database as it was written by cod with schema version 2.
+create table Completion (
+	CompletionId   integer not null primary key autoincrement,
+	HelpPageId     integer not null,
+	Flag           text not null,
+	Context        text, Metavar text,
+	foreign key (HelpPageId) references HelpPage(HelpPageId)
+);
+create index Completion_SourceId ON Completion (HelpPageId);
+create table HelpPage (
+	HelpPageId          integer not null primary key autoincrement,
+	ExecutablePath      text,
+	HelpTextCheckSum    text,
+	CommandArgsCheckSum text,
+	CommandJson         text,
+	Policy              text,
+	unique              (ExecutablePath, HelpTextCheckSum),
+	unique              (ExecutablePath, CommandArgsCheckSum)
+);
+create index HelpPage_ExecutablePath ON HelpPage (ExecutablePath);
+create index HelpPage_ExecutablePath_HelpTextCheckSum ON HelpPage (ExecutablePath, HelpTextCheckSum);
+create index HelpPage_ExecutablePath_CommandArgsCheckSum ON HelpPage (ExecutablePath, CommandArgsCheckSum);
+
+insert into HelpPage values (
+	1, '/usr/bin/tool', 'a8cec314061364328be641e84ccc515ad1a4e9c7',
+	'6755b91e1bb865ca3c7cfe855ca42ed2a9ac100ec57e959ba31110cc79bb2acf',
+	'{"Args":["/usr/bin/tool","--help"],"Env":["PATH=/usr/bin"],"Dir":"/home/user"}', 'trust'
+);
+insert into Completion values (1, 1, '--verbose', '{}', '');
+insert into Completion values (2, 1, '--output', '{}', 'FILE');
+
+insert into HelpPage values (
+	2, '/usr/bin/tool', 'b2f1a3c5d7e9f1a3c5d7e9f1a3c5d7e9f1a3c5d7',
+	'3c400d4440ffa5e9e91216d5a7935a371e69bd1dbb13186d709e277f044f4e5a',
+	'{"Args":["/usr/bin/tool","run","--help"],"Env":["PATH=/usr/bin"],"Dir":"/home/user"}', 'trust'
+);
+insert into Completion values (3, 2, '--force', '{"sub-command":["run"],"framework":"argparse"}', '');
+
+insert into HelpPage values (
+	3, '/opt/bin/other', 'c3e5a7b9d1f3e5a7b9d1f3e5a7b9d1f3e5a7b9d1',
+	'018de4fea03f96fc39bf10119f47faad3013cc52f86141b5ca05423d55508a6f',
+	'{"Args":["/opt/bin/other","-h"],"Env":[],"Dir":"/tmp"}', 'ignore'
+);
+insert into Completion values (4, 3, '-q', null, null);
+
+PRAGMA user_version = 2;
@@ -0,0 +1,127 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"strings"
+)
+
+// ValueKind describes what kind of value is expected by a flag.
+// Shells use it to decide which native completer (if any) should be used.
+type ValueKind string
+
+const (
+	// Nothing is known about the value, shells fall back to file completion.
+	ValueKindUnknown   = ValueKind("")
+	ValueKindFile      = ValueKind("file")
+	ValueKindDirectory = ValueKind("directory")
+	ValueKindHostname  = ValueKind("hostname")
+	ValueKindUser      = ValueKind("user")
+	ValueKindGroup     = ValueKind("group")
+	ValueKindSignal    = ValueKind("signal")
+	ValueKindInterface = ValueKind("interface")
+	// Value is known to be something that cannot be completed (number, size, port etc).
+	ValueKindPlain = ValueKind("plain")
+)
+
+var metavarValueKinds = map[string]ValueKind{
+	"file":      ValueKindFile,
+	"files":     ValueKindFile,
+	"filename":  ValueKindFile,
+	"filenames": ValueKindFile,
+	"filepath":  ValueKindFile,
+	"path":      ValueKindFile,
+	"paths":     ValueKindFile,
+	"config":    ValueKindFile,
+	"conf":      ValueKindFile,
+	"script":    ValueKindFile,
+	"input":     ValueKindFile,
+	"output":    ValueKindFile,
+	"logfile":   ValueKindFile,
+
+	"dir":         ValueKindDirectory,
+	"dirs":        ValueKindDirectory,
+	"directory":   ValueKindDirectory,
+	"directories": ValueKindDirectory,
+	"folder":      ValueKindDirectory,
+	"workdir":     ValueKindDirectory,
+
+	"host":     ValueKindHostname,
+	"hosts":    ValueKindHostname,
+	"hostname": ValueKindHostname,
+	"server":   ValueKindHostname,
+
+	"user":     ValueKindUser,
+	"username": ValueKindUser,
+	"login":    ValueKindUser,
+	"owner":    ValueKindUser,
+
+	"group":     ValueKindGroup,
+	"groupname": ValueKindGroup,
+
+	"signal": ValueKindSignal,
+	"sig":    ValueKindSignal,
+
+	"interface": ValueKindInterface,
+	"iface":     ValueKindInterface,
+	"ifname":    ValueKindInterface,
+
+	"n":        ValueKindPlain,
+	"num":      ValueKindPlain,
+	"number":   ValueKindPlain,
+	"count":    ValueKindPlain,
+	"int":      ValueKindPlain,
+	"integer":  ValueKindPlain,
+	"size":     ValueKindPlain,
+	"bytes":    ValueKindPlain,
+	"port":     ValueKindPlain,
+	"pid":      ValueKindPlain,
+	"uid":      ValueKindPlain,
+	"gid":      ValueKindPlain,
+	"seconds":  ValueKindPlain,
+	"secs":     ValueKindPlain,
+	"sec":      ValueKindPlain,
+	"timeout":  ValueKindPlain,
+	"duration": ValueKindPlain,
+	"delay":    ValueKindPlain,
+	"limit":    ValueKindPlain,
+	"level":    ValueKindPlain,
+	"width":    ValueKindPlain,
+	"height":   ValueKindPlain,
+	"cols":     ValueKindPlain,
+	"lines":    ValueKindPlain,
+	"percent":  ValueKindPlain,
+}
+
+// GuessValueKind guesses kind of the value by its metavar (e.g. `FILE` in `--input FILE`).
+// Compound metavars like `OUTPUT_DIR` or `<config-file>` are recognized by their last component.
+func GuessValueKind(metavar string) ValueKind {
+	name := strings.ToLower(strings.Trim(metavar, "<>"))
+	if len(name) == 0 {
+		return ValueKindUnknown
+	}
+	if kind, ok := metavarValueKinds[name]; ok {
+		return kind
+	}
+	components := strings.FieldsFunc(name, func(r rune) bool {
+		return r == '_' || r == '-' || r == '.'
+	})
+	if len(components) > 1 {
+		if kind, ok := metavarValueKinds[components[len(components)-1]]; ok {
+			return kind
+		}
+	}
+	return ValueKindUnknown
+}
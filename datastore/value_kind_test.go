@@ -0,0 +1,39 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGuessValueKind(t *testing.T) {
+	require.Equal(t, ValueKindUnknown, GuessValueKind(""))
+	require.Equal(t, ValueKindUnknown, GuessValueKind("WHEN"))
+	require.Equal(t, ValueKindFile, GuessValueKind("FILE"))
+	require.Equal(t, ValueKindFile, GuessValueKind("<file>"))
+	require.Equal(t, ValueKindFile, GuessValueKind("CONFIG_FILE"))
+	require.Equal(t, ValueKindDirectory, GuessValueKind("OUTPUT_DIR"))
+	require.Equal(t, ValueKindDirectory, GuessValueKind("<dir>"))
+	require.Equal(t, ValueKindHostname, GuessValueKind("HOST"))
+	require.Equal(t, ValueKindUser, GuessValueKind("USER"))
+	require.Equal(t, ValueKindGroup, GuessValueKind("GROUP"))
+	require.Equal(t, ValueKindSignal, GuessValueKind("SIGNAL"))
+	require.Equal(t, ValueKindInterface, GuessValueKind("IFACE"))
+	require.Equal(t, ValueKindPlain, GuessValueKind("PORT"))
+	require.Equal(t, ValueKindPlain, GuessValueKind("IDLE_TIME_LIMIT"))
+	require.Equal(t, ValueKindPlain, GuessValueKind("NUM"))
+}
@@ -16,6 +16,7 @@ package main
 
 var ExampleConfiguration = `# cod configuration
 # Put this configuration into '~/.config/cod/config.toml'.
+# Running daemon reloads this file when it is changed or when it gets SIGHUP.
 #
 # Lines starting with '#' are comments.
 
@@ -27,6 +28,25 @@ var ExampleConfiguration = `# cod configuration
 # Default value is 1000 (i.e. 1 second).
 #
 # command-execution-timeout = 1000
+#
+# 'idle-timeout' controls how long daemon keeps running when no shells are attached
+# and no clients are connected (in milliseconds).
+# Default value is 60000 (i.e. 1 minute).
+#
+# idle-timeout = 60000
+#
+# 'log-level' sets minimal level of messages written to the daemon log: debug, info, warn or error.
+# Requests are logged (with values of environment variables hidden) only at debug level.
+# Default value is "info". Use 'cod logs' to read the log.
+#
+# log-level = "info"
+#
+# 'metrics-textfile' makes daemon write its counters (see 'cod stats') to
+# $XDG_DATA_HOME/cod/metrics/cod.prom in Prometheus text format every 15 seconds,
+# point textfile collector of node_exporter to this directory to scrape them.
+# Default value is false.
+#
+# metrics-textfile = false
 
 
 #
@@ -70,4 +90,66 @@ var ExampleConfiguration = `# cod configuration
 #   [[rule]]
 #   executable = "~/my/repo/**"
 #   policy = 'trust'
+
+
+#
+# Providers
+# =========
+
+# Configuration might have several '[[provider]]' sections.
+# Provider is a command whose output lines are used as completions
+# for the value of some flag or positional argument.
+# First provider that matches the word being completed is used.
+
+# 'executable' controls which executables this provider is applied to.
+# It has the same form as 'executable' in '[[rule]]' sections.
+
+# 'sub-command' is an optional list of sub-command words that must be present
+# in the command line, e.g. ["get"] for 'kubectl get'.
+
+# Exactly one of 'flag' and 'positional' must be specified:
+#   - 'flag' :: provider completes value of this flag, e.g. "--namespace";
+#   - 'positional' :: provider completes positional argument with this number
+#                     (starting from 1) that follows the sub-command.
+
+# 'command' is run with '/bin/sh -c' in the working directory and with
+# the environment of the shell. It is limited by 'command-execution-timeout'.
+
+# 'ttl' controls how long output of the command is reused (in milliseconds).
+# Output is reused only for the same working directory and environment.
+# Default value is 5000 (i.e. 5 seconds), 0 disables caching.
+
+# Examples:
+#   [[provider]]
+#   executable = "kubectl"
+#   flag = "--namespace"
+#   command = "kubectl get namespaces -o name | cut -d/ -f2"
+#
+#   [[provider]]
+#   executable = "git"
+#   sub-command = ["checkout"]
+#   positional = 1
+#   command = "git for-each-ref --format='%(refname:short)' refs/heads"
+#   ttl = 0
+
+
+#
+# Preferences
+# ===========
+
+# Different executables might share the same name, e.g. '/usr/bin/python3' and
+# '~/.venv/bin/python3'. Shells register completions by name, so only one of
+# learned executables provides completions for the name. By default it is the one
+# found first in PATH, if it is not learned completions of other learned executable
+# with the same name are used. 'cod list' shows which executable wins.
+
+# Configuration might have several '[[prefer]]' sections.
+# 'name' is the name of executable and 'executable' selects learned executable
+# whose completions are used for this name. 'executable' has the same form
+# as in '[[rule]]' sections.
+
+# Examples:
+#   [[prefer]]
+#   name = "python3"
+#   executable = "~/.venv/bin/python3"
 `
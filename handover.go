@@ -0,0 +1,222 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dim-an/cod/server"
+	"golang.org/x/sys/unix"
+)
+
+// startDaemonTimeout limits how long client tries to start the daemon,
+// e.g. while the daemon that is shutting down still holds the lock file.
+const startDaemonTimeout = time.Second * 5
+
+// connectDaemon connects to the daemon and starts it if connection fails.
+// Daemon started from older cod binary rejects requests of this binary,
+// then it hands over its shells to the daemon started from this binary and the request is retried,
+// so shells keep working after cod is upgraded.
+func connectDaemon(config *server.Configuration) (client *server.Client, err error) {
+	deadline := time.Now().Add(startDaemonTimeout)
+	for {
+		client, err = server.ConnectClient(*config)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, unix.ENOENT) && !errors.Is(err, unix.ECONNREFUSED) || time.Now().After(deadline) {
+			return
+		}
+		// Daemon that is shutting down removes its socket before it releases the lock file,
+		// then daemonize doesn't start new daemon and the connection is retried until the old one exits.
+		err = daemonize(config)
+		if errors.Is(err, errDatabaseLocked) {
+			return
+		}
+		if err == nil {
+			client, err = server.ConnectClient(*config)
+			if err == nil {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			return
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	client.OnProtocolMismatch(func() error {
+		return upgradeDaemon(config)
+	})
+	return
+}
+
+// upgradeDaemon makes daemon speaking other protocol version hand over its shells to the daemon started from this binary.
+func upgradeDaemon(config *server.Configuration) (err error) {
+	client, err := server.NewClient(*config)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	rsp, err := requestDaemonVersion(client)
+	if err != nil {
+		return
+	}
+
+	switch {
+	case rsp.ProtocolVersion == server.ProtocolVersion:
+		// Daemon is already upgraded by other client.
+		return
+	case rsp.ProtocolVersion > server.ProtocolVersion:
+		err = fmt.Errorf(
+			"daemon (pid %v) is started from newer cod %v, this binary supports protocol version %v only",
+			rsp.Pid, rsp.Version, server.ProtocolVersion,
+		)
+		return
+	}
+
+	err = handoverDaemon(config, config, client, rsp.ProtocolVersion)
+	if err != nil {
+		err = fmt.Errorf("cannot upgrade daemon: %w", err)
+	}
+	return
+}
+
+func requestDaemonVersion(client *server.Client) (rsp server.VersionResponse, err error) {
+	err = client.Request(&server.VersionRequest{}, &rsp)
+	var errorResponse *server.ErrorResponse
+	if errors.As(err, &errorResponse) {
+		// Daemons that don't know VersionRequest speak the very first version of protocol.
+		err = nil
+		rsp = server.VersionResponse{}
+	}
+	return
+}
+
+// handoverLegacyDaemon moves shells of the daemon started by cod that kept runtime files in the data directory
+// to the daemon listening in the current run directory. handedOver is false if there is no such daemon.
+func handoverLegacyDaemon(config *server.Configuration) (handedOver bool, err error) {
+	legacyConfig, ok, err := config.GetLegacyConfiguration()
+	if err != nil || !ok {
+		return
+	}
+	isRunning, err := isDaemonRunning(&legacyConfig)
+	if err != nil || !isRunning {
+		return
+	}
+
+	client, err := server.NewClient(legacyConfig)
+	if err != nil {
+		return
+	}
+	rsp, err := requestDaemonVersion(client)
+	if err == nil {
+		err = handoverDaemon(&legacyConfig, config, client, rsp.ProtocolVersion)
+	}
+	_ = client.Close()
+	if err != nil {
+		err = fmt.Errorf("cannot move daemon to %v: %w", config.GetRunDir(), err)
+		return
+	}
+	handedOver = true
+
+	// Init scripts of the shells refer to the old generation file.
+	legacyGenerationFile := legacyConfig.GetGenerationFile()
+	_ = os.Remove(legacyGenerationFile)
+	err = os.Symlink(config.GetGenerationFile(), legacyGenerationFile)
+	return
+}
+
+// handoverDaemon takes shells from the old daemon, waits until it exits
+// and attaches the shells to the new daemon.
+// oldConfig differs from config if the old daemon listens in other run directory.
+func handoverDaemon(oldConfig, config *server.Configuration, oldClient *server.Client, oldProtocolVersion int) (err error) {
+	var shells []server.HandedOverShell
+	if oldProtocolVersion == 0 {
+		// The very first daemons cannot hand over, shells are taken from the list of clients.
+		rsp := server.ListClientsResponse{}
+		err = oldClient.Request(&server.ListClientsRequest{}, &rsp)
+		if err != nil {
+			return
+		}
+		for _, c := range rsp.Clients {
+			shells = append(shells, server.HandedOverShell{
+				Shell:         c.Shell,
+				Pid:           c.Pid,
+				CodBinaryPath: CodBinaryPath,
+			})
+		}
+		_, err = stopDaemon(oldConfig)
+		if err != nil {
+			return
+		}
+	} else {
+		rsp := server.HandoverResponse{}
+		err = oldClient.Request(&server.HandoverRequest{}, &rsp)
+		if err != nil {
+			return
+		}
+		shells = rsp.Shells
+
+		_ = oldClient.Close()
+		var exited bool
+		exited, err = waitDaemonExit(oldConfig)
+		if err != nil {
+			return
+		}
+		if !exited {
+			_, err = stopDaemon(oldConfig)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	err = daemonize(config)
+	if err != nil {
+		return
+	}
+	client, err := server.NewClient(*config)
+	if err != nil {
+		return
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	for _, shell := range shells {
+		req := server.AttachRequest{
+			ProtocolVersion: server.ProtocolVersion,
+			Shell:           shell.Shell,
+			Pid:             shell.Pid,
+			CodBinaryPath:   shell.CodBinaryPath,
+			Dir:             shell.Dir,
+			PathVar:         shell.PathVar,
+		}
+		rsp := server.AttachResponse{}
+		attachErr := client.Request(&req, &rsp)
+		if attachErr != nil {
+			log.Printf("warn: cannot attach %v shell (pid %v) to upgraded daemon: %v", shell.Shell, shell.Pid, attachErr)
+		}
+	}
+	return
+}
@@ -0,0 +1,194 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dim-an/cod/server"
+	"github.com/dim-an/cod/util"
+)
+
+const logsPollInterval = 200 * time.Millisecond
+
+// logFilter decides whether log line is printed by `cod logs`.
+type logFilter struct {
+	minLevel slog.Level
+}
+
+func (f logFilter) match(line string) bool {
+	for _, field := range strings.Fields(line) {
+		value, found := strings.CutPrefix(field, "level=")
+		if !found {
+			continue
+		}
+		level, err := server.ParseLogLevel(value)
+		if err != nil {
+			break
+		}
+		return level >= f.minLevel
+	}
+	// Lines without level (e.g. panics written to stderr of the daemon) are always shown.
+	return true
+}
+
+func logsMain(follow bool, levelName string, lineCount int) {
+	config, err := server.DefaultConfiguration()
+	verifyFatal(err)
+
+	filter := logFilter{minLevel: slog.LevelDebug}
+	if levelName != "" {
+		filter.minLevel, err = server.ParseLogLevel(levelName)
+		verifyFatal(err)
+	}
+
+	logFile := config.GetLogFile()
+
+	// Last rotated file is read too, so recent lines are not lost right after rotation.
+	var lines []string
+	for _, name := range []string{util.BackupName(logFile, 1), logFile} {
+		fileLines, err := readLogLines(name, filter)
+		verifyFatal(err)
+		lines = append(lines, fileLines...)
+	}
+	if lineCount >= 0 && len(lines) > lineCount {
+		lines = lines[len(lines)-lineCount:]
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+
+	if follow {
+		err = followLog(logFile, filter)
+		verifyFatal(err)
+	}
+}
+
+func readLogLines(name string, filter logFilter) (lines []string, err error) {
+	file, err := os.Open(name)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if filter.match(scanner.Text()) {
+			lines = append(lines, scanner.Text())
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
+// followLog prints lines appended to the log file until interrupted.
+// File is reopened when it is rotated or truncated.
+func followLog(name string, filter logFilter) (err error) {
+	var file *os.File
+	var reader *bufio.Reader
+	var offset int64
+	var partial string
+
+	open := func(whence int) (err error) {
+		if file != nil {
+			_ = file.Close()
+			file = nil
+		}
+		file, err = os.Open(name)
+		if os.IsNotExist(err) {
+			err = nil
+			return
+		} else if err != nil {
+			return
+		}
+		offset, err = file.Seek(0, whence)
+		reader = bufio.NewReader(file)
+		partial = ""
+		return
+	}
+
+	readAvailable := func() (err error) {
+		if file == nil {
+			return
+		}
+		for {
+			var chunk string
+			chunk, err = reader.ReadString('\n')
+			offset += int64(len(chunk))
+			if err == io.EOF {
+				partial += chunk
+				err = nil
+				return
+			} else if err != nil {
+				return
+			}
+			line := strings.TrimSuffix(partial+chunk, "\n")
+			partial = ""
+			if filter.match(line) {
+				fmt.Println(line)
+			}
+		}
+	}
+
+	err = open(io.SeekEnd)
+	if err != nil {
+		return
+	}
+	for {
+		err = readAvailable()
+		if err != nil {
+			return
+		}
+
+		time.Sleep(logsPollInterval)
+
+		stat, statErr := os.Stat(name)
+		if statErr != nil {
+			continue
+		}
+		reopen := file == nil
+		if !reopen {
+			current, statErr := file.Stat()
+			if statErr != nil || !os.SameFile(stat, current) {
+				// Lines written to the file before it was rotated.
+				err = readAvailable()
+				if err != nil {
+					return
+				}
+				reopen = true
+			} else {
+				reopen = stat.Size() < offset
+			}
+		}
+		if reopen {
+			err = open(io.SeekStart)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
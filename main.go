@@ -73,9 +73,11 @@ func main() {
 	var foreground bool
 	var selectors []string
 	var createConfig bool
+	var purge bool
+	var assumeYes bool
 
 	addShellArg := func(c *kingpin.CmdClause) *kingpin.CmdClause {
-		c.Arg("shell", "Shell name (bash, zsh or fish).").Required().StringVar(&shell)
+		c.Arg("shell", "Shell name (bash, zsh, fish, nu, elvish, xonsh or pwsh).").Required().StringVar(&shell)
 		return c
 	}
 
@@ -100,18 +102,52 @@ func main() {
 	update := app.Command("update", "Update known command")
 	update.Arg("selector", "Items to update.").Required().StringsVar(&selectors)
 
+	exportCompletions := app.Command("export-completions", "Write standalone completion scripts that don't require cod to work.")
+	exportCompletionsShell := exportCompletions.Flag("shell", "Shell to generate completions for (bash, zsh or fish).").Required().Enum("bash", "zsh", "fish")
+	exportCompletionsOut := exportCompletions.Flag("out", "Directory to write completion files to.").Required().String()
+	exportCompletions.Arg("selector", "Items to export.").StringsVar(&selectors)
+
+	export := app.Command("export", "Write learned commands and their completions to stdout as a bundle that can be imported by cod on another machine.")
+	export.Arg("selector", "Items to export.").StringsVar(&selectors)
+
+	importCmd := app.Command("import", "Add commands and completions from bundle written by cod export.")
+	importStrategy := importCmd.Flag("strategy", "What to do with commands that are already learned: skip, overwrite or newer-wins (replace if bundle has help page learned later).").Default("skip").Enum("skip", "overwrite", "newer-wins")
+	importKeepTrust := importCmd.Flag("keep-trust", "Keep trust policy of imported commands, so cod learns them without asking.").Bool()
+	importFile := importCmd.Arg("bundle", "Bundle file, - reads it from stdin.").Required().String()
+
 	init := app.Command("init", "Output shell initialization script.")
+	initLazy := init.Flag("lazy", "Register completions of a command on its first completion instead of registering all of them at init (bash, zsh and fish).").Bool()
 	addPidArg(init)
 	addShellArg(init)
 
+	deinit := app.Command("deinit", "Output script that removes cod from the shell.")
+	addPidArg(deinit)
+	addShellArg(deinit)
+
+	uninstall := app.Command("uninstall", "Stop cod daemon and optionally remove all its files.")
+	uninstall.Flag("purge", "Remove data, log and config directories.").BoolVar(&purge)
+	uninstall.Flag("yes", "Don't ask for confirmation.").Short('y').BoolVar(&assumeYes)
+
 	exampleConfig := app.Command("example-config", "print example configuration to stdout")
 	exampleConfig.Flag(
 		"create",
 		"write configuration to config file instead of printing it to stdout (doesn't work if config file already exists)",
 	).BoolVar(&createConfig)
 
-	daemon := app.Command("daemon", "Start cod daemon.")
+	stats := app.Command("stats", "Show request latencies, parser results and other counters of running daemon.")
+	statsPrometheus := stats.Flag("prometheus", "Print counters in Prometheus text format.").Bool()
+
+	logs := app.Command("logs", "Print log of cod daemon.")
+	logsFollow := logs.Flag("follow", "Keep printing lines appended to the log.").Short('f').Bool()
+	logsLevel := logs.Flag("level", "Print only lines of this level or higher (debug, info, warn or error).").Enum("debug", "info", "warn", "error")
+	logsLines := logs.Flag("lines", "Number of last lines to print, -1 prints all of them.").Short('n').Default("100").Int()
+
+	daemon := app.Command("daemon", "Manage cod daemon.")
 	daemon.Flag("foreground", "Run daemon in foreground.").BoolVar(&foreground)
+	daemonStart := daemon.Command("start", "Start cod daemon.").Default()
+	daemonStatus := daemon.Command("status", "Show status of running daemon.")
+	daemonStop := daemon.Command("stop", "Detach all shells and stop the daemon.")
+	daemonRestart := daemon.Command("restart", "Restart the daemon keeping shells attached.")
 
 	api := app.Command("api", "shell <-> cod interaction.").Hidden()
 
@@ -129,13 +165,29 @@ func main() {
 	addPidArg(apiPostexec)
 	apiPostexecCommand := apiPostexec.Arg("command", "command to analyze").Required().String()
 
+	apiPromptHook := api.Command("prompt-hook", "check executed command and print script with pending updates").Hidden()
+	addPidArg(apiPromptHook)
+	apiPromptHookCommand := apiPromptHook.Arg("command", "executed command to analyze").String()
+
+	apiLazyCompletions := api.Command("lazy-completions", "print script registering completions of the command if it is learned").Hidden()
+	addPidArg(apiLazyCompletions)
+	apiLazyCompletionsName := apiLazyCompletions.Arg("name", "name of the command").Required().String()
+
 	apiListClients := api.Command("list-clients", "help list all attached shells").Hidden()
 
+	apiVersion := api.Command("version", "print protocol versions of client and daemon").Hidden()
+
 	apiCompleteWords := api.Command("complete-words", "Get completions for given command line.").Hidden()
 	addPidArg(apiCompleteWords)
+	apiCompleteWordsFormat := apiCompleteWords.Flag("format", "Output format: plain (value per line) or v1 (value kind line followed by tab separated items).").Default("plain").Enum("plain", "v1")
 	apiCompleteWordsCWord := apiCompleteWords.Arg("c-word", "Index of a word being completed.").Required().Int()
 	apiCompleteWordsWords := apiCompleteWords.Arg("words", "Command line being completed.").Required().Strings()
 
+	apiSession := api.Command("session", "Serve requests of the shell read from stdin until it is closed.").Hidden()
+	apiSessionFifo := apiSession.Flag("fifo", "Start session in background serving fifos, print their directory and pid of the session.").Bool()
+	apiSessionFifoDir := apiSession.Flag("fifo-dir", "Serve fifos of the directory instead of stdin and stdout.").Hidden().String()
+	addPidArg(apiSession)
+
 	apiForkedDaemon := api.Command("forked-daemon", "Helper method to run a daemon").Hidden()
 	notifyPid := apiForkedDaemon.Arg("pid", "Pid to notify after command start").Required().Int()
 
@@ -150,13 +202,33 @@ func main() {
 	case list.FullCommand():
 		listMain(selectors)
 	case init.FullCommand():
-		initMain(pid, shell)
-	case daemon.FullCommand():
+		initMain(pid, shell, *initLazy)
+	case deinit.FullCommand():
+		deinitMain(pid, shell)
+	case uninstall.FullCommand():
+		uninstallMain(purge, assumeYes)
+	case stats.FullCommand():
+		statsMain(*statsPrometheus)
+	case logs.FullCommand():
+		logsMain(*logsFollow, *logsLevel, *logsLines)
+	case daemonStart.FullCommand():
 		daemonMain(foreground)
+	case daemonStatus.FullCommand():
+		daemonStatusMain()
+	case daemonStop.FullCommand():
+		daemonStopMain()
+	case daemonRestart.FullCommand():
+		daemonRestartMain()
 	case remove.FullCommand():
 		removeMain(selectors)
 	case update.FullCommand():
 		updateMain(selectors)
+	case exportCompletions.FullCommand():
+		exportCompletionsMain(*exportCompletionsShell, *exportCompletionsOut, selectors)
+	case export.FullCommand():
+		exportMain(selectors)
+	case importCmd.FullCommand():
+		importMain(*importFile, *importStrategy, *importKeepTrust)
 	case exampleConfig.FullCommand():
 		exampleConfigMain(createConfig)
 
@@ -167,10 +239,22 @@ func main() {
 		apiPollUpdatesMain(pid)
 	case apiPostexec.FullCommand():
 		apiPostexecMain(pid, *apiPostexecCommand)
+	case apiPromptHook.FullCommand():
+		apiPromptHookMain(pid, *apiPromptHookCommand)
+	case apiLazyCompletions.FullCommand():
+		apiLazyCompletionsMain(pid, *apiLazyCompletionsName)
 	case apiCompleteWords.FullCommand():
-		apiCompleteWordsMain(pid, *apiCompleteWordsCWord, *apiCompleteWordsWords)
+		apiCompleteWordsMain(pid, *apiCompleteWordsCWord, *apiCompleteWordsWords, *apiCompleteWordsFormat)
 	case apiListClients.FullCommand():
 		apiListClientsMain()
+	case apiVersion.FullCommand():
+		apiVersionMain()
+	case apiSession.FullCommand():
+		if *apiSessionFifo {
+			apiStartFifoSessionMain(pid)
+		} else {
+			apiSessionMain(pid, *apiSessionFifoDir)
+		}
 	case apiForkedDaemon.FullCommand():
 		forkedDaemonMain(*notifyPid)
 	case apiBashCleanCompletions.FullCommand():
@@ -241,12 +241,22 @@ func tryParseFlagsParagraph(par *lineTree, usage *argparseUsage, res *parseResul
 
 	for idx := range par.children {
 		line := par.children[idx].line
-		allFlags := flagRe.FindAllString(line, -1)
-		for _, flag := range allFlags {
-			res.completions = append(res.completions, datastore.Completion{
-				Flag:    flag,
+		matches := findFlags(flagRe, 0, line)
+		description, groupSize := findDescription(line, matches)
+		if len(description) == 0 && len(par.children[idx].children) > 0 {
+			// argparse moves description to the next line when flags are too long
+			description = strings.TrimSpace(par.children[idx].children[0].line)
+		}
+		for matchIdx, match := range matches {
+			completion := datastore.Completion{
+				Flag:    match.flag,
 				Context: usage.flagContext,
-			})
+				Metavar: match.metavar,
+			}
+			if matchIdx < groupSize {
+				completion.Description = description
+			}
+			res.completions = append(res.completions, completion)
 		}
 	}
 	return true
@@ -256,13 +266,14 @@ func extractPositionalArgs(par *lineTree, usage *argparseUsage, res *parseResult
 	var completions []datastore.Completion
 	for idx := range par.children[0].children {
 		line := par.children[0].children[idx].line
-		arg := argRe.FindStringSubmatch(line)[1]
-		if len(arg) == 0 {
+		match := argRe.FindStringSubmatch(line)
+		if len(match) == 0 || len(match[1]) == 0 {
 			return false
 		}
 		completions = append(completions, datastore.Completion{
-			Flag:    arg,
-			Context: usage.flagContext,
+			Flag:        match[1],
+			Context:     usage.flagContext,
+			Description: splitDescription(line[len(match[0])-len(match[2]):]),
 		})
 	}
 	res.completions = append(res.completions, completions...)
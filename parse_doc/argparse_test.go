@@ -112,24 +112,24 @@ func TestParseArgparseContext(t *testing.T) {
 	require.Equal(
 		t,
 		[]datastore.Completion{
-			{Flag: "--append", Context: argparseContext([]string{"rec"})},
-			{Flag: "--command", Context: argparseContext([]string{"rec"})},
-			{Flag: "--env", Context: argparseContext([]string{"rec"})},
-			{Flag: "--help", Context: argparseContext([]string{"rec"})},
-			{Flag: "--idle-time-limit", Context: argparseContext([]string{"rec"})},
-			{Flag: "--overwrite", Context: argparseContext([]string{"rec"})},
-			{Flag: "--quiet", Context: argparseContext([]string{"rec"})},
-			{Flag: "--raw", Context: argparseContext([]string{"rec"})},
-			{Flag: "--stdin", Context: argparseContext([]string{"rec"})},
-			{Flag: "--title", Context: argparseContext([]string{"rec"})},
-			{Flag: "--yes", Context: argparseContext([]string{"rec"})},
-			{Flag: "-c", Context: argparseContext([]string{"rec"})},
-			{Flag: "-e", Context: argparseContext([]string{"rec"})},
-			{Flag: "-h", Context: argparseContext([]string{"rec"})},
-			{Flag: "-i", Context: argparseContext([]string{"rec"})},
-			{Flag: "-q", Context: argparseContext([]string{"rec"})},
-			{Flag: "-t", Context: argparseContext([]string{"rec"})},
-			{Flag: "-y", Context: argparseContext([]string{"rec"})},
+			{Flag: "--append", Context: argparseContext([]string{"rec"}), Description: "append to existing recording"},
+			{Flag: "--command", Context: argparseContext([]string{"rec"}), Metavar: "COMMAND", Description: "command to record, defaults to $SHELL"},
+			{Flag: "--env", Context: argparseContext([]string{"rec"}), Metavar: "ENV", Description: "list of environment variables to capture, defaults to"},
+			{Flag: "--help", Context: argparseContext([]string{"rec"}), Description: "show this help message and exit"},
+			{Flag: "--idle-time-limit", Context: argparseContext([]string{"rec"}), Metavar: "IDLE_TIME_LIMIT", Description: "limit recorded idle time to given number of seconds"},
+			{Flag: "--overwrite", Context: argparseContext([]string{"rec"}), Description: "overwrite the file if it already exists"},
+			{Flag: "--quiet", Context: argparseContext([]string{"rec"}), Description: "be quiet, suppress all notices/warnings (implies -y)"},
+			{Flag: "--raw", Context: argparseContext([]string{"rec"}), Description: "save only raw stdout output"},
+			{Flag: "--stdin", Context: argparseContext([]string{"rec"}), Description: "enable stdin recording, disabled by default"},
+			{Flag: "--title", Context: argparseContext([]string{"rec"}), Metavar: "TITLE", Description: "title of the asciicast"},
+			{Flag: "--yes", Context: argparseContext([]string{"rec"}), Description: "answer \"yes\" to all prompts (e.g. upload confirmation)"},
+			{Flag: "-c", Context: argparseContext([]string{"rec"}), Metavar: "COMMAND", Description: "command to record, defaults to $SHELL"},
+			{Flag: "-e", Context: argparseContext([]string{"rec"}), Metavar: "ENV", Description: "list of environment variables to capture, defaults to"},
+			{Flag: "-h", Context: argparseContext([]string{"rec"}), Description: "show this help message and exit"},
+			{Flag: "-i", Context: argparseContext([]string{"rec"}), Metavar: "IDLE_TIME_LIMIT", Description: "limit recorded idle time to given number of seconds"},
+			{Flag: "-q", Context: argparseContext([]string{"rec"}), Description: "be quiet, suppress all notices/warnings (implies -y)"},
+			{Flag: "-t", Context: argparseContext([]string{"rec"}), Metavar: "TITLE", Description: "title of the asciicast"},
+			{Flag: "-y", Context: argparseContext([]string{"rec"}), Description: "answer \"yes\" to all prompts (e.g. upload confirmation)"},
 			// FIXME: this is bug, we should only parse `-y` single time
 			{Flag: "-y", Context: argparseContext([]string{"rec"})},
 		},
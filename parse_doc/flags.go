@@ -0,0 +1,114 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parse_doc
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Metavar is a word right after the flag, either in upper case (`--file FILE`, `--size=SIZE`)
+// or in angle brackets (`-o <file>`).
+// Optional values (`--color[=WHEN]`) are ignored since such flags don't require any value.
+var metavarRegexp = regexp.MustCompile(`^[= ](<[-_.[:alnum:]]+>|[A-Z][-_A-Z0-9]*)(?:,|\s|$)`)
+
+// Description is separated from flags or sub-command by several spaces or tab.
+var descriptionSeparatorRegexp = regexp.MustCompile(`\s{2,}|\t`)
+
+type flagMatch struct {
+	flag    string
+	metavar string
+
+	// Position of the flag inside the line
+	begin int
+	end   int
+}
+
+func findMetavar(flag string, rest string) string {
+	if strings.HasSuffix(flag, "=") {
+		rest = "=" + rest
+	}
+	m := metavarRegexp.FindStringSubmatch(rest)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// Check if text between two flags means that these flags are aliases of each other
+// e.g. `-f, --file FILE` or `-f FILE, --file FILE`.
+func isFlagSeparator(between string) bool {
+	trimmed := strings.TrimSpace(between)
+	if len(trimmed) == 0 {
+		return len(between) <= 2
+	}
+	return strings.HasSuffix(trimmed, ",") && !strings.ContainsAny(trimmed, " \t")
+}
+
+// findFlags finds all flags matched by `re` inside the line (`group` is an index of submatch containing the flag).
+// Metavar is propagated between aliases so `-w` gets `COLS` in `-w, --width=COLS`.
+func findFlags(re *regexp.Regexp, group int, line string) (res []flagMatch) {
+	groupStart := 0
+	for _, loc := range re.FindAllStringSubmatchIndex(line, -1) {
+		begin, end := loc[2*group], loc[2*group+1]
+		cur := flagMatch{
+			flag:  line[begin:end],
+			begin: begin,
+			end:   end,
+		}
+		cur.metavar = findMetavar(cur.flag, line[end:])
+
+		if len(res) == 0 || !isFlagSeparator(line[res[len(res)-1].end:begin]) {
+			groupStart = len(res)
+		}
+		res = append(res, cur)
+
+		if cur.metavar == "" {
+			continue
+		}
+		for i := groupStart; i < len(res); i += 1 {
+			if res[i].metavar == "" {
+				res[i].metavar = cur.metavar
+			}
+		}
+	}
+	return
+}
+
+// splitDescription returns text that follows the description separator.
+func splitDescription(rest string) string {
+	loc := descriptionSeparatorRegexp.FindStringIndex(rest)
+	if loc == nil {
+		return ""
+	}
+	return strings.TrimSpace(rest[loc[1]:])
+}
+
+// findDescription returns description of the flags that start the line
+// e.g. `display help` for `  -h, --help   display help`.
+// groupSize is the number of leading matches the description belongs to.
+func findDescription(line string, matches []flagMatch) (description string, groupSize int) {
+	if len(matches) == 0 || len(strings.TrimSpace(line[:matches[0].begin])) > 0 {
+		return
+	}
+	groupSize = 1
+	for ; groupSize < len(matches); groupSize += 1 {
+		if !isFlagSeparator(line[matches[groupSize-1].end:matches[groupSize].begin]) {
+			break
+		}
+	}
+	description = splitDescription(line[matches[groupSize-1].end:])
+	return
+}
@@ -127,14 +127,30 @@ func (defaultParser) Parse(context parseContext) (res *parseResult, err error) {
 	var completions []datastore.Completion
 	var discoveredFlagMap = make(map[string]bool)
 	var discoveredFlags []string
-	for _, line := range context.text.lines {
-		flagsMatch := flagRegexp.FindAllStringSubmatch(line, -1)
-		for _, match := range flagsMatch {
-			flag := match[1]
+	var discoveredMetavars = make(map[string]string)
+	var discoveredDescriptions = make(map[string]string)
+	for lineIdx, line := range context.text.lines {
+		matches := findFlags(flagRegexp, 1, line)
+		description, groupSize := findDescription(line, matches)
+		if groupSize > 0 && len(description) == 0 && lineIdx+1 < len(context.text.lines) {
+			// Description might be on the next line if flags are too long.
+			next := context.text.lines[lineIdx+1]
+			if computeIndent(next) > computeIndent(line) && !strings.HasPrefix(strings.TrimSpace(next), "-") {
+				description = strings.TrimSpace(next)
+			}
+		}
+		for matchIdx, match := range matches {
+			flag := match.flag
 			if !discoveredFlagMap[flag] {
 				discoveredFlags = append(discoveredFlags, flag)
 				discoveredFlagMap[flag] = true
 			}
+			if discoveredMetavars[flag] == "" {
+				discoveredMetavars[flag] = match.metavar
+			}
+			if discoveredDescriptions[flag] == "" && matchIdx < groupSize {
+				discoveredDescriptions[flag] = description
+			}
 		}
 	}
 
@@ -153,7 +169,12 @@ func (defaultParser) Parse(context parseContext) (res *parseResult, err error) {
 		if isGnuLike && isJavaStyleFlag(flag) {
 			continue
 		}
-		completions = append(completions, datastore.Completion{Flag: flag, Context: flagContext})
+		completions = append(completions, datastore.Completion{
+			Flag:        flag,
+			Context:     flagContext,
+			Metavar:     discoveredMetavars[flag],
+			Description: discoveredDescriptions[flag],
+		})
 	}
 
 	// Now we are going to search for sub-commands.
@@ -197,7 +218,11 @@ func (defaultParser) Parse(context parseContext) (res *parseResult, err error) {
 					continue
 				}
 				subCommand := m[1]
-				completions = append(completions, datastore.Completion{Flag: subCommand, Context: flagContext})
+				completions = append(completions, datastore.Completion{
+					Flag:        subCommand,
+					Context:     flagContext,
+					Description: splitDescription(line[len(m[0]):]),
+				})
 			} else if indent < currentParagraphIndent {
 				state = Outer
 			} // else if indent > currentParagraphIndent { continue }
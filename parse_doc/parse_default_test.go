@@ -211,3 +211,69 @@ func TestParseUsageSubCommand(t *testing.T) {
 func TestIsJavaStyleFlag(t *testing.T) {
 	require.Equal(t, true, isJavaStyleFlag("-vET"))
 }
+
+func TestParseMetavars(t *testing.T) {
+	help := `Usage: foo [OPTION]... [FILE]...
+
+  -a, --all                  do not ignore anything
+      --block-size=SIZE      scale sizes by SIZE
+      --color[=WHEN]         colorize the output
+  -w, --width=COLS           set output width to COLS
+  -o <file>                  write output to file
+  -H HOST, --host HOST       connect to HOST
+`
+	ctx, err := makeParseContext([]string{"foo", "--help"}, help)
+	require.NoError(t, err)
+
+	parseResult, err := makeDefaultParser().Parse(ctx)
+	require.NoError(t, err)
+
+	metavars := make(map[string]string)
+	for _, c := range parseResult.completions {
+		metavars[c.Flag] = c.Metavar
+	}
+	require.Equal(t, map[string]string{
+		"-a":            "",
+		"--all":         "",
+		"--block-size=": "SIZE",
+		"--color":       "",
+		"-w":            "COLS",
+		"--width=":      "COLS",
+		"-o":            "<file>",
+		"-H":            "HOST",
+		"--host":        "HOST",
+	}, metavars)
+}
+
+func TestParseDescriptions(t *testing.T) {
+	help := `Usage: foo [OPTION]... COMMAND
+
+  -a, --all                  do not ignore anything
+      --group-directories-first
+                             group directories before files
+  -v --verbose be verbose (single space is not a separator)
+
+Commands:
+  build       Build the project
+  run	Run the project
+`
+	ctx, err := makeParseContext([]string{"foo", "--help"}, help)
+	require.NoError(t, err)
+
+	parseResult, err := makeDefaultParser().Parse(ctx)
+	require.NoError(t, err)
+
+	descriptions := make(map[string]string)
+	for _, c := range parseResult.completions {
+		descriptions[c.Flag] = c.Description
+	}
+	require.Equal(t, map[string]string{
+		"-a":                        "do not ignore anything",
+		"--all":                     "do not ignore anything",
+		"--group-directories-first": "group directories before files",
+		"-v":                        "",
+		"--verbose":                 "",
+		"build":                     "Build the project",
+		"run":                       "Run the project",
+	}, descriptions)
+}
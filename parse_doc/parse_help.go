@@ -18,6 +18,7 @@ import (
 	"crypto/sha1"
 	"fmt"
 	"log"
+	"log/slog"
 
 	"github.com/dim-an/cod/datastore"
 )
@@ -32,7 +33,14 @@ var parsers = []HelpParser{
 	makeDefaultParser(),
 }
 
+// ParserObserver is called for every parser tried on a help page, err is nil if the parser succeeded.
+type ParserObserver func(parser string, err error)
+
 func ParseHelp(args []string, help string) (*datastore.HelpPage, error) {
+	return ParseHelpObserved(args, help, nil)
+}
+
+func ParseHelpObserved(args []string, help string, observe ParserObserver) (*datastore.HelpPage, error) {
 	if len(args) < 1 {
 		log.Panicf("args cannot be empty")
 	}
@@ -50,8 +58,11 @@ func ParseHelp(args []string, help string) (*datastore.HelpPage, error) {
 	for idx := range parsers {
 		var err error
 		res, err = parsers[idx].Parse(ctx)
+		if observe != nil {
+			observe(parsers[idx].Name(), err)
+		}
 		if err != nil {
-			log.Printf("Parser %s failed with error %s", parsers[idx].Name(), err)
+			slog.Debug("Parser failed", "parser", parsers[idx].Name(), "error", err)
 			continue
 		}
 		break
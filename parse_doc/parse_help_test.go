@@ -15,6 +15,7 @@
 package parse_doc
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/dim-an/cod/datastore"
@@ -46,10 +47,10 @@ func TestParseCatHelp(t *testing.T) {
 	expected := datastore.HelpPage{
 		ExecutablePath: "cat",
 		Completions: []datastore.Completion{
-			{Flag: "-A"},
-			{Flag: "--show-all"},
-			{Flag: "-e"},
-			{Flag: "--help"},
+			{Flag: "-A", Description: "equivalent to -vET"},
+			{Flag: "--show-all", Description: "equivalent to -vET"},
+			{Flag: "-e", Description: "equivalent to -vE"},
+			{Flag: "--help", Description: "display this help and exit"},
 		},
 		CheckSum: "4a8d01dde2483ad006b8f5ac2f599f9369287730",
 	}
@@ -82,10 +83,10 @@ func TestParseQuWriteFileHelp(t *testing.T) {
 	expected := datastore.HelpPage{
 		ExecutablePath: "qu",
 		Completions: []datastore.Completion{
-			{Flag: "-h", Context: expectedContext},
-			{Flag: "--help", Context: expectedContext},
-			{Flag: "--destination", Context: expectedContext},
-			{Flag: "--compute", Context: expectedContext},
+			{Flag: "-h", Context: expectedContext, Description: "show this help message and exit"},
+			{Flag: "--help", Context: expectedContext, Description: "show this help message and exit"},
+			{Flag: "--destination", Context: expectedContext, Metavar: "DESTINATION", Description: "destination see also http://example.com/"},
+			{Flag: "--compute", Context: expectedContext, Description: "compute file content"},
 		},
 		CheckSum: "54e9e119f4205bdde6a9315db1a67571385a6cf2",
 	}
@@ -265,7 +266,7 @@ func TestJavaStyleInclusion(t *testing.T) {
 			{Flag: "--expand"},
 			{Flag: "-T"},
 			{Flag: "--text"},
-			{Flag: "-a"},
+			{Flag: "-a", Description: "same as -vET"},
 		},
 		CheckSum: "6776c5b37b6af1554b1af65fd95275117a379682",
 	}
@@ -320,3 +321,19 @@ func TestOptionInVeryBeginningOfLine(t *testing.T) {
 	}
 	require.Equal(t, expected, *desc)
 }
+
+func TestParseHelpObserved(t *testing.T) {
+	var attempts []string
+	observe := func(parser string, err error) {
+		attempts = append(attempts, fmt.Sprintf("%v:%v", parser, err == nil))
+	}
+
+	_, err := ParseHelpObserved([]string{"cat", "--help"}, catHelp, observe)
+	require.NoError(t, err)
+	require.Equal(t, []string{"argparse:false", "default:true"}, attempts)
+
+	attempts = nil
+	_, err = ParseHelpObserved([]string{"qu", "--help"}, quWriteFileHelp, observe)
+	require.NoError(t, err)
+	require.Equal(t, []string{"argparse:true"}, attempts)
+}
@@ -16,16 +16,21 @@ package server
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"net"
+	"reflect"
 	"time"
 
 	"github.com/dim-an/cod/util"
 )
 
 type Client struct {
-	conn   net.Conn
-	reader *bufio.Reader
+	configuration Configuration
+	conn          net.Conn
+	reader        *bufio.Reader
+
+	onProtocolMismatch func() error
 }
 
 func dial(network, address string) (conn net.Conn, err error) {
@@ -39,19 +44,77 @@ func dial(network, address string) (conn net.Conn, err error) {
 	return
 }
 
+// NewClient connects to the daemon, it waits for a while if the daemon is starting.
 func NewClient(configuration Configuration) (client *Client, err error) {
-	conn, err := dial("unix", configuration.GetSocketFile())
+	client = &Client{
+		configuration: configuration,
+	}
+	err = client.connect(dial)
 	if err != nil {
-		return
+		client = nil
 	}
+	return
+}
+
+// ConnectClient makes single attempt to connect to the daemon.
+func ConnectClient(configuration Configuration) (client *Client, err error) {
 	client = &Client{
-		conn:   conn,
-		reader: bufio.NewReader(conn),
+		configuration: configuration,
+	}
+	err = client.connect(net.Dial)
+	if err != nil {
+		client = nil
+	}
+	return
+}
+
+func (c *Client) connect(dial func(network, address string) (net.Conn, error)) (err error) {
+	conn, err := dial("unix", c.configuration.GetSocketAddress())
+	if err != nil {
+		return
+	}
+	err = checkPeerCredentials(conn)
+	if err != nil {
+		_ = conn.Close()
+		err = fmt.Errorf("cannot connect daemon: %w", err)
+		return
 	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
 	return
 }
 
+// OnProtocolMismatch sets function called when response comes from the daemon speaking other protocol version.
+// Such daemon rejects the request (daemons that predate the check serve it),
+// so the request is sent again over new connection after the function succeeds.
+func (c *Client) OnProtocolMismatch(f func() error) {
+	c.onProtocolMismatch = f
+}
+
 func (c *Client) Request(req interface{}, rsp interface{}) (err error) {
+	protocolVersion, responded, err := c.request(req, rsp)
+	if !responded || protocolVersion == ProtocolVersion || c.onProtocolMismatch == nil {
+		return
+	}
+
+	_ = c.conn.Close()
+	err = c.onProtocolMismatch()
+	if err != nil {
+		return
+	}
+	err = c.connect(dial)
+	if err != nil {
+		return
+	}
+	rspValue := reflect.ValueOf(rsp).Elem()
+	rspValue.Set(reflect.Zero(rspValue.Type()))
+	_, _, err = c.request(req, rsp)
+	return
+}
+
+// request sends request and reads response, responded is false if response is not received or cannot be parsed.
+// protocolVersion is zero if the daemon doesn't send its version.
+func (c *Client) request(req interface{}, rsp interface{}) (protocolVersion int, responded bool, err error) {
 	reqString := MarshalRequest(req)
 	reqString = append(reqString, byte('\n'))
 	_, err = c.conn.Write(reqString)
@@ -64,7 +127,9 @@ func (c *Client) Request(req interface{}, rsp interface{}) (err error) {
 		err = fmt.Errorf("cannot read server response: %w", err)
 		return
 	}
-	err, warns := UnmarshalResponseToVar(data, rsp)
+	err, warns, protocolVersion := UnmarshalResponseToVar(data, rsp)
+	var errorResponse *ErrorResponse
+	responded = err == nil || errors.As(err, &errorResponse)
 	util.LogWarnings(warns)
 	return
 }
@@ -0,0 +1,95 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"path/filepath"
+
+	"github.com/dim-an/cod/util"
+)
+
+// Shells register completions by the name of executable, while different executables
+// with the same name (e.g. `/usr/bin/python3` and `~/.venv/bin/python3`) are learned separately.
+// Functions below decide which of them provides completions for the name.
+
+// GetPreferredExecutable returns selector of preferred executable for the name, or nil if none is configured.
+func (cfg *UserConfiguration) GetPreferredExecutable(name string) util.Selector {
+	for i := range cfg.Preferences {
+		if cfg.Preferences[i].Name == name {
+			return cfg.Preferences[i].compiledGlob
+		}
+	}
+	return nil
+}
+
+// resolveExecutable chooses which of the learned executables provides completions for the active one.
+// All learned executables must have the same name as the active one, activePath might be empty if
+// the name is not found in PATH.
+func (cfg *UserConfiguration) resolveExecutable(activePath string, learned []string) (resolved string, status ExecutableStatus) {
+	if len(learned) == 0 {
+		return
+	}
+
+	if selector := cfg.GetPreferredExecutable(filepath.Base(learned[0])); selector != nil {
+		for _, executablePath := range learned {
+			if selector.MatchString(executablePath) {
+				resolved = executablePath
+				status = ExecutableStatusPreferred
+				return
+			}
+		}
+	}
+
+	for _, executablePath := range learned {
+		if executablePath == activePath {
+			resolved = executablePath
+			status = ExecutableStatusActive
+			return
+		}
+	}
+
+	resolved = learned[0]
+	if activePath != "" {
+		status = ExecutableStatusFallback
+	}
+	return
+}
+
+// getLearnedExecutables returns sorted paths of learned executables grouped by their names.
+// Returned map is shared and must not be modified.
+func (s *serverImpl) getLearnedExecutables() map[string][]string {
+	return s.storage.GetExecutablesByName()
+}
+
+// resolveCompletedExecutable returns executable whose completions are used to complete command line of activePath.
+func (s *serverImpl) resolveCompletedExecutable(activePath string) (resolved string, status ExecutableStatus) {
+	byName := s.getLearnedExecutables()
+	resolved, status = s.getUserConfiguration().resolveExecutable(activePath, byName[filepath.Base(activePath)])
+	if resolved == "" {
+		resolved = activePath
+	}
+	return
+}
+
+// resolveExecutableName returns executable whose completions are used for the name
+// in the shell with given working directory and PATH.
+func (s *serverImpl) resolveExecutableName(name string, learned []string, dir, pathVar string) (resolved string, status ExecutableStatus) {
+	activePath, err := util.FindExecutable(name, dir, pathVar)
+	if err != nil {
+		activePath = ""
+	}
+	resolved, status = s.getUserConfiguration().resolveExecutable(activePath, learned)
+	return
+}
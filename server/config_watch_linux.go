@@ -0,0 +1,87 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/dim-an/cod/util"
+	"golang.org/x/sys/unix"
+)
+
+// watchUserConfiguration reloads user configuration whenever config file is changed.
+// Directory is watched instead of the file, since editors often replace the file with a new one.
+func (s *serverImpl) watchUserConfiguration() (err error) {
+	configFile := s.configuration.GetUserConfiguration()
+	configDir := filepath.Dir(configFile)
+	err = util.CreateDirIfNotExists(configDir)
+	if err != nil {
+		return
+	}
+
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		err = fmt.Errorf("cannot init inotify: %w", err)
+		return
+	}
+	mask := uint32(unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_DELETE)
+	_, err = unix.InotifyAddWatch(fd, configDir, mask)
+	if err != nil {
+		_ = unix.Close(fd)
+		err = fmt.Errorf("cannot watch %s: %w", configDir, err)
+		return
+	}
+
+	changes := make(chan struct{}, 1)
+	go s.reloadOnChangeProc(changes)
+	go readInotifyProc(fd, filepath.Base(configFile), changes)
+	return
+}
+
+func readInotifyProc(fd int, fileName string, changes chan<- struct{}) {
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := unix.Read(fd, buf)
+		if errors.Is(err, unix.EINTR) {
+			continue
+		} else if err != nil {
+			slog.Error("Cannot read inotify events", "error", err)
+			return
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			name := string(bytes.TrimRight(buf[nameStart:nameStart+int(event.Len)], "\x00"))
+			offset = nameStart + int(event.Len)
+
+			if name != fileName {
+				continue
+			}
+			select {
+			case changes <- struct{}{}:
+			default:
+				// Reload is already pending.
+			}
+		}
+	}
+}
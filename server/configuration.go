@@ -21,6 +21,9 @@ import (
 	"path"
 )
 
+// Longest path of unix socket supported on all systems, sun_path is 104 bytes long on macOS.
+const maxSocketPathLength = 100
+
 type Configuration struct {
 	// it's not real app name but either 'cod' or 'cod-test'
 	appName   string
@@ -28,6 +31,8 @@ type Configuration struct {
 	dataDir   string
 	runDir    string
 	homeDir   string
+	// Address the daemon listens, it differs from the socket file if its path is too long.
+	socketAddress string
 }
 
 func DefaultConfiguration() (cfg Configuration, err error) {
@@ -54,6 +59,9 @@ func DefaultConfiguration() (cfg Configuration, err error) {
 	dataDir = path.Join(dataDir, appName)
 
 	runDir := path.Join(dataDir, "var")
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); path.IsAbs(runtimeDir) {
+		runDir = path.Join(runtimeDir, appName)
+	}
 
 	homeDir := os.Getenv("HOME")
 
@@ -65,10 +73,31 @@ func DefaultConfiguration() (cfg Configuration, err error) {
 		homeDir:   homeDir,
 	}
 
-	if len(cfg.GetSocketFile()) > 100 {
-		err = fmt.Errorf("socket name %s is too long", cfg.GetSocketFile())
+	err = cfg.initSocketAddress()
+	return
+}
+
+func (cfg *Configuration) initSocketAddress() (err error) {
+	cfg.socketAddress = cfg.GetSocketFile()
+	if len(cfg.socketAddress) > maxSocketPathLength {
+		cfg.socketAddress, err = shortSocketAddress(cfg)
+		if err != nil {
+			err = fmt.Errorf("socket name %s is too long: %w", cfg.GetSocketFile(), err)
+		}
+	}
+	return
+}
+
+// GetLegacyConfiguration returns configuration of daemons that kept runtime files in the data directory
+// before $XDG_RUNTIME_DIR was used, ok is false if the run directory is the same.
+func (cfg *Configuration) GetLegacyConfiguration() (legacy Configuration, ok bool, err error) {
+	legacy = *cfg
+	legacy.runDir = path.Join(cfg.dataDir, "var")
+	if legacy.runDir == cfg.runDir {
 		return
 	}
+	ok = true
+	err = legacy.initSocketAddress()
 	return
 }
 
@@ -76,6 +105,18 @@ func (cfg *Configuration) GetHomeDir() string {
 	return cfg.homeDir
 }
 
+// GetConfigDir returns directory with user configuration.
+func (cfg *Configuration) GetConfigDir() string {
+	return cfg.configDir
+}
+
+// GetDataDir returns directory with all data files including database and logs.
+func (cfg *Configuration) GetDataDir() string {
+	return cfg.dataDir
+}
+
+// GetRunDir returns private directory with socket, lock and other runtime files of the daemon:
+// $XDG_RUNTIME_DIR/cod if XDG_RUNTIME_DIR is set, "var" in the data directory otherwise.
 func (cfg *Configuration) GetRunDir() string {
 	return cfg.runDir
 }
@@ -100,14 +141,50 @@ func (cfg *Configuration) GetSocketFile() string {
 	return path.Join(cfg.runDir, cfg.appName+".sock")
 }
 
+// GetSocketAddress returns address to listen and connect the daemon: path of the socket file
+// or a shorter address if the path doesn't fit into unix socket address.
+func (cfg *Configuration) GetSocketAddress() string {
+	return cfg.socketAddress
+}
+
 func (cfg *Configuration) GetLockFile() string {
 	return path.Join(cfg.runDir, cfg.appName+".lock")
 }
 
+// GetDataLockFile returns lock file held by the daemon using the data directory,
+// unlike lock in the run directory it is the same whatever XDG_RUNTIME_DIR is.
+func (cfg *Configuration) GetDataLockFile() string {
+	return path.Join(cfg.dataDir, cfg.appName+".lock")
+}
+
+// GetGenerationFile returns file whose content is changed by daemon whenever shells have updates to poll.
+func (cfg *Configuration) GetGenerationFile() string {
+	return path.Join(cfg.runDir, cfg.appName+".generation")
+}
+
+// GetCompletionStubDir returns directory with completion files loaded by the shell on first completion of a command.
+func (cfg *Configuration) GetCompletionStubDir(shell string) string {
+	return path.Join(cfg.runDir, "completion-stubs", shell)
+}
+
 func (cfg *Configuration) GetLogDir() string {
 	return path.Join(cfg.dataDir, "log")
 }
 
+// GetLogFile returns current log file of the daemon, rotated files have suffixes ".1", ".2" and so on.
+func (cfg *Configuration) GetLogFile() string {
+	return path.Join(cfg.GetLogDir(), cfg.appName+".log")
+}
+
+func (cfg *Configuration) GetMetricsDir() string {
+	return path.Join(cfg.dataDir, "metrics")
+}
+
+// GetMetricsFile returns Prometheus textfile written by daemon if it is enabled in user configuration.
+func (cfg *Configuration) GetMetricsFile() string {
+	return path.Join(cfg.GetMetricsDir(), cfg.appName+".prom")
+}
+
 func (cfg *Configuration) GetPidFile() string {
 	return path.Join(cfg.runDir, cfg.appName+".pid")
 }
@@ -0,0 +1,58 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// bumpGenerationLocked changes content of the generation file after updates are queued for shells.
+// Shells read the file at prompt with shell builtins and contact daemon only when it is changed.
+// Content includes start time of the daemon, so it never repeats after restart.
+// Must be called under shellsMutex.
+func (s *serverImpl) bumpGenerationLocked() {
+	s.generation += 1
+	content := fmt.Sprintf("%v.%v\n", s.startTime.UnixNano(), s.generation)
+	err := writeFileAtomically(s.configuration.GetGenerationFile(), []byte(content))
+	if err != nil {
+		slog.Error("Cannot write generation file", "error", err)
+	}
+}
+
+// writeFileAtomically replaces the file, so readers never see it partially written.
+func writeFileAtomically(fileName string, data []byte) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(fileName), filepath.Base(fileName)+".*")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmp.Name(), fileName)
+	return
+}
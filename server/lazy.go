@@ -0,0 +1,70 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/dim-an/cod/shells"
+	"github.com/dim-an/cod/util"
+)
+
+// Guards stub directories against concurrent init of several shells.
+var completionStubsMutex sync.Mutex
+
+// writeCompletionStubs makes stubDir contain completion stubs of the given executable names only.
+// Directory is shared by all shells of the same kind, so unchanged stubs are not rewritten.
+func writeCompletionStubs(stubDir string, generator shells.CompletionStubGenerator, names []string) (err error) {
+	completionStubsMutex.Lock()
+	defer completionStubsMutex.Unlock()
+
+	err = util.CreateDirIfNotExists(stubDir)
+	if err != nil {
+		return
+	}
+
+	stubs := make(map[string]bool)
+	for _, name := range names {
+		fileName, content := generator.CompletionStub(name)
+		stubs[fileName] = true
+
+		stubPath := filepath.Join(stubDir, fileName)
+		if current, readErr := os.ReadFile(stubPath); readErr == nil && bytes.Equal(current, []byte(content)) {
+			continue
+		}
+		err = writeFileAtomically(stubPath, []byte(content))
+		if err != nil {
+			return
+		}
+	}
+
+	entries, err := os.ReadDir(stubDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !stubs[entry.Name()] {
+			err = os.Remove(filepath.Join(stubDir, entry.Name()))
+			if err != nil && !os.IsNotExist(err) {
+				return
+			}
+			err = nil
+		}
+	}
+	return
+}
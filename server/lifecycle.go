@@ -0,0 +1,91 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Idle timeout used until user configuration is loaded.
+const defaultIdleTimeout = time.Minute
+
+// First file descriptor passed by systemd socket activation, see sd_listen_fds(3).
+const listenFdsStart = 3
+
+// socketActivationListener returns listener passed by systemd (or compatible) socket activation.
+// Listener is nil if the daemon is not socket activated.
+func socketActivationListener() (listener net.Listener, err error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	fdCount, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		err = fmt.Errorf("bad LISTEN_FDS: %w", err)
+		return
+	}
+	if fdCount != 1 {
+		err = fmt.Errorf("socket activation must pass exactly one socket, got %v", fdCount)
+		return
+	}
+	// Variables must not be inherited by commands run by the daemon.
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		_ = os.Unsetenv(name)
+	}
+
+	unix.CloseOnExec(listenFdsStart)
+	file := os.NewFile(listenFdsStart, "LISTEN_FD_3")
+	listener, err = net.FileListener(file)
+	_ = file.Close()
+	if err != nil {
+		err = fmt.Errorf("cannot use socket passed by socket activation: %w", err)
+	}
+	return
+}
+
+func (s *serverImpl) getIdleTimeout() time.Duration {
+	if userConfiguration := s.getUserConfiguration(); userConfiguration != nil {
+		return userConfiguration.GetIdleTimeout()
+	}
+	return defaultIdleTimeout
+}
+
+// updateIdleTimerLocked is called whenever shells or connections come and go.
+// Daemon without attached shells and open connections is stopped after idle timeout.
+// Must be called under shellsMutex.
+func (s *serverImpl) updateIdleTimerLocked() {
+	s.idleSeq += 1
+	if s.stopping || len(s.shellInfoMap) > 0 || len(s.connections) > 0 {
+		return
+	}
+
+	seq := s.idleSeq
+	timeout := s.getIdleTimeout()
+	time.AfterFunc(timeout, func() {
+		s.shellsMutex.Lock()
+		defer s.shellsMutex.Unlock()
+		if seq != s.idleSeq || s.stopping {
+			return
+		}
+		slog.Info("Daemon is idle, stopping", "timeout", timeout)
+		s.stopServing()
+	})
+}
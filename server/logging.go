@@ -0,0 +1,93 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	// Log file is rotated when it gets bigger than this size.
+	MaxLogFileSize = 10 * 1024 * 1024
+	// Number of rotated log files that are kept.
+	LogFileBackups = 5
+
+	// Strings longer than this are truncated when requests and responses are logged.
+	maxLoggedStringLength = 200
+)
+
+// Level of daemon logs, it is changed when user configuration is loaded.
+var logLevel = new(slog.LevelVar)
+
+// SetupLogging makes slog and standard log packages write to w with level from user configuration.
+func SetupLogging(w io.Writer) {
+	handler := slog.NewTextHandler(w, &slog.HandlerOptions{Level: logLevel})
+	slog.SetDefault(slog.New(handler).With("pid", os.Getpid()))
+}
+
+// ParseLogLevel parses level name used in configuration and `cod logs --level`: debug, info, warn or error.
+func ParseLogLevel(name string) (level slog.Level, err error) {
+	err = level.UnmarshalText([]byte(name))
+	if err != nil {
+		err = fmt.Errorf("unknown log level %q, expected debug, info, warn or error", name)
+	}
+	return
+}
+
+// redactMessage returns JSON message suitable for logging: values of environment variables
+// are hidden and long strings (e.g. help pages) are truncated.
+func redactMessage(data []byte) string {
+	var message interface{}
+	err := json.Unmarshal(data, &message)
+	if err != nil {
+		return fmt.Sprintf("<malformed message of %v bytes>", len(data))
+	}
+	var redacted strings.Builder
+	encoder := json.NewEncoder(&redacted)
+	encoder.SetEscapeHTML(false)
+	err = encoder.Encode(redactValue(message, false))
+	if err != nil {
+		return fmt.Sprintf("<message of %v bytes>", len(data))
+	}
+	return strings.TrimSuffix(redacted.String(), "\n")
+}
+
+func redactValue(value interface{}, isEnv bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = redactValue(item, key == "Env")
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(item, isEnv)
+		}
+	case string:
+		if isEnv {
+			if name, _, found := strings.Cut(v, "="); found {
+				return name + "=<redacted>"
+			}
+		}
+		if len(v) > maxLoggedStringLength {
+			return fmt.Sprintf("%v...<%v bytes>", v[:maxLoggedStringLength], len(v))
+		}
+	}
+	return value
+}
@@ -0,0 +1,278 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dim-an/cod/util"
+)
+
+// Upper bounds of latency histogram buckets, the last bucket is unbounded.
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// Prometheus textfile is rewritten this often when it is enabled in user configuration.
+const metricsWriteInterval = 15 * time.Second
+
+type requestMetrics struct {
+	count         uint64
+	errors        uint64
+	totalDuration time.Duration
+	// Not cumulative, the last item counts requests slower than any bound.
+	buckets []uint64
+}
+
+type parserMetrics struct {
+	successes uint64
+	failures  uint64
+}
+
+// metrics are kept in memory and reset when daemon is restarted.
+type metrics struct {
+	mutex          sync.Mutex
+	requests       map[string]*requestMetrics
+	parsers        map[string]*parserMetrics
+	learnFailures  uint64
+	updateFailures uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests: make(map[string]*requestMetrics),
+		parsers:  make(map[string]*parserMetrics),
+	}
+}
+
+func (m *metrics) getRequestLocked(name string) *requestMetrics {
+	r, ok := m.requests[name]
+	if !ok {
+		r = &requestMetrics{buckets: make([]uint64, len(latencyBuckets)+1)}
+		m.requests[name] = r
+	}
+	return r
+}
+
+func (m *metrics) observeRequest(name string, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	r := m.getRequestLocked(name)
+	r.count += 1
+	r.totalDuration += duration
+	i := sort.Search(len(latencyBuckets), func(i int) bool {
+		return duration <= latencyBuckets[i]
+	})
+	r.buckets[i] += 1
+}
+
+func (m *metrics) recordRequestError(name string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.getRequestLocked(name).errors += 1
+}
+
+// observeParser is parse_doc.ParserObserver.
+func (m *metrics) observeParser(parser string, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	p, ok := m.parsers[parser]
+	if !ok {
+		p = &parserMetrics{}
+		m.parsers[parser] = p
+	}
+	if err == nil {
+		p.successes += 1
+	} else {
+		p.failures += 1
+	}
+}
+
+func (m *metrics) recordLearnFailure() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.learnFailures += 1
+}
+
+func (m *metrics) recordUpdateFailure() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.updateFailures += 1
+}
+
+// fill copies counters to the response, items are sorted by name.
+func (m *metrics) fill(rsp *StatsResponse) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for name, r := range m.requests {
+		stats := RequestStats{
+			Request:       name,
+			Count:         r.count,
+			Errors:        r.errors,
+			TotalDuration: r.totalDuration,
+		}
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += r.buckets[i]
+			stats.Buckets = append(stats.Buckets, LatencyBucket{UpperBound: bound, Count: cumulative})
+		}
+		rsp.Requests = append(rsp.Requests, stats)
+	}
+	sort.Slice(rsp.Requests, func(i, j int) bool {
+		return rsp.Requests[i].Request < rsp.Requests[j].Request
+	})
+
+	for name, p := range m.parsers {
+		rsp.Parsers = append(rsp.Parsers, ParserStats{
+			Parser:    name,
+			Successes: p.successes,
+			Failures:  p.failures,
+		})
+	}
+	sort.Slice(rsp.Parsers, func(i, j int) bool {
+		return rsp.Parsers[i].Parser < rsp.Parsers[j].Parser
+	})
+
+	rsp.LearnFailures = m.learnFailures
+	rsp.UpdateFailures = m.updateFailures
+}
+
+func (s *serverImpl) handleStats(_ *StatsRequest, _ *util.Warner) (rsp StatsResponse, err error) {
+	rsp.StartTime = s.startTime
+	s.metrics.fill(&rsp)
+
+	commands, err := s.storage.ListCommands()
+	if err != nil {
+		return
+	}
+	pageCounts := make(map[string]int)
+	for _, command := range commands {
+		if command != nil && len(command.Args) > 0 {
+			pageCounts[command.Args[0]] += 1
+		}
+	}
+	for executablePath, count := range pageCounts {
+		rsp.Executables = append(rsp.Executables, ExecutableStats{
+			ExecutablePath: executablePath,
+			HelpPages:      count,
+		})
+	}
+	sort.Slice(rsp.Executables, func(i, j int) bool {
+		return rsp.Executables[i].ExecutablePath < rsp.Executables[j].ExecutablePath
+	})
+	return
+}
+
+// writeMetricsProc keeps Prometheus textfile up to date while daemon is running.
+func (s *serverImpl) writeMetricsProc() {
+	ticker := time.NewTicker(metricsWriteInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.shellsMutex.Lock()
+		stopping := s.stopping
+		s.shellsMutex.Unlock()
+		if stopping {
+			return
+		}
+		s.writeMetricsFile()
+	}
+}
+
+func (s *serverImpl) writeMetricsFile() {
+	userConfiguration := s.getUserConfiguration()
+	if userConfiguration == nil || !userConfiguration.MetricsTextfile {
+		return
+	}
+	rsp, err := s.handleStats(&StatsRequest{}, nil)
+	if err == nil {
+		err = util.CreateDirIfNotExists(s.configuration.GetMetricsDir())
+	}
+	if err == nil {
+		var buffer bytes.Buffer
+		WritePrometheusMetrics(&buffer, &rsp)
+		err = writeFileAtomically(s.configuration.GetMetricsFile(), buffer.Bytes())
+	}
+	if err != nil {
+		slog.Warn("Cannot write metrics file", "error", err)
+	}
+}
+
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WritePrometheusMetrics writes stats in Prometheus text exposition format,
+// suitable for textfile collector of node_exporter.
+func WritePrometheusMetrics(w io.Writer, stats *StatsResponse) {
+	label := func(value string) string {
+		return `"` + prometheusLabelEscaper.Replace(value) + `"`
+	}
+	header := func(name, metricType, help string) {
+		_, _ = fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, metricType)
+	}
+
+	header("cod_daemon_start_time_seconds", "gauge", "Start time of cod daemon since unix epoch in seconds.")
+	_, _ = fmt.Fprintf(w, "cod_daemon_start_time_seconds %v\n", stats.StartTime.Unix())
+
+	header("cod_requests_total", "counter", "Requests handled by cod daemon.")
+	for _, r := range stats.Requests {
+		_, _ = fmt.Fprintf(w, "cod_requests_total{request=%v} %v\n", label(r.Request), r.Count)
+	}
+	header("cod_request_errors_total", "counter", "Requests failed with error.")
+	for _, r := range stats.Requests {
+		_, _ = fmt.Fprintf(w, "cod_request_errors_total{request=%v} %v\n", label(r.Request), r.Errors)
+	}
+	header("cod_request_duration_seconds", "histogram", "Time spent handling requests.")
+	for _, r := range stats.Requests {
+		for _, b := range r.Buckets {
+			_, _ = fmt.Fprintf(w, "cod_request_duration_seconds_bucket{request=%v,le=\"%v\"} %v\n",
+				label(r.Request), b.UpperBound.Seconds(), b.Count)
+		}
+		_, _ = fmt.Fprintf(w, "cod_request_duration_seconds_bucket{request=%v,le=\"+Inf\"} %v\n", label(r.Request), r.Count)
+		_, _ = fmt.Fprintf(w, "cod_request_duration_seconds_sum{request=%v} %v\n", label(r.Request), r.TotalDuration.Seconds())
+		_, _ = fmt.Fprintf(w, "cod_request_duration_seconds_count{request=%v} %v\n", label(r.Request), r.Count)
+	}
+
+	header("cod_parser_results_total", "counter", "Help pages parsed by each parser, parsers are tried in order until one succeeds.")
+	for _, p := range stats.Parsers {
+		_, _ = fmt.Fprintf(w, "cod_parser_results_total{parser=%v,result=\"success\"} %v\n", label(p.Parser), p.Successes)
+		_, _ = fmt.Fprintf(w, "cod_parser_results_total{parser=%v,result=\"failure\"} %v\n", label(p.Parser), p.Failures)
+	}
+
+	header("cod_learn_failures_total", "counter", "Help commands that could not be learned.")
+	_, _ = fmt.Fprintf(w, "cod_learn_failures_total %v\n", stats.LearnFailures)
+	header("cod_update_failures_total", "counter", "Help commands that could not be run to update completions.")
+	_, _ = fmt.Fprintf(w, "cod_update_failures_total %v\n", stats.UpdateFailures)
+
+	header("cod_help_pages", "gauge", "Learned help pages of the executable.")
+	for _, e := range stats.Executables {
+		_, _ = fmt.Fprintf(w, "cod_help_pages{executable=%v} %v\n", label(e.ExecutablePath), e.HelpPages)
+	}
+}
@@ -0,0 +1,22 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !cod_old_protocol
+
+package server
+
+// ProtocolVersion is incremented whenever requests or responses change incompatibly.
+// It is sent with every request and response, client that finds daemon speaking older protocol
+// makes it hand over attached shells to the daemon started from the client binary.
+const ProtocolVersion = 6
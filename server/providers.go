@@ -0,0 +1,183 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dim-an/cod/datastore"
+	"github.com/dim-an/cod/util"
+)
+
+// Providers might depend on any variable (e.g. KUBECONFIG or AWS_PROFILE),
+// so values are cached for the whole environment of the request.
+type providerCacheKey struct {
+	command string
+	dir     string
+	envHash string
+}
+
+type providerCacheEntry struct {
+	values  []string
+	expires time.Time
+}
+
+type providerCache struct {
+	mutex   sync.Mutex
+	entries map[providerCacheKey]providerCacheEntry
+}
+
+func (c *providerCache) get(key providerCacheKey) (values []string, ok bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	if ok && time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+	values = entry.values
+	return
+}
+
+func (c *providerCache) put(key providerCacheKey, values []string, ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[providerCacheKey]providerCacheEntry)
+	}
+	now := time.Now()
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = providerCacheEntry{
+		values:  values,
+		expires: now.Add(ttl),
+	}
+}
+
+// HasProviders checks if any provider is configured for the executable.
+func (cfg *UserConfiguration) HasProviders(executablePath string) bool {
+	for i := range cfg.Providers {
+		if cfg.Providers[i].compiledGlob.MatchString(executablePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindProvider returns first provider that is configured for the word being completed.
+func (cfg *UserConfiguration) FindProvider(
+	completions []datastore.Completion,
+	commandPrefix []string,
+	word string,
+) (provider *Provider, slot valueSlot) {
+	for i := range cfg.Providers {
+		p := &cfg.Providers[i]
+		if !p.compiledGlob.MatchString(commandPrefix[0]) {
+			continue
+		}
+		if !datastore.IsCommandMatchingContext(commandPrefix, datastore.FlagContext{SubCommand: p.SubCommand}) {
+			continue
+		}
+		if len(p.Flag) > 0 {
+			var ok bool
+			slot, ok = splitValueSlot(commandPrefix, word)
+			if ok && slot.flag == strings.TrimSuffix(p.Flag, "=") {
+				provider = p
+				return
+			}
+		} else if findPositionalIndex(completions, commandPrefix, p.SubCommand, word) == p.Positional {
+			slot = valueSlot{valuePrefix: word}
+			provider = p
+			return
+		}
+	}
+	slot = valueSlot{}
+	return
+}
+
+func parseProviderOutput(output []byte) (values []string) {
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) > 0 {
+			values = append(values, line)
+		}
+	}
+	return
+}
+
+func runProvider(provider *Provider, dir string, env []string, timeout time.Duration) (values []string, err error) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), timeout)
+	defer cancelFunc()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", provider.Command)
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stdin = nil
+
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		switch {
+		case ctx.Err() != nil && errors.Is(ctx.Err(), context.DeadlineExceeded):
+			err = fmt.Errorf("timeout of %v exceeded running provider `%v`", timeout, provider.Command)
+		case errors.As(err, &exitErr):
+			err = fmt.Errorf("provider `%v` failed: %w: %v", provider.Command, err, string(exitErr.Stderr))
+		default:
+			err = fmt.Errorf("provider `%v` failed: %w", provider.Command, err)
+		}
+		return
+	}
+	values = parseProviderOutput(output)
+	return
+}
+
+// getProviderValues runs provider or takes its output from cache.
+func (s *serverImpl) getProviderValues(provider *Provider, dir string, env []string, timeout time.Duration) (values []string, err error) {
+	sortedEnv := append([]string(nil), env...)
+	sort.Strings(sortedEnv)
+	key := providerCacheKey{
+		command: provider.Command,
+		dir:     dir,
+		envHash: util.HashStrings(sortedEnv),
+	}
+	values, ok := s.providerCache.get(key)
+	if ok {
+		return
+	}
+
+	values, err = runProvider(provider, dir, env, timeout)
+	if err != nil {
+		return
+	}
+	if provider.Ttl > 0 {
+		s.providerCache.put(key, values, provider.GetTtl())
+	}
+	return
+}
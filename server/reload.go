@@ -0,0 +1,91 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/dim-an/cod/util"
+)
+
+// Editors usually write a file with several system calls, reload waits until they are done.
+const reloadDelay = 100 * time.Millisecond
+
+func (s *serverImpl) getUserConfiguration() *UserConfiguration {
+	return s.userConfiguration.Load()
+}
+
+// ReloadUserConfiguration loads user configuration again.
+// Current configuration is kept if the new one cannot be loaded,
+// the error is reported to attached shells on their next poll.
+func (s *serverImpl) ReloadUserConfiguration() {
+	s.shellsMutex.Lock()
+	initialized := s.initialized
+	stopping := s.stopping
+	s.shellsMutex.Unlock()
+	if !initialized || stopping {
+		// Configuration is loaded on first request, storage is closed once daemon is stopped.
+		return
+	}
+
+	slog.Info("Reloading user configuration")
+	userConfiguration, err := LoadUserConfiguration(
+		s.configuration.GetUserConfiguration(),
+		s.configuration.GetHomeDir(),
+	)
+	if err != nil {
+		slog.Error("Cannot reload user configuration", "error", err)
+		warning := util.Warning{
+			Warning: fmt.Sprintf("cannot reload configuration, previous one is used: %v", err),
+		}
+		s.shellsMutex.Lock()
+		for _, info := range s.shellInfoMap {
+			info.warnings = append(info.warnings, warning)
+		}
+		s.bumpGenerationLocked()
+		s.shellsMutex.Unlock()
+		return
+	}
+	s.userConfiguration.Store(&userConfiguration)
+	logLevel.Set(userConfiguration.GetLogLevel())
+
+	// Providers and preferences affect generated completions, so they are regenerated in all shells.
+	executablePaths, err := s.storage.ListExecutables()
+	if err != nil {
+		slog.Error("Cannot list executables", "error", err)
+		return
+	}
+	for _, executablePath := range executablePaths {
+		s.notifyExecutableUpdate(executablePath)
+	}
+}
+
+// reloadOnChangeProc reloads user configuration after each change of the file, changes made in quick succession are merged.
+func (s *serverImpl) reloadOnChangeProc(changes <-chan struct{}) {
+	for range changes {
+		time.Sleep(reloadDelay)
+	drain:
+		for {
+			select {
+			case <-changes:
+			default:
+				break drain
+			}
+		}
+		s.ReloadUserConfiguration()
+	}
+}
@@ -18,15 +18,134 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"time"
 
 	"github.com/dim-an/cod/datastore"
 	"github.com/dim-an/cod/util"
 )
 
+// isDaemonManagementRequest reports if request is served regardless of protocol version of the client,
+// so clients can learn version of the daemon and make it hand over its shells.
+func isDaemonManagementRequest(name string) bool {
+	switch name {
+	case "AttachRequest", "VersionRequest", "HandoverRequest", "StatusRequest", "StopRequest":
+		return true
+	default:
+		return false
+	}
+}
+
+type VersionRequest struct {
+}
+
+type VersionResponse struct {
+	ProtocolVersion int
+	// Version of cod binary the daemon is started from.
+	Version string
+	Pid     int
+}
+
+// HandoverRequest asks daemon to stop serving and return attached shells,
+// so they can be attached to the daemon started from other cod binary.
+type HandoverRequest struct {
+}
+
+type HandedOverShell struct {
+	Shell         string
+	Pid           int
+	CodBinaryPath string
+	Dir           string
+	PathVar       string
+}
+
+type HandoverResponse struct {
+	Shells []HandedOverShell
+}
+
+type StatusRequest struct {
+}
+
+type ErrorRecord struct {
+	Time    time.Time
+	Request string
+	Message string
+}
+
+type StatusResponse struct {
+	Pid             int
+	Version         string
+	ProtocolVersion int
+	StartTime       time.Time
+	SocketPath      string
+	Shells          []ShellAndPid
+
+	DbPath        string
+	DbSize        int64
+	HelpPageCount int
+
+	// Requests being processed by the daemon except the status request itself.
+	PendingJobs int
+	LastErrors  []ErrorRecord
+}
+
+// StatsRequest returns counters collected by the daemon since it is started.
+type StatsRequest struct {
+}
+
+// LatencyBucket counts requests that took no longer than UpperBound,
+// buckets are cumulative like buckets of Prometheus histograms.
+type LatencyBucket struct {
+	UpperBound time.Duration
+	Count      uint64
+}
+
+type RequestStats struct {
+	Request       string
+	Count         uint64
+	Errors        uint64
+	TotalDuration time.Duration
+	Buckets       []LatencyBucket
+}
+
+// ParserStats counts help pages parsed by the parser, parsers are tried in order until one succeeds.
+type ParserStats struct {
+	Parser    string
+	Successes uint64
+	Failures  uint64
+}
+
+type ExecutableStats struct {
+	ExecutablePath string
+	HelpPages      int
+}
+
+type StatsResponse struct {
+	StartTime      time.Time
+	Requests       []RequestStats
+	Parsers        []ParserStats
+	LearnFailures  uint64
+	UpdateFailures uint64
+	Executables    []ExecutableStats
+}
+
+// StopRequest asks daemon to detach all shells and exit.
+type StopRequest struct {
+}
+
+type StopResponse struct {
+}
+
 type AttachRequest struct {
+	ProtocolVersion int
+
 	Shell         string
 	Pid           int
 	CodBinaryPath string
+
+	// Working directory and PATH of the shell, used to decide which of the same-named executables
+	// gets completions registered in the shell.
+	Dir     string
+	PathVar string
 }
 
 type AttachResponse struct {
@@ -36,10 +155,50 @@ type CompleteWordsRequest struct {
 	// First word of the `Words` must be executable path.
 	Words []string
 	CWord int
+
+	// Working directory and environment of the shell, used to run value providers.
+	Dir string
+	Env []string
+
+	// Pid of the shell, warnings are shown by the shell on its next poll. Zero if unknown.
+	Pid int `json:",omitempty"`
+}
+
+// CompletionItemVersion is incremented whenever CompletionItem changes incompatibly.
+const CompletionItemVersion = 1
+
+type CompletionKind string
+
+const (
+	CompletionKindFlag       CompletionKind = "flag"
+	CompletionKindSubCommand CompletionKind = "subcommand"
+	CompletionKindValue      CompletionKind = "value"
+)
+
+type CompletionItem struct {
+	// Text that replaces the word being completed.
+	Value string
+	// Text shown to user in completion menu (e.g. `--output FILE`).
+	Display     string
+	Description string
+	Kind        CompletionKind
+	// Name of the group this item is shown in, e.g. "flag" or "subcommand".
+	Group string
+	// Shell must not add space after the value (e.g. `--output=`).
+	NoSpace bool
 }
 
 type CompleteWordsResponse struct {
-	Completions []string
+	Version int
+	Items   []CompletionItem
+	// Executable whose completions are used.
+	// It differs from the first word of the request if the executable is not learned
+	// but another one with the same name is.
+	ExecutablePath   string
+	ExecutableStatus ExecutableStatus
+	// Kind of the value being completed, empty if completed word is not a value of known flag.
+	// Shell uses it to decide whether its own completion (e.g. files) should be added.
+	ValueKind datastore.ValueKind
 }
 
 type DetachRequest struct {
@@ -51,12 +210,26 @@ type DetachResponse struct {
 
 type InitScriptRequest struct {
 	Pid int
+	// Lazy asks for a script that registers completions of a command on its first completion
+	// instead of registering all learned executables.
+	Lazy bool
 }
 
 type InitScriptResponse struct {
 	Script []string
 }
 
+// DeinitScriptRequest asks for a script that removes cod from the shell.
+// Shell is not detached by this request, client sends DetachRequest afterwards.
+type DeinitScriptRequest struct {
+	Pid   int
+	Shell string
+}
+
+type DeinitScriptResponse struct {
+	Script []string
+}
+
 type ShellAndPid struct {
 	Shell string
 	Pid   int
@@ -64,18 +237,104 @@ type ShellAndPid struct {
 
 type ListCommandsRequest struct {
 	Selectors []string
-}
+
+	// Working directory and PATH of the client, used to find executables that are currently active.
+	// If PathVar is empty Status of response items is not filled.
+	Dir     string
+	PathVar string
+}
+
+// ExecutableStatus tells whose completions are used for the name of the executable
+// when several learned executables share the same name.
+type ExecutableStatus string
+
+const (
+	// Name is not ambiguous or cannot be resolved.
+	ExecutableStatusUnknown = ExecutableStatus("")
+	// Executable is found first in PATH.
+	ExecutableStatusActive = ExecutableStatus("active")
+	// Executable is selected by `prefer` section of configuration.
+	ExecutableStatusPreferred = ExecutableStatus("preferred")
+	// Executable found in PATH is not learned, completions of same-named executable are used instead.
+	ExecutableStatusFallback = ExecutableStatus("fallback")
+	// Completions of other executable are used for the name.
+	ExecutableStatusShadowed = ExecutableStatus("shadowed")
+)
 
 type ListCommandsResponseItem struct {
 	Id int64
 
 	// In rare cases Command might be empty.
 	Command *datastore.Command
+
+	Status ExecutableStatus `json:",omitempty"`
 }
 type ListCommandsResponse struct {
 	CommandItems []ListCommandsResponseItem
 }
 
+// ListCompletionsRequest asks for learned completions of executables matching selectors.
+type ListCompletionsRequest struct {
+	Selectors []string
+}
+
+type ExecutableCompletions struct {
+	ExecutablePath string
+	Completions    []datastore.Completion
+	// Preferred is set if [[prefer]] section of user configuration selects the executable for its name.
+	Preferred bool
+}
+
+type ListCompletionsResponse struct {
+	Executables []ExecutableCompletions
+}
+
+// ExportRequest asks for learned help pages of commands matching selectors.
+type ExportRequest struct {
+	Selectors []string
+}
+
+type ExportResponse struct {
+	HelpPages []datastore.BundleHelpPage
+}
+
+// ImportStrategy tells what to do with imported help page if the same command is already learned.
+type ImportStrategy string
+
+const (
+	// Keep help page that is already learned.
+	ImportStrategySkip = ImportStrategy("skip")
+	// Replace learned help page with imported one.
+	ImportStrategyOverwrite = ImportStrategy("overwrite")
+	// Replace learned help page if imported one is learned later.
+	ImportStrategyNewerWins = ImportStrategy("newer-wins")
+)
+
+type ImportRequest struct {
+	HelpPages []datastore.BundleHelpPage
+	Strategy  ImportStrategy
+
+	// Trust policy makes cod run commands without asking, so it is imported only if asked explicitly.
+	// Otherwise trusted commands get policy from user configuration as never seen ones.
+	KeepTrust bool
+
+	// Environment of the client, imported commands are run with it when help pages are updated.
+	Env []string
+}
+
+type ImportStatus string
+
+const (
+	ImportStatusNew     = ImportStatus("new")
+	ImportStatusUpdated = ImportStatus("updated")
+	ImportStatusSkipped = ImportStatus("skipped")
+)
+
+type ImportResponse struct {
+	// Status of each help page of the request in the same order.
+	Statuses []ImportStatus
+}
+
 type ListClientsRequest struct {
 }
 
@@ -122,6 +381,35 @@ type PollUpdatesResponse struct {
 	Script []string
 }
 
+// PromptHookRequest is sent by shell at prompt, it checks the last executed command
+// like ParseCommandLineRequest (unless CommandLine is empty) and polls updates like PollUpdatesRequest.
+type PromptHookRequest struct {
+	Pid         int
+	CommandLine string
+	Dir         string
+	Env         []string
+}
+
+type PromptHookResponse struct {
+	Command ParseCommandLineResponse
+	Script  []string
+	// Shell the pid is attached as, script produced by the client is written in its language.
+	Shell string
+}
+
+// LazyCompletionsRequest is sent by the default completer installed by lazy init script
+// when it meets a command without registered completions.
+type LazyCompletionsRequest struct {
+	Pid  int
+	Name string
+}
+
+// LazyCompletionsResponse contains script registering completions of the command,
+// it is empty if the command is not learned.
+type LazyCompletionsResponse struct {
+	Script []string
+}
+
 type UpdateHelpPageRequest struct {
 	Id      int64
 	Command datastore.Command
@@ -165,27 +453,49 @@ func getMessageName(msg interface{}) string {
 func isRequest(msg interface{}) bool {
 	switch msg.(type) {
 	case *AttachRequest,
+		*VersionRequest,
+		*HandoverRequest,
+		*StatusRequest,
+		*StatsRequest,
+		*StopRequest,
 		*CompleteWordsRequest,
 		*DetachRequest,
 		*InitScriptRequest,
+		*DeinitScriptRequest,
 		*ListClientsRequest,
 		*ListCommandsRequest,
+		*ListCompletionsRequest,
+		*ExportRequest,
+		*ImportRequest,
 		*RemoveCommandsRequest,
 		*AddHelpPageRequest,
 		*ParseCommandLineRequest,
 		*PollUpdatesRequest,
+		*PromptHookRequest,
+		*LazyCompletionsRequest,
 		*UpdateHelpPageRequest:
 		return true
 	case *AttachResponse,
+		*VersionResponse,
+		*HandoverResponse,
+		*StatusResponse,
+		*StatsResponse,
+		*StopResponse,
 		*CompleteWordsResponse,
 		*DetachResponse,
 		*InitScriptResponse,
+		*DeinitScriptResponse,
 		*ListClientsResponse,
 		*ListCommandsResponse,
+		*ListCompletionsResponse,
+		*ExportResponse,
+		*ImportResponse,
 		*RemoveCommandsResponse,
 		*AddHelpPageResponse,
 		*ParseCommandLineResponse,
 		*PollUpdatesResponse,
+		*PromptHookResponse,
+		*LazyCompletionsResponse,
 		*UpdateHelpPageResponse:
 		return false
 	default:
@@ -194,22 +504,25 @@ func isRequest(msg interface{}) bool {
 }
 
 type requestOnWire struct {
-	Request string
-	Payload interface{}
+	Request         string
+	ProtocolVersion int `json:",omitempty"`
+	Payload         interface{}
 }
 
 type responseOnWire struct {
-	Response interface{}
-	Error    *ErrorResponse `json:",omitempty"`
-	Warnings []util.Warning `json:",omitempty"`
+	ProtocolVersion int `json:",omitempty"`
+	Response        interface{}
+	Error           *ErrorResponse `json:",omitempty"`
+	Warnings        []util.Warning `json:",omitempty"`
 }
 
 func MarshalRequest(req interface{}) (bytes []byte) {
 	verifyRequestType(req)
 
 	wire := requestOnWire{
-		Request: getMessageName(req),
-		Payload: req,
+		Request:         getMessageName(req),
+		ProtocolVersion: ProtocolVersion,
+		Payload:         req,
 	}
 
 	bytes, err := json.Marshal(&wire)
@@ -219,7 +532,7 @@ func MarshalRequest(req interface{}) (bytes []byte) {
 	return
 }
 
-func UnmarshalRequest(data []byte) (name string, payload interface{}, err error) {
+func UnmarshalRequest(data []byte) (name string, protocolVersion int, payload interface{}, err error) {
 	wire := requestOnWire{
 		Payload: &payload,
 	}
@@ -228,6 +541,7 @@ func UnmarshalRequest(data []byte) (name string, payload interface{}, err error)
 		return
 	}
 	name = wire.Request
+	protocolVersion = wire.ProtocolVersion
 	return
 }
 
@@ -242,16 +556,18 @@ func MarshalResponse(rsp interface{}, e error, warns []util.Warning) (bytes []by
 	verifyResponseType(rsp)
 
 	wire := responseOnWire{
-		Response: rsp,
-		Error:    toErrorResponse(e),
-		Warnings: warns,
+		ProtocolVersion: ProtocolVersion,
+		Response:        rsp,
+		Error:           toErrorResponse(e),
+		Warnings:        warns,
 	}
 	bytes, err := json.Marshal(&wire)
 	util.VerifyPanic(err)
 	return
 }
 
-func UnmarshalResponseToVar(data []byte, rsp interface{}) (err error, warns []util.Warning) {
+// UnmarshalResponseToVar parses response, protocolVersion is zero if the daemon doesn't send its version.
+func UnmarshalResponseToVar(data []byte, rsp interface{}) (err error, warns []util.Warning, protocolVersion int) {
 	verifyResponseType(rsp)
 
 	wire := responseOnWire{
@@ -262,6 +578,7 @@ func UnmarshalResponseToVar(data []byte, rsp interface{}) (err error, warns []ut
 	if err != nil {
 		return
 	}
+	protocolVersion = wire.ProtocolVersion
 
 	if wire.Error != nil {
 		err = wire.Error
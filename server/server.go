@@ -20,8 +20,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"log/slog"
 	"net"
 	"os"
 	"os/exec"
@@ -31,6 +30,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dim-an/cod/datastore"
@@ -42,30 +42,59 @@ import (
 
 type Server interface {
 	Serve() error
+	Stop()
+	ReloadUserConfiguration()
 	Close() error
 }
 
-func NewServer(cfg *Configuration) (server Server, err error) {
+func NewServer(cfg *Configuration, version string) (server Server, err error) {
 	serverImpl := &serverImpl{
 		configuration: cfg,
+		version:       version,
+		startTime:     time.Now(),
 		shellInfoMap:  make(map[int]*shellInfo),
+		connections:   make(map[net.Conn]bool),
+		metrics:       newMetrics(),
+	}
+	go serverImpl.removeLegacyLogs()
+	go serverImpl.writeMetricsProc()
+
+	err = serverImpl.watchUserConfiguration()
+	if err != nil {
+		slog.Warn("Config file is not watched", "error", err)
+		err = nil
 	}
-	go serverImpl.waitAttach()
-	go serverImpl.trimLogs()
 
 	err = serverImpl.listen()
 	if err != nil {
 		return
 	}
+	serverImpl.shellsMutex.Lock()
+	serverImpl.bumpGenerationLocked()
+	// Daemon that nobody connects to exits after idle timeout.
+	serverImpl.updateIdleTimerLocked()
+	serverImpl.shellsMutex.Unlock()
 	server = serverImpl
 	return
 }
 
+// shellInfo fields are not changed after attach, except those guarded by serverImpl.shellsMutex.
 type shellInfo struct {
-	pid                 int
-	shell               string
-	scriptGenerator     shells.ShellScriptGenerator
+	pid             int
+	shell           string
+	codBinaryPath   string
+	scriptGenerator shells.ShellScriptGenerator
+
+	// Working directory and PATH of the shell at the moment of attach.
+	dir     string
+	pathVar string
+
+	// Guarded by serverImpl.shellsMutex.
 	executablesToUpdate map[string]bool
+	// Warnings shown to the shell on its next poll, guarded by serverImpl.shellsMutex.
+	warnings []util.Warning
+	// Warnings queued by warnShellOnce, guarded by serverImpl.shellsMutex.
+	shownWarnings map[string]bool
 }
 
 type serverImpl struct {
@@ -73,21 +102,44 @@ type serverImpl struct {
 
 	wg sync.WaitGroup
 
-	mutex         sync.Mutex
-	initialized   bool
-	configuration *Configuration
-	shellInfoMap  map[int]*shellInfo
-	storage       datastore.Storage
+	// shellsMutex guards attached shells and daemon lifecycle. Storage is safe for concurrent use
+	// and is never accessed under this mutex, so completions don't wait for learning or updates.
+	shellsMutex  sync.Mutex
+	initialized  bool
+	stopping     bool
+	shellInfoMap map[int]*shellInfo
+	// Open connections, their reading is interrupted when listener is closed.
+	connections    map[net.Conn]bool
+	listenerClosed bool
+	// Counter of updates written to the generation file.
+	generation uint64
+	// Incremented whenever daemon becomes idle or busy, idle timer stops the daemon
+	// only if it is not changed since the timer was started.
+	idleSeq uint64
+	// Set once on first request that needs it before initialized is set.
+	storage *datastore.IndexedStorage
 
-	userConfiguration UserConfiguration
+	configuration *Configuration
+	version       string
+	startTime     time.Time
+
+	activeRequests int32
+	requestCount   uint64
+	errorsMutex    sync.Mutex
+	lastErrors     []ErrorRecord
+	metrics        *metrics
+
+	// Replaced as a whole on reload, so handlers never see partially loaded configuration.
+	userConfiguration atomic.Pointer[UserConfiguration]
+	providerCache     providerCache
 }
 
 func (s *serverImpl) Serve() (err error) {
-	log.Printf("Start serving requests")
+	slog.Info("Start serving requests")
 	for {
 		conn, err := s.listener.Accept()
 		if err != nil {
-			log.Printf("Error accepting connection: %v", err)
+			slog.Info("Stop accepting connections", "error", err)
 			break
 		}
 		s.wg.Add(1)
@@ -95,30 +147,34 @@ func (s *serverImpl) Serve() (err error) {
 	}
 
 	s.wg.Wait()
+	s.writeMetricsFile()
 	return
 }
 
 func (s *serverImpl) Close() (err error) {
-	return
-}
+	s.shellsMutex.Lock()
+	defer s.shellsMutex.Unlock()
 
-func (s *serverImpl) waitAttach() {
-	time.Sleep(time.Second * 3)
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	if !s.initialized {
-		log.Fatal("daemon was not attached, exiting")
+	if s.storage != nil {
+		err = s.storage.Close()
+		s.storage = nil
 	}
+	return
 }
 
 func (s *serverImpl) listen() (err error) {
-	socketFile := s.configuration.GetSocketFile()
-	stat, err := os.Stat(socketFile)
-	log.Printf("stat %s: %v %v", socketFile, stat, err)
-	s.listener, err = net.Listen("unix", socketFile)
+	s.listener, err = socketActivationListener()
 	if err != nil {
-		err = fmt.Errorf("cannot listen socket %s: %w", socketFile, err)
+		return
+	}
+	if s.listener != nil {
+		slog.Info("Using socket passed by socket activation")
+		return
+	}
+
+	s.listener, err = listenSocket(s.configuration)
+	if err == nil {
+		slog.Debug("Listening socket", "address", s.configuration.GetSocketAddress())
 	}
 	return
 }
@@ -128,22 +184,58 @@ func (s *serverImpl) handleConnectionProc(conn net.Conn) {
 	closeConn := func() {
 		err := conn.Close()
 		if err != nil {
-			log.Printf("Cannot close connection: %v", err)
+			slog.Warn("Cannot close connection", "error", err)
 		}
 	}
 
 	defer closeConn()
 
+	err := checkPeerCredentials(conn)
+	if err != nil {
+		slog.Warn("Connection is rejected", "error", err)
+		return
+	}
+
+	s.shellsMutex.Lock()
+	if s.listenerClosed {
+		s.shellsMutex.Unlock()
+		return
+	}
+	s.connections[conn] = true
+	s.updateIdleTimerLocked()
+	s.shellsMutex.Unlock()
+	defer func() {
+		s.shellsMutex.Lock()
+		delete(s.connections, conn)
+		s.updateIdleTimerLocked()
+		s.shellsMutex.Unlock()
+	}()
+
 	scanner := bufio.NewScanner(conn)
 	for scanner.Scan() {
 		reqData := scanner.Bytes()
 
-		log.Printf("Received request: %v", string(reqData))
-		rspData, err := s.handleRequest(reqData)
+		logger := slog.With("req", atomic.AddUint64(&s.requestCount, 1))
+		if logger.Enabled(context.Background(), slog.LevelDebug) {
+			logger.Debug("Received request", "payload", redactMessage(reqData))
+		}
+		startTime := time.Now()
+		atomic.AddInt32(&s.activeRequests, 1)
+		name, rspData, err := s.handleRequest(logger, reqData)
+		atomic.AddInt32(&s.activeRequests, -1)
 		if err != nil {
+			logger.Warn("Bad request", "request", name, "error", err)
+			s.recordError("", err)
 			rspData = MarshalResponse(nil, err, nil)
 		}
-		log.Printf("Sending response: %v", string(rspData))
+		duration := time.Since(startTime)
+		if err == nil {
+			s.metrics.observeRequest(name, duration)
+		}
+		logger.Info("Request done", "request", name, "duration", duration)
+		if logger.Enabled(context.Background(), slog.LevelDebug) {
+			logger.Debug("Sending response", "payload", redactMessage(rspData))
+		}
 
 		if !bytes.HasSuffix(rspData, []byte{byte('\n')}) {
 			rspData = append(rspData, byte('\n'))
@@ -155,23 +247,35 @@ func (s *serverImpl) handleConnectionProc(conn net.Conn) {
 	}
 }
 
-func (s *serverImpl) verifyInitialized() error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	if !s.initialized {
-		return fmt.Errorf("server is not initialized yet")
+// ensureInitialized opens storage and loads user configuration on the first request that needs them,
+// so clients like `cod learn` work without attached shells.
+func (s *serverImpl) ensureInitialized() (err error) {
+	s.shellsMutex.Lock()
+	defer s.shellsMutex.Unlock()
+	if s.initialized {
+		return
+	}
+	err = s.initializeStorage()
+	if err != nil {
+		return
 	}
-	return nil
+	s.initialized = true
+	return
 }
 
-func (s *serverImpl) handleRequest(reqData []byte) (rspData []byte, err error) {
-	name, payload, err := UnmarshalRequest(reqData)
+func (s *serverImpl) handleRequest(logger *slog.Logger, reqData []byte) (name string, rspData []byte, err error) {
+	name, protocolVersion, payload, err := UnmarshalRequest(reqData)
 	warner := &util.Warner{}
 	if err != nil {
 		return
 	} else {
-		if name != "AttachRequest" {
-			err = s.verifyInitialized()
+		// Requests that manage the daemon itself are served before any shell is attached.
+		if !isDaemonManagementRequest(name) {
+			if protocolVersion != ProtocolVersion {
+				err = fmt.Errorf("client protocol version %v doesn't match daemon protocol version %v", protocolVersion, ProtocolVersion)
+				return
+			}
+			err = s.ensureInitialized()
 			if err != nil {
 				return
 			}
@@ -182,57 +286,112 @@ func (s *serverImpl) handleRequest(reqData []byte) (rspData []byte, err error) {
 			req := DetachRequest{}
 			CastRequestPayload(payload, &req)
 			rsp, err := s.handleDetach(&req, warner)
-			rspData = MarshalResponse(&rsp, err, warner.Warns)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warner.Warns)
+		case "VersionRequest":
+			req := VersionRequest{}
+			CastRequestPayload(payload, &req)
+			rsp, err := s.handleVersion(&req, warner)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warner.Warns)
+		case "HandoverRequest":
+			req := HandoverRequest{}
+			CastRequestPayload(payload, &req)
+			rsp, err := s.handleHandover(&req, warner)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warner.Warns)
+		case "StatusRequest":
+			req := StatusRequest{}
+			CastRequestPayload(payload, &req)
+			rsp, err := s.handleStatus(&req, warner)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warner.Warns)
+		case "StatsRequest":
+			req := StatsRequest{}
+			CastRequestPayload(payload, &req)
+			rsp, err := s.handleStats(&req, warner)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warner.Warns)
+		case "StopRequest":
+			req := StopRequest{}
+			CastRequestPayload(payload, &req)
+			rsp, err := s.handleStop(&req, warner)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warner.Warns)
 		case "AttachRequest":
 			req := AttachRequest{}
 			CastRequestPayload(payload, &req)
 			rsp, err := s.handleAttach(&req, warner)
-			rspData = MarshalResponse(&rsp, err, warner.Warns)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warner.Warns)
 		case "CompleteWordsRequest":
 			req := CompleteWordsRequest{}
 			CastRequestPayload(payload, &req)
 			rsp, err := s.handleCompleteWords(&req, warner)
-			rspData = MarshalResponse(&rsp, err, warner.Warns)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warner.Warns)
 		case "InitScriptRequest":
 			req := InitScriptRequest{}
 			CastRequestPayload(payload, &req)
 			rsp, err := s.handleInitScript(&req, warner)
-			rspData = MarshalResponse(&rsp, err, warner.Warns)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warner.Warns)
+		case "DeinitScriptRequest":
+			req := DeinitScriptRequest{}
+			CastRequestPayload(payload, &req)
+			rsp, err := s.handleDeinitScript(&req, warner)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warner.Warns)
 		case "ListClientsRequest":
 			req := ListClientsRequest{}
 			CastRequestPayload(payload, &req)
 			rsp, err := s.handleListClients(&req, warner)
-			rspData = MarshalResponse(&rsp, err, warner.Warns)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warner.Warns)
 		case "ListCommandsRequest":
 			req := ListCommandsRequest{}
 			CastRequestPayload(payload, &req)
 			rsp, err := s.handleListCommands(&req, warner)
-			rspData = MarshalResponse(&rsp, err, warner.Warns)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warner.Warns)
+		case "ListCompletionsRequest":
+			req := ListCompletionsRequest{}
+			CastRequestPayload(payload, &req)
+			rsp, err := s.handleListCompletions(&req, warner)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warner.Warns)
+		case "ExportRequest":
+			req := ExportRequest{}
+			CastRequestPayload(payload, &req)
+			rsp, err := s.handleExport(&req, warner)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warner.Warns)
+		case "ImportRequest":
+			req := ImportRequest{}
+			CastRequestPayload(payload, &req)
+			rsp, err := s.handleImport(&req, warner)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warner.Warns)
 		case "RemoveCommandsRequest":
 			req := RemoveCommandsRequest{}
 			CastRequestPayload(payload, &req)
 			rsp, err := s.handleRemoveCommands(&req, warner)
-			rspData = MarshalResponse(&rsp, err, warner.Warns)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warner.Warns)
 		case "AddHelpPageRequest":
 			req := AddHelpPageRequest{}
 			CastRequestPayload(payload, &req)
 			rsp, err := s.handleAddHelpPage(&req, warner)
-			rspData = MarshalResponse(&rsp, err, warner.Warns)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warner.Warns)
 		case "PollUpdatesRequest":
 			req := PollUpdatesRequest{}
 			CastRequestPayload(payload, &req)
 			rsp, err, warns := s.handlePollUpdates(&req)
-			rspData = MarshalResponse(&rsp, err, warns)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warns)
 		case "ParseCommandLineRequest":
 			req := ParseCommandLineRequest{}
 			CastRequestPayload(payload, &req)
 			rsp, err, warns := s.handleParseCommandLine(&req)
-			rspData = MarshalResponse(&rsp, err, warns)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warns)
+		case "PromptHookRequest":
+			req := PromptHookRequest{}
+			CastRequestPayload(payload, &req)
+			rsp, err, warns := s.handlePromptHook(&req)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warns)
+		case "LazyCompletionsRequest":
+			req := LazyCompletionsRequest{}
+			CastRequestPayload(payload, &req)
+			rsp, err := s.handleLazyCompletions(&req, warner)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warner.Warns)
 		case "UpdateHelpPageRequest":
 			req := UpdateHelpPageRequest{}
 			CastRequestPayload(payload, &req)
 			rsp, err := s.handleUpdateHelpPageRequest(&req, warner)
-			rspData = MarshalResponse(&rsp, err, warner.Warns)
+			rspData = s.marshalResponse(logger, name, &rsp, err, warner.Warns)
 		default:
 			err = fmt.Errorf("unknown request: %v", name)
 			return
@@ -241,18 +400,46 @@ func (s *serverImpl) handleRequest(reqData []byte) (rspData []byte, err error) {
 	return
 }
 
+// initializeStorage loads user configuration and opens storage,
+// storage is not opened while the configuration is broken, so retries of failed requests don't leak it.
 func (s *serverImpl) initializeStorage() (err error) {
-	s.storage, err = datastore.NewSqliteStorage(s.configuration.GetCompletionsSqliteDb())
-	if err != nil {
-		return
-	}
-	s.userConfiguration, err = LoadUserConfiguration(
+	userConfiguration, err := LoadUserConfiguration(
 		s.configuration.GetUserConfiguration(),
 		s.configuration.GetHomeDir(),
 	)
 	if err != nil {
 		return
 	}
+	storage, err := datastore.NewSqliteStorage(s.configuration.GetCompletionsSqliteDb())
+	if err != nil {
+		return
+	}
+	indexedStorage, err := datastore.NewIndexedStorage(storage)
+	if err != nil {
+		_ = storage.Close()
+		return
+	}
+	s.storage = indexedStorage
+	s.userConfiguration.Store(&userConfiguration)
+	logLevel.Set(userConfiguration.GetLogLevel())
+	return
+}
+
+// warnShellOnce queues warning to be shown by the attached shell on its next poll.
+// Each warning is shown to the shell only once. Returns false if the shell is not attached.
+func (s *serverImpl) warnShellOnce(pid int, warning string) (attached bool) {
+	s.shellsMutex.Lock()
+	defer s.shellsMutex.Unlock()
+
+	info, attached := s.shellInfoMap[pid]
+	if !attached || info.shownWarnings[warning] {
+		return
+	}
+	if info.shownWarnings == nil {
+		info.shownWarnings = make(map[string]bool)
+	}
+	info.shownWarnings[warning] = true
+	info.warnings = append(info.warnings, util.Warning{Warning: warning})
 	return
 }
 
@@ -266,14 +453,27 @@ func (s *serverImpl) getWatchedPids() []int {
 }
 
 func (s *serverImpl) notifyExecutableUpdate(executablePath string) {
+	s.shellsMutex.Lock()
+	defer s.shellsMutex.Unlock()
+
 	for _, si := range s.shellInfoMap {
 		si.executablesToUpdate[executablePath] = true
 	}
+	s.bumpGenerationLocked()
 }
 
 func (s *serverImpl) handleAttach(req *AttachRequest, _ *util.Warner) (rsp AttachResponse, err error) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	s.shellsMutex.Lock()
+	defer s.shellsMutex.Unlock()
+
+	if req.ProtocolVersion != ProtocolVersion {
+		err = fmt.Errorf("client protocol version %v doesn't match daemon protocol version %v", req.ProtocolVersion, ProtocolVersion)
+		return
+	}
+	if s.stopping {
+		err = fmt.Errorf("daemon is stopping")
+		return
+	}
 
 	if !s.initialized {
 		err = s.initializeStorage()
@@ -291,26 +491,55 @@ func (s *serverImpl) handleAttach(req *AttachRequest, _ *util.Warner) (rsp Attac
 	s.shellInfoMap[req.Pid] = &shellInfo{
 		pid:                 req.Pid,
 		shell:               req.Shell,
+		codBinaryPath:       req.CodBinaryPath,
 		scriptGenerator:     scriptGenerator,
 		executablesToUpdate: make(map[string]bool),
+		dir:                 req.Dir,
+		pathVar:             req.PathVar,
 	}
-	log.Printf("Watched pids: %v", s.getWatchedPids())
+	slog.Info("Watched pids changed", "pids", s.getWatchedPids())
 	go s.waitPidProc(req.Pid)
-	s.initialized = true
+	s.updateIdleTimerLocked()
 	return
 }
 
-func (s *serverImpl) handleCompleteWords(req *CompleteWordsRequest, _ *util.Warner) (rsp CompleteWordsResponse, err error) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+func (s *serverImpl) handleVersion(_ *VersionRequest, _ *util.Warner) (rsp VersionResponse, err error) {
+	rsp.ProtocolVersion = ProtocolVersion
+	rsp.Version = s.version
+	rsp.Pid = os.Getpid()
+	return
+}
 
-	if len(req.Words) == 0 {
-		err = fmt.Errorf("cannot complete empty command line")
+// handleHandover returns attached shells and stops the daemon,
+// so the shells can be attached to the daemon started from other cod binary.
+func (s *serverImpl) handleHandover(_ *HandoverRequest, _ *util.Warner) (rsp HandoverResponse, err error) {
+	s.shellsMutex.Lock()
+	defer s.shellsMutex.Unlock()
+
+	if s.stopping {
 		return
 	}
 
-	completions, err := s.storage.GetCompletions(req.Words[0])
-	if err != nil {
+	for _, info := range s.shellInfoMap {
+		rsp.Shells = append(rsp.Shells, HandedOverShell{
+			Shell:         info.shell,
+			Pid:           info.pid,
+			CodBinaryPath: info.codBinaryPath,
+			Dir:           info.dir,
+			PathVar:       info.pathVar,
+		})
+	}
+	sort.Slice(rsp.Shells, func(i, j int) bool {
+		return rsp.Shells[i].Pid < rsp.Shells[j].Pid
+	})
+	slog.Info("Handing over shells", "shells", rsp.Shells)
+	s.stopServing()
+	return
+}
+
+func (s *serverImpl) handleCompleteWords(req *CompleteWordsRequest, warner *util.Warner) (rsp CompleteWordsResponse, err error) {
+	if len(req.Words) == 0 {
+		err = fmt.Errorf("cannot complete empty command line")
 		return
 	}
 
@@ -329,55 +558,181 @@ func (s *serverImpl) handleCompleteWords(req *CompleteWordsRequest, _ *util.Warn
 
 	commandPrefix := req.Words[:cWord]
 
-	for _, completion := range completions {
-		ok := strings.HasPrefix(completion.Flag, word) &&
-			datastore.IsCommandMatchingContext(commandPrefix, completion.Context)
+	var completions []datastore.Completion
+	rsp.ExecutablePath, rsp.ExecutableStatus = s.resolveCompletedExecutable(req.Words[0])
+	completions, err = s.storage.GetCompletions(rsp.ExecutablePath)
+	if err != nil {
+		return
+	}
+	userConfiguration := s.getUserConfiguration()
+	provider, providerSlot := userConfiguration.FindProvider(completions, commandPrefix, word)
+	timeout := userConfiguration.GetCommandExecutionTimeout()
+
+	rsp.Version = CompletionItemVersion
+	if rsp.ExecutableStatus == ExecutableStatusFallback {
+		warning := fmt.Sprintf("%v is not learned, using completions of %v", req.Words[0], rsp.ExecutablePath)
+		// Shell glue hides output of completion, so warning is shown by the shell on its next poll.
+		if !s.warnShellOnce(req.Pid, warning) {
+			warner.Warnf("%v", warning)
+		}
+	}
 
-		if ok {
-			rsp.Completions = append(rsp.Completions, completion.Flag)
+	if provider != nil {
+		var values []string
+		values, err = s.getProviderValues(provider, req.Dir, req.Env, timeout)
+		if err != nil {
+			warner.WarnError(err)
+			err = nil
+		}
+		rsp.ValueKind = datastore.ValueKindPlain
+		for _, value := range values {
+			if strings.HasPrefix(value, providerSlot.valuePrefix) {
+				rsp.Items = append(rsp.Items, makeValueItem(value, providerSlot.wordPrefix, string(CompletionKindValue)))
+			}
+		}
+		if len(provider.Flag) > 0 {
+			return
+		}
+	} else if completion, slot := findValueSlot(completions, commandPrefix, word); completion != nil {
+		rsp.ValueKind = completion.ValueKind()
+		for _, value := range s.listValues(rsp.ValueKind) {
+			if strings.HasPrefix(value, slot.valuePrefix) {
+				rsp.Items = append(rsp.Items, makeValueItem(value, slot.wordPrefix, string(rsp.ValueKind)))
+			}
 		}
+		return
+	}
+
+	matching := s.storage.FindCompletions(rsp.ExecutablePath, commandPrefix, word)
+	for i := range matching {
+		rsp.Items = append(rsp.Items, makeCompletionItem(&matching[i]))
 	}
 
 	return
 }
 
 func (s *serverImpl) handleDetach(req *DetachRequest, _ *util.Warner) (rsp DetachResponse, err error) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	s.shellsMutex.Lock()
+	defer s.shellsMutex.Unlock()
 
-	delete(s.shellInfoMap, req.Pid)
-	log.Printf("Watched pids: %v", s.getWatchedPids())
-	if len(s.shellInfoMap) == 0 {
-		err = s.listener.Close()
+	// Shell might be detached explicitly before its process exits.
+	if _, ok := s.shellInfoMap[req.Pid]; !ok {
+		return
 	}
+	delete(s.shellInfoMap, req.Pid)
+	slog.Info("Watched pids changed", "pids", s.getWatchedPids())
+	s.updateIdleTimerLocked()
 	return
 }
 
-func (s *serverImpl) handleInitScript(req *InitScriptRequest, _ *util.Warner) (rsp InitScriptResponse, err error) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+// closeListenerLocked stops accepting connections and interrupts reading of the open ones,
+// so Serve doesn't wait for idle long-lived clients like shell sessions.
+// Requests being processed are still answered.
+func (s *serverImpl) closeListenerLocked() error {
+	s.listenerClosed = true
+	for conn := range s.connections {
+		_ = conn.SetReadDeadline(time.Now())
+	}
+	return s.listener.Close()
+}
 
+func (s *serverImpl) handleInitScript(req *InitScriptRequest, warner *util.Warner) (rsp InitScriptResponse, err error) {
 	info, err := s.getShellInfo(req.Pid)
 	if err != nil {
 		return
 	}
 
 	rsp.Script = info.scriptGenerator.GetPreamble()
-	helpPageList, err := s.storage.GetAllCompletions()
+	if generator, ok := info.scriptGenerator.(shells.GenerationScriptGenerator); ok {
+		rsp.Script = append(rsp.Script, generator.GenerationFileScript(s.configuration.GetGenerationFile())...)
+	}
+	byName := s.getLearnedExecutables()
+	var names []string
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if req.Lazy {
+		if generator, ok := info.scriptGenerator.(shells.LazyScriptGenerator); ok {
+			stubDir := s.configuration.GetCompletionStubDir(info.shell)
+			if stubGenerator, ok := generator.(shells.CompletionStubGenerator); ok {
+				err = writeCompletionStubs(stubDir, stubGenerator, names)
+				if err != nil {
+					return
+				}
+			}
+			rsp.Script = append(rsp.Script, generator.GetLazyScript(stubDir)...)
+			return
+		}
+		warner.Warnf("lazy registration of completions is not supported by %v, all completions are registered now", info.shell)
+	}
+
+	for _, name := range names {
+		executablePath, _ := s.resolveExecutableName(name, byName[name], info.dir, info.pathVar)
+		var completions []datastore.Completion
+		completions, err = s.storage.GetCompletions(executablePath)
+		if err != nil {
+			return
+		}
+		if len(completions) > 0 {
+			rsp.Script = append(rsp.Script, s.generateCompletions(info, executablePath, completions)...)
+		}
+	}
+	return
+}
+
+func (s *serverImpl) handleLazyCompletions(req *LazyCompletionsRequest, _ *util.Warner) (rsp LazyCompletionsResponse, err error) {
+	info, err := s.getShellInfo(req.Pid)
 	if err != nil {
 		return
 	}
-	for _, helpPage := range helpPageList {
-		rsp.Script = append(
-			rsp.Script, info.scriptGenerator.GenerateCompletions(helpPage.ExecutablePath, helpPage.Completions)...,
-		)
+
+	byName := s.getLearnedExecutables()
+	name := filepath.Base(req.Name)
+	executablePath, _ := s.resolveExecutableName(name, byName[name], info.dir, info.pathVar)
+	if executablePath == "" {
+		// Command is not learned.
+		return
+	}
+	completions, err := s.storage.GetCompletions(executablePath)
+	if err != nil {
+		return
+	}
+	if len(completions) > 0 {
+		rsp.Script = s.generateCompletions(info, executablePath, completions)
+	}
+	return
+}
+
+func (s *serverImpl) handleDeinitScript(req *DeinitScriptRequest, _ *util.Warner) (rsp DeinitScriptResponse, err error) {
+	info, err := s.getShellInfo(req.Pid)
+	if err != nil {
+		return
 	}
+	if info.shell != req.Shell {
+		err = fmt.Errorf("shell %v was initialized as %v", req.Pid, info.shell)
+		return
+	}
+
+	byName := s.getLearnedExecutables()
+	var names []string
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Completions are reset before functions used by reset commands are removed.
+	for _, name := range names {
+		rsp.Script = append(rsp.Script, info.scriptGenerator.ResetCommand(byName[name][0])...)
+	}
+	rsp.Script = append(rsp.Script, info.scriptGenerator.GetDeinitScript()...)
 	return
 }
 
 func (s *serverImpl) handleListClients(_ *ListClientsRequest, _ *util.Warner) (rsp ListClientsResponse, err error) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	s.shellsMutex.Lock()
+	defer s.shellsMutex.Unlock()
 
 	for _, shellInfo := range s.shellInfoMap {
 		rsp.Clients = append(rsp.Clients, ShellAndPid{
@@ -391,9 +746,72 @@ func (s *serverImpl) handleListClients(_ *ListClientsRequest, _ *util.Warner) (r
 var numberRe = regexp.MustCompile("^\\d+$")
 
 func (s *serverImpl) handleListCommands(req *ListCommandsRequest, _ *util.Warner) (rsp ListCommandsResponse, err error) {
+	rsp.CommandItems, err = s.selectCommands(req.Selectors)
+	if err != nil || req.PathVar == "" {
+		return
+	}
+
+	byName := s.getLearnedExecutables()
+	for i := range rsp.CommandItems {
+		item := &rsp.CommandItems[i]
+		if item.Command == nil || len(item.Command.Args) == 0 {
+			continue
+		}
+		executablePath := item.Command.Args[0]
+		name := filepath.Base(executablePath)
+		resolved, status := s.resolveExecutableName(name, byName[name], req.Dir, req.PathVar)
+		switch {
+		case status == ExecutableStatusActive && len(byName[name]) == 1:
+			// Nothing interesting to show.
+		case resolved == executablePath:
+			item.Status = status
+		case status != ExecutableStatusUnknown:
+			item.Status = ExecutableStatusShadowed
+		}
+	}
+	return
+}
+
+func (s *serverImpl) handleListCompletions(req *ListCompletionsRequest, _ *util.Warner) (rsp ListCompletionsResponse, err error) {
+	items, err := s.selectCommands(req.Selectors)
+	if err != nil {
+		return
+	}
+
+	var executablePaths []string
+	seen := make(map[string]bool)
+	for _, item := range items {
+		if item.Command == nil || len(item.Command.Args) == 0 || seen[item.Command.Args[0]] {
+			continue
+		}
+		seen[item.Command.Args[0]] = true
+		executablePaths = append(executablePaths, item.Command.Args[0])
+	}
+	sort.Strings(executablePaths)
+
+	userConfiguration := s.getUserConfiguration()
+	for _, executablePath := range executablePaths {
+		var completions []datastore.Completion
+		completions, err = s.storage.GetCompletions(executablePath)
+		if err != nil {
+			return
+		}
+		selector := userConfiguration.GetPreferredExecutable(filepath.Base(executablePath))
+		rsp.Executables = append(rsp.Executables, ExecutableCompletions{
+			ExecutablePath: executablePath,
+			Completions:    completions,
+			Preferred:      selector != nil && selector.MatchString(executablePath),
+		})
+	}
+	return
+}
+
+// selectCommands returns learned commands matching selectors, selector is either id of a command
+// or a glob matching executable path.
+func (s *serverImpl) selectCommands(selectors []string) (items []ListCommandsResponseItem, err error) {
 	idFilter := make(map[int64]bool)
 	var globs []util.Selector
-	for _, selector := range req.Selectors {
+	for _, selector := range selectors {
 		switch {
 		case numberRe.MatchString(selector):
 			var pid int64
@@ -435,16 +853,108 @@ func (s *serverImpl) handleListCommands(req *ListCommandsRequest, _ *util.Warner
 				Id:      id,
 				Command: command,
 			}
-			rsp.CommandItems = append(rsp.CommandItems, item)
+			items = append(items, item)
 		}
 	}
 	return
 }
 
-func (s *serverImpl) handleRemoveCommands(req *RemoveCommandsRequest, _ *util.Warner) (rsp RemoveCommandsResponse, err error) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+func (s *serverImpl) handleExport(req *ExportRequest, _ *util.Warner) (rsp ExportResponse, err error) {
+	items, err := s.selectCommands(req.Selectors)
+	if err != nil {
+		return
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Id < items[j].Id
+	})
+
+	for _, item := range items {
+		var helpPage *datastore.HelpPage
+		var policy datastore.Policy
+		helpPage, policy, err = s.storage.GetHelpPage(item.Id)
+		if err != nil {
+			return
+		}
+		// Help page might be removed after it is selected.
+		if helpPage == nil {
+			continue
+		}
+		rsp.HelpPages = append(rsp.HelpPages, datastore.NewBundleHelpPage(helpPage, policy))
+	}
+	return
+}
+
+func (s *serverImpl) handleImport(req *ImportRequest, _ *util.Warner) (rsp ImportResponse, err error) {
+	switch req.Strategy {
+	case ImportStrategySkip, ImportStrategyOverwrite, ImportStrategyNewerWins:
+	default:
+		err = fmt.Errorf("unknown import strategy %q", req.Strategy)
+		return
+	}
+
+	updated := make(map[string]bool)
+	defer func() {
+		for executablePath := range updated {
+			s.notifyExecutableUpdate(executablePath)
+		}
+	}()
+
+	for i := range req.HelpPages {
+		page := &req.HelpPages[i]
+		var status ImportStatus
+		status, err = s.importHelpPage(page, req)
+		if err != nil {
+			err = fmt.Errorf("cannot import `%v`: %w", shells.Quote(page.Command.Args), err)
+			return
+		}
+		if status != ImportStatusSkipped {
+			updated[page.ExecutablePath] = true
+		}
+		rsp.Statuses = append(rsp.Statuses, status)
+	}
+	return
+}
+
+// importHelpPage adds help page through the storage as any learned one, so help pages with the same help text
+// are merged with it.
+func (s *serverImpl) importHelpPage(page *datastore.BundleHelpPage, req *ImportRequest) (status ImportStatus, err error) {
+	// Request might come from client that doesn't check bundle.
+	err = datastore.CheckBundleHelpPage(page)
+	if err != nil {
+		return
+	}
+
+	strategy := req.Strategy
+	if strategy != ImportStrategyOverwrite {
+		var learnedAt time.Time
+		var found bool
+		learnedAt, found, err = s.storage.GetCommandLearnedAt(page.Command.Args)
+		if err != nil {
+			return
+		}
+		if found && (strategy == ImportStrategySkip || !page.LearnedAt.After(learnedAt)) {
+			status = ImportStatusSkipped
+			return
+		}
+	}
+
+	policy := page.Policy
+	if policy == datastore.PolicyTrust && !req.KeepTrust {
+		policy = datastore.PolicyUnknown
+	}
+	addStatus, err := s.storage.AddHelpPage(page.HelpPage(req.Env), policy)
+	if err != nil {
+		return
+	}
+	if addStatus == datastore.AddHelpPageStatusNew {
+		status = ImportStatusNew
+	} else {
+		status = ImportStatusUpdated
+	}
+	return
+}
 
+func (s *serverImpl) handleRemoveCommands(req *RemoveCommandsRequest, _ *util.Warner) (rsp RemoveCommandsResponse, err error) {
 	for _, id := range req.HelpPageIds {
 		var executablePath string
 		executablePath, err = s.storage.RemoveHelpPage(id)
@@ -487,7 +997,7 @@ func (s *serverImpl) runHelpCommand(command datastore.Command, ctx context.Conte
 		err = fmt.Errorf("%w: %v", err, string(helpBytes))
 		return
 	}
-	helpPage, err = parse_doc.ParseHelp(argv, string(helpBytes))
+	helpPage, err = parse_doc.ParseHelpObserved(argv, string(helpBytes), s.metrics.observeParser)
 	if err != nil {
 		return
 	}
@@ -497,7 +1007,13 @@ func (s *serverImpl) runHelpCommand(command datastore.Command, ctx context.Conte
 }
 
 func (s *serverImpl) handleAddHelpPage(req *AddHelpPageRequest, _ *util.Warner) (rsp AddHelpPageResponse, err error) {
-	timeout := s.userConfiguration.GetCommandExecutionTimeout()
+	defer func() {
+		if err != nil {
+			s.metrics.recordLearnFailure()
+		}
+	}()
+
+	timeout := s.getUserConfiguration().GetCommandExecutionTimeout()
 	ctx, cancelFunc := context.WithTimeout(context.Background(), timeout)
 	helpPage, err := s.runHelpCommand(req.Command, ctx)
 	cancelFunc()
@@ -518,9 +1034,6 @@ func (s *serverImpl) handleAddHelpPage(req *AddHelpPageRequest, _ *util.Warner)
 		return
 	}
 
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
 	var status datastore.AddHelpPageStatus
 	status, err = s.storage.AddHelpPage(helpPage, req.Policy)
 	if err != nil {
@@ -535,32 +1048,103 @@ func (s *serverImpl) handleAddHelpPage(req *AddHelpPageRequest, _ *util.Warner)
 }
 
 func (s *serverImpl) handlePollUpdates(req *PollUpdatesRequest) (rsp PollUpdatesResponse, err error, warns []util.Warning) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	info, err := s.getShellInfo(req.Pid)
+	s.shellsMutex.Lock()
+	info, err := s.getShellInfoLocked(req.Pid)
 	if err != nil {
+		s.shellsMutex.Unlock()
 		return
 	}
+	warns = info.warnings
+	info.warnings = nil
+	executablesToUpdate := info.executablesToUpdate
+	info.executablesToUpdate = make(map[string]bool)
+	s.shellsMutex.Unlock()
 
-	for executablePath := range info.executablesToUpdate {
-		var completions []datastore.Completion
-		completions, err = s.storage.GetCompletions(executablePath)
+	if len(executablesToUpdate) == 0 {
+		return
+	}
+	defer func() {
 		if err != nil {
-			return
+			// Updates are retried on the next poll.
+			s.shellsMutex.Lock()
+			for executablePath := range executablesToUpdate {
+				info.executablesToUpdate[executablePath] = true
+			}
+			s.shellsMutex.Unlock()
 		}
+	}()
+
+	byName := s.getLearnedExecutables()
+
+	// Completions are registered by name, so update of any executable might change
+	// which of the same-named executables provides completions.
+	updatedNames := make(map[string]bool)
+	for executablePath := range executablesToUpdate {
+		name := filepath.Base(executablePath)
+		if updatedNames[name] {
+			continue
+		}
+		updatedNames[name] = true
 
 		rsp.Script = append(rsp.Script, info.scriptGenerator.ResetCommand(executablePath)...)
-		if len(completions) > 0 {
-			rsp.Script = append(rsp.Script, info.scriptGenerator.GenerateCompletions(executablePath, completions)...)
+		if resolved, _ := s.resolveExecutableName(name, byName[name], info.dir, info.pathVar); resolved != "" {
+			var completions []datastore.Completion
+			completions, err = s.storage.GetCompletions(resolved)
+			if err != nil {
+				return
+			}
+			if len(completions) > 0 {
+				rsp.Script = append(rsp.Script, s.generateCompletions(info, resolved, completions)...)
+			}
 		}
-		delete(info.executablesToUpdate, executablePath)
 	}
 	return
 }
 
+func (s *serverImpl) handlePromptHook(req *PromptHookRequest) (rsp PromptHookResponse, err error, warns []util.Warning) {
+	if req.CommandLine != "" {
+		parseReq := ParseCommandLineRequest{
+			Pid:         req.Pid,
+			CommandLine: req.CommandLine,
+			Dir:         req.Dir,
+			Env:         req.Env,
+		}
+		rsp.Command, err, warns = s.handleParseCommandLine(&parseReq)
+		if errors.Is(err, util.ErrBinaryNotFound) {
+			// Command that is not found is not a help command, updates are polled anyway.
+			err = nil
+			rsp.Command = ParseCommandLineResponse{}
+		} else if err != nil {
+			return
+		}
+	}
+
+	pollRsp, err, pollWarns := s.handlePollUpdates(&PollUpdatesRequest{Pid: req.Pid})
+	rsp.Script = pollRsp.Script
+	rsp.Shell = s.getShell(req.Pid)
+	warns = append(warns, pollWarns...)
+	return
+}
+
+func (s *serverImpl) generateCompletions(info *shellInfo, executablePath string, completions []datastore.Completion) (script []string) {
+	script = info.scriptGenerator.GenerateCompletions(executablePath, completions)
+	if generator, ok := info.scriptGenerator.(shells.ProviderScriptGenerator); ok && s.getUserConfiguration().HasProviders(executablePath) {
+		script = append(script, generator.GenerateProviderCompletions(executablePath)...)
+	}
+	return
+}
+
+func (s *serverImpl) getShell(pid int) string {
+	s.shellsMutex.Lock()
+	defer s.shellsMutex.Unlock()
+	if info, ok := s.shellInfoMap[pid]; ok {
+		return info.shell
+	}
+	return ""
+}
+
 func (s *serverImpl) handleParseCommandLine(req *ParseCommandLineRequest) (rsp ParseCommandLineResponse, err error, warns []util.Warning) {
-	rsp.Env, rsp.Args, err = shells.ParseSimpleCommand(req.CommandLine)
+	rsp.Env, rsp.Args, err = shells.ParseShellCommand(s.getShell(req.Pid), req.CommandLine)
 	if err != nil {
 		if errors.Is(err, shells.ErrCommandNotSimple) {
 			err = nil
@@ -568,17 +1152,7 @@ func (s *serverImpl) handleParseCommandLine(req *ParseCommandLineRequest) (rsp P
 		return
 	}
 
-	rsp.IsHelpCommand = false
-loop:
-	for _, a := range rsp.Args {
-		switch a {
-		case "--help":
-			rsp.IsHelpCommand = true
-			break loop
-		case "--":
-			break loop
-		}
-	}
+	rsp.IsHelpCommand = datastore.IsHelpCommand(rsp.Args)
 
 	var executablePath string
 	if len(rsp.Args) > 0 {
@@ -599,7 +1173,7 @@ loop:
 		}
 		if policy != datastore.PolicyUnknown {
 			rsp.PolicyMode = policy
-		} else if policy = s.userConfiguration.GetExecutablePolicy(rsp.Args[0]); policy != datastore.PolicyUnknown {
+		} else if policy = s.getUserConfiguration().GetExecutablePolicy(rsp.Args[0]); policy != datastore.PolicyUnknown {
 			rsp.PolicyMode = policy
 		} else {
 			rsp.PolicyMode = datastore.PolicyAsk
@@ -631,16 +1205,30 @@ func (s *serverImpl) handleUpdateHelpPageRequest(req *UpdateHelpPageRequest, war
 	helpPage, err := s.runHelpCommand(cmd, ctx)
 	cancelCtx()
 	if err != nil {
+		s.metrics.recordUpdateFailure()
 		warner.Warnf("error running %v: %v", shells.Quote(cmd.Args), err)
-		_, err = s.storage.RemoveHelpPage(req.Id)
+		var executablePath string
+		executablePath, err = s.storage.RemoveHelpPage(req.Id)
+		if err == nil {
+			s.notifyExecutableUpdate(executablePath)
+		}
 	} else {
 		_, err = s.storage.AddHelpPage(helpPage, datastore.PolicyUnknown)
+		if err == nil {
+			s.notifyExecutableUpdate(helpPage.ExecutablePath)
+		}
 	}
 
 	return
 }
 
 func (s *serverImpl) getShellInfo(pid int) (info *shellInfo, err error) {
+	s.shellsMutex.Lock()
+	defer s.shellsMutex.Unlock()
+	return s.getShellInfoLocked(pid)
+}
+
+func (s *serverImpl) getShellInfoLocked(pid int) (info *shellInfo, err error) {
 	info, ok := s.shellInfoMap[pid]
 	if !ok {
 		err = fmt.Errorf("unknown pid: %v", pid)
@@ -648,11 +1236,22 @@ func (s *serverImpl) getShellInfo(pid int) (info *shellInfo, err error) {
 	return
 }
 
+func (s *serverImpl) isAttached(pid int) bool {
+	s.shellsMutex.Lock()
+	defer s.shellsMutex.Unlock()
+	_, ok := s.shellInfoMap[pid]
+	return ok
+}
+
 func (s *serverImpl) waitPidProc(pid int) {
 	for {
+		if !s.isAttached(pid) {
+			slog.Debug("Process was detached, stop waiting", "shell", pid)
+			break
+		}
 		err := unix.Kill(pid, 0)
 		if err != nil {
-			log.Printf("Done waiting process %v: %v", pid, err)
+			slog.Info("Shell process exited", "shell", pid, "error", err)
 			_, err := s.handleDetach(&DetachRequest{pid}, nil)
 			util.VerifyPanic(err)
 			break
@@ -661,39 +1260,32 @@ func (s *serverImpl) waitPidProc(pid int) {
 	}
 }
 
-var logPattern = regexp.MustCompile(`^cod[.]\d\d\d\d-\d\d-\d\d[.]log$`)
+// Daily log files were written before logs were rotated by size, they are removed after a week.
+var legacyLogPattern = regexp.MustCompile(`^cod[.](\d\d\d\d-\d\d-\d\d)[.]log$`)
 
-func (s *serverImpl) trimLogs() {
-	maxLogCount := 7
+const legacyLogRetention = 7 * 24 * time.Hour
 
+func (s *serverImpl) removeLegacyLogs() {
 	logDir := s.configuration.GetLogDir()
-	fileInfoList, err := ioutil.ReadDir(logDir)
+	entries, err := os.ReadDir(logDir)
 	if err != nil {
-		log.Printf("cannot read log directory: %v", err)
+		slog.Warn("Cannot read log directory", "error", err)
 		return
 	}
 
-	var logFileList []string
-	for _, fileInfo := range fileInfoList {
-		if fileInfo.IsDir() {
+	for _, entry := range entries {
+		match := legacyLogPattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
 			continue
 		}
-		if logPattern.MatchString(fileInfo.Name()) {
-			logFileList = append(logFileList, fileInfo.Name())
+		date, err := time.Parse("2006-01-02", match[1])
+		if err != nil || time.Since(date) < legacyLogRetention {
+			continue
 		}
-	}
-
-	if len(logFileList) <= maxLogCount {
-		return
-	}
-
-	sort.Strings(logFileList)
-
-	for _, name := range logFileList[:len(logFileList)-maxLogCount] {
-		fullPath := filepath.Join(logDir, name)
+		fullPath := filepath.Join(logDir, entry.Name())
 		err = os.Remove(fullPath)
 		if err != nil {
-			log.Printf("cannot remove old log file %q: %v", fullPath, err)
+			slog.Warn("Cannot remove old log file", "path", fullPath, "error", err)
 		}
 	}
 }
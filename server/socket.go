@@ -0,0 +1,78 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/dim-an/cod/util"
+)
+
+func isAbstractSocketAddress(address string) bool {
+	return strings.HasPrefix(address, "@")
+}
+
+// listenSocket creates the socket accessible only by the current user inside private run directory.
+func listenSocket(cfg *Configuration) (listener net.Listener, err error) {
+	err = util.CreatePrivateDir(cfg.GetRunDir())
+	if err != nil {
+		return
+	}
+
+	address := cfg.GetSocketAddress()
+	socketFile := cfg.GetSocketFile()
+	if !isAbstractSocketAddress(address) {
+		err = os.Remove(socketFile)
+		if err != nil && !os.IsNotExist(err) {
+			err = fmt.Errorf("cannot remove %s: %w", socketFile, err)
+			return
+		}
+	}
+	listener, err = net.Listen("unix", address)
+	if err != nil {
+		err = fmt.Errorf("cannot listen socket %s: %w", address, err)
+		return
+	}
+	if !isAbstractSocketAddress(address) {
+		err = os.Chmod(socketFile, 0600)
+		if err != nil {
+			_ = listener.Close()
+			listener = nil
+		}
+	}
+	return
+}
+
+// checkPeerCredentials makes sure the other side of the connection runs as the same user,
+// so neither daemon nor clients talk to processes of other users.
+func checkPeerCredentials(conn net.Conn) (err error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		err = fmt.Errorf("unexpected connection type %T", conn)
+		return
+	}
+	uid, err := peerUid(unixConn)
+	if err != nil {
+		err = fmt.Errorf("cannot get peer credentials: %w", err)
+		return
+	}
+	if uid != os.Getuid() {
+		err = fmt.Errorf("peer runs as uid %v, expected uid %v", uid, os.Getuid())
+	}
+	return
+}
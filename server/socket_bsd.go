@@ -0,0 +1,79 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || freebsd
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"syscall"
+
+	"github.com/dim-an/cod/util"
+	"golang.org/x/sys/unix"
+)
+
+// shortSocketAddress returns path of the socket file through a short symlink to the run directory.
+// Symlink is created in /tmp, not in $TMPDIR that is long on macOS.
+func shortSocketAddress(cfg *Configuration) (address string, err error) {
+	hash := util.HashStrings([]string{cfg.runDir})
+	link := path.Join("/tmp", fmt.Sprintf("%v-%v-%v", cfg.appName, os.Getuid(), hash[:8]))
+
+	err = os.Symlink(cfg.runDir, link)
+	if err != nil && !os.IsExist(err) {
+		return
+	}
+	// Symlink might be created by somebody else, it must be ours and point to the run directory.
+	stat, err := os.Lstat(link)
+	if err != nil {
+		return
+	}
+	if sys, ok := stat.Sys().(*syscall.Stat_t); !ok || int(sys.Uid) != os.Getuid() {
+		err = fmt.Errorf("%v is owned by other user", link)
+		return
+	}
+	target, err := os.Readlink(link)
+	if err != nil {
+		return
+	}
+	if target != cfg.runDir {
+		err = fmt.Errorf("%v points to %v instead of %v", link, target, cfg.runDir)
+		return
+	}
+	address = path.Join(link, path.Base(cfg.GetSocketFile()))
+	return
+}
+
+// peerUid returns uid of the process on the other side of unix socket connection.
+func peerUid(conn *net.UnixConn) (uid int, err error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return
+	}
+	var cred *unix.Xucred
+	controlErr := rawConn.Control(func(fd uintptr) {
+		cred, err = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	})
+	if controlErr != nil {
+		err = controlErr
+	}
+	if err != nil {
+		return
+	}
+	uid = int(cred.Uid)
+	return
+}
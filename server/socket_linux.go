@@ -0,0 +1,53 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package server
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/dim-an/cod/util"
+	"golang.org/x/sys/unix"
+)
+
+// shortSocketAddress returns abstract socket address derived from the path of the socket file.
+// Abstract sockets have no file permissions, peers are checked by their credentials instead.
+func shortSocketAddress(cfg *Configuration) (address string, err error) {
+	hash := util.HashStrings([]string{cfg.GetSocketFile()})
+	address = fmt.Sprintf("@%v-%v", cfg.appName, hash[:16])
+	return
+}
+
+// peerUid returns uid of the process on the other side of unix socket connection.
+func peerUid(conn *net.UnixConn) (uid int, err error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return
+	}
+	var cred *unix.Ucred
+	controlErr := rawConn.Control(func(fd uintptr) {
+		cred, err = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if controlErr != nil {
+		err = controlErr
+	}
+	if err != nil {
+		return
+	}
+	uid = int(cred.Uid)
+	return
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/dim-an/cod/datastore"
+	"github.com/dim-an/cod/util"
+)
+
+const maxLastErrors = 10
+
+// marshalResponse remembers failed requests for StatusRequest and marshals response.
+func (s *serverImpl) marshalResponse(logger *slog.Logger, name string, rsp interface{}, e error, warns []util.Warning) []byte {
+	if e != nil {
+		logger.Warn("Request failed", "request", name, "error", e)
+		s.recordError(name, e)
+		s.metrics.recordRequestError(name)
+	}
+	return MarshalResponse(rsp, e, warns)
+}
+
+func (s *serverImpl) recordError(name string, e error) {
+	s.errorsMutex.Lock()
+	defer s.errorsMutex.Unlock()
+
+	s.lastErrors = append(s.lastErrors, ErrorRecord{
+		Time:    time.Now(),
+		Request: name,
+		Message: e.Error(),
+	})
+	if len(s.lastErrors) > maxLastErrors {
+		s.lastErrors = s.lastErrors[len(s.lastErrors)-maxLastErrors:]
+	}
+}
+
+func (s *serverImpl) handleStatus(_ *StatusRequest, _ *util.Warner) (rsp StatusResponse, err error) {
+	rsp.Pid = os.Getpid()
+	rsp.Version = s.version
+	rsp.ProtocolVersion = ProtocolVersion
+	rsp.StartTime = s.startTime
+	rsp.SocketPath = s.configuration.GetSocketAddress()
+
+	s.shellsMutex.Lock()
+	initialized := s.initialized
+	for _, info := range s.shellInfoMap {
+		rsp.Shells = append(rsp.Shells, ShellAndPid{
+			Shell: info.shell,
+			Pid:   info.pid,
+		})
+	}
+	s.shellsMutex.Unlock()
+	sort.Slice(rsp.Shells, func(i, j int) bool {
+		return rsp.Shells[i].Pid < rsp.Shells[j].Pid
+	})
+
+	rsp.DbPath = s.configuration.GetCompletionsSqliteDb()
+	stat, err := os.Stat(rsp.DbPath)
+	if err == nil {
+		rsp.DbSize = stat.Size()
+	} else if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	} else {
+		return
+	}
+
+	if initialized {
+		var commands map[int64]*datastore.Command
+		commands, err = s.storage.ListCommands()
+		if err != nil {
+			return
+		}
+		rsp.HelpPageCount = len(commands)
+	}
+
+	rsp.PendingJobs = int(atomic.LoadInt32(&s.activeRequests)) - 1
+
+	s.errorsMutex.Lock()
+	rsp.LastErrors = append(rsp.LastErrors, s.lastErrors...)
+	s.errorsMutex.Unlock()
+	return
+}
+
+func (s *serverImpl) handleStop(_ *StopRequest, _ *util.Warner) (rsp StopResponse, err error) {
+	s.Stop()
+	return
+}
+
+// Stop detaches all shells and stops accepting connections,
+// Serve returns as soon as requests being processed are done.
+func (s *serverImpl) Stop() {
+	s.shellsMutex.Lock()
+	defer s.shellsMutex.Unlock()
+
+	s.stopServing()
+}
+
+func (s *serverImpl) stopServing() {
+	if s.stopping {
+		return
+	}
+	s.stopping = true
+	s.shellInfoMap = make(map[int]*shellInfo)
+	slog.Info("Stop serving requests")
+
+	// Listener is already closed if the last shell was detached.
+	if err := s.closeListenerLocked(); err != nil {
+		slog.Warn("Cannot close listener", "error", err)
+	}
+}
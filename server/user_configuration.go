@@ -18,7 +18,9 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/dim-an/cod/datastore"
@@ -32,9 +34,41 @@ type Rule struct {
 	Policy       datastore.Policy `toml:"policy"`
 }
 
+// Provider describes a command that generates completions for values of a flag or a positional argument.
+type Provider struct {
+	Executable   string `toml:"executable"`
+	compiledGlob util.Selector
+	SubCommand   []string `toml:"sub-command"`
+	Flag         string   `toml:"flag"`
+	Positional   int      `toml:"positional"`
+	Command      string   `toml:"command"`
+	// Time in milliseconds during which output of the command is reused.
+	Ttl int `toml:"ttl" default:"5000"`
+}
+
+func (p *Provider) GetTtl() time.Duration {
+	return time.Millisecond * time.Duration(p.Ttl)
+}
+
+// Preference selects which of the learned executables sharing the same name provides completions for that name.
+type Preference struct {
+	Name         string `toml:"name"`
+	Executable   string `toml:"executable"`
+	compiledGlob util.Selector
+}
+
 type UserConfiguration struct {
-	Rules                   []Rule `toml:"rule"`
-	commandExecutionTimeout int    `toml:"command-execution-timeout"`
+	Rules                   []Rule       `toml:"rule"`
+	Providers               []Provider   `toml:"provider"`
+	Preferences             []Preference `toml:"prefer"`
+	commandExecutionTimeout int          `toml:"command-execution-timeout"`
+	// Time in milliseconds the daemon keeps running without attached shells and connections.
+	IdleTimeout int `toml:"idle-timeout"`
+	// Minimal level of daemon logs: debug, info, warn or error.
+	LogLevel       string `toml:"log-level"`
+	parsedLogLevel slog.Level
+	// Write Prometheus textfile with daemon metrics.
+	MetricsTextfile bool `toml:"metrics-textfile"`
 	// NOTE: defaults are set inside LoadUserConfigurationFromBytes
 }
 
@@ -42,6 +76,14 @@ func (cfg *UserConfiguration) GetCommandExecutionTimeout() time.Duration {
 	return time.Millisecond * time.Duration(cfg.commandExecutionTimeout)
 }
 
+func (cfg *UserConfiguration) GetIdleTimeout() time.Duration {
+	return time.Millisecond * time.Duration(cfg.IdleTimeout)
+}
+
+func (cfg *UserConfiguration) GetLogLevel() slog.Level {
+	return cfg.parsedLogLevel
+}
+
 func initRule(rule *Rule, homeDir string) (err error) {
 	switch rule.Policy {
 	case datastore.PolicyAsk, datastore.PolicyIgnore, datastore.PolicyTrust:
@@ -61,6 +103,48 @@ func initRule(rule *Rule, homeDir string) (err error) {
 	return nil
 }
 
+func initProvider(provider *Provider, homeDir string) (err error) {
+	if len(provider.Executable) == 0 {
+		return fmt.Errorf(`found provider with empty "executable"`)
+	}
+	if len(provider.Command) == 0 {
+		return fmt.Errorf(`provider for %q has empty "command"`, provider.Executable)
+	}
+	if provider.Positional < 0 {
+		return fmt.Errorf(`provider for %q has negative "positional"`, provider.Executable)
+	}
+	if (len(provider.Flag) == 0) == (provider.Positional == 0) {
+		return fmt.Errorf(`provider for %q must have exactly one of "flag" or "positional"`, provider.Executable)
+	}
+	if provider.Ttl < 0 {
+		return fmt.Errorf(`provider for %q has negative "ttl"`, provider.Executable)
+	}
+
+	provider.compiledGlob, err = util.CompileSelector(provider.Executable, homeDir)
+	if err != nil {
+		return fmt.Errorf("bad glob in configuration: %q: %w", provider.Executable, err)
+	}
+	return nil
+}
+
+func initPreference(preference *Preference, homeDir string) (err error) {
+	if len(preference.Name) == 0 {
+		return fmt.Errorf(`found preference with empty "name"`)
+	}
+	if strings.ContainsRune(preference.Name, os.PathSeparator) {
+		return fmt.Errorf(`preference "name" must be a name of executable not a path: %q`, preference.Name)
+	}
+	if len(preference.Executable) == 0 {
+		return fmt.Errorf(`preference for %q has empty "executable"`, preference.Name)
+	}
+
+	preference.compiledGlob, err = util.CompileSelector(preference.Executable, homeDir)
+	if err != nil {
+		return fmt.Errorf("bad glob in configuration: %q: %w", preference.Executable, err)
+	}
+	return nil
+}
+
 func LoadUserConfiguration(filename, homeDir string) (userConfiguration UserConfiguration, err error) {
 	var bytes []byte
 	bytes, err = ioutil.ReadFile(filename)
@@ -83,6 +167,8 @@ func LoadUserConfiguration(filename, homeDir string) (userConfiguration UserConf
 
 func LoadUserConfigurationFromBytes(bytes []byte, homeDir string) (userConfiguration UserConfiguration, err error) {
 	userConfiguration.commandExecutionTimeout = 1000
+	userConfiguration.IdleTimeout = int(defaultIdleTimeout / time.Millisecond)
+	userConfiguration.LogLevel = "info"
 
 	err = toml.Unmarshal(bytes, &userConfiguration)
 	if err != nil {
@@ -94,10 +180,31 @@ func LoadUserConfigurationFromBytes(bytes []byte, homeDir string) (userConfigura
 			return
 		}
 	}
+	for i := range userConfiguration.Providers {
+		err = initProvider(&userConfiguration.Providers[i], homeDir)
+		if err != nil {
+			return
+		}
+	}
+	for i := range userConfiguration.Preferences {
+		err = initPreference(&userConfiguration.Preferences[i], homeDir)
+		if err != nil {
+			return
+		}
+	}
 	if userConfiguration.commandExecutionTimeout < 0 {
 		err = fmt.Errorf("'command-execution-timeout' must not be negative")
 		return
 	}
+	if userConfiguration.IdleTimeout < 0 {
+		err = fmt.Errorf("'idle-timeout' must not be negative")
+		return
+	}
+	userConfiguration.parsedLogLevel, err = ParseLogLevel(userConfiguration.LogLevel)
+	if err != nil {
+		err = fmt.Errorf("bad 'log-level': %w", err)
+		return
+	}
 	return
 }
 
@@ -0,0 +1,180 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"strings"
+
+	"github.com/dim-an/cod/datastore"
+	"github.com/dim-an/cod/util"
+)
+
+// valueSlot describes position of the word being completed when it is a value of some flag.
+type valueSlot struct {
+	flag string
+	// Part of the word that is before the value itself (e.g. `--file=` for `--file=foo`).
+	wordPrefix string
+	// Part of the value that is already typed.
+	valuePrefix string
+}
+
+func findFlagWithValue(completions []datastore.Completion, commandPrefix []string, flag string) *datastore.Completion {
+	for idx := range completions {
+		c := &completions[idx]
+		if c.Metavar == "" {
+			continue
+		}
+		if strings.TrimSuffix(c.Flag, "=") != flag {
+			continue
+		}
+		if datastore.IsCommandMatchingContext(commandPrefix, c.Context) {
+			return c
+		}
+	}
+	return nil
+}
+
+// splitValueSlot checks if word being completed looks like a value of a flag.
+// Following cases are recognized:
+//   - `--flag=val` inside single word (zsh, fish);
+//   - `--flag = val` when shell splits words on `=` (bash);
+//   - `--flag val` or `-f val`.
+func splitValueSlot(commandPrefix []string, word string) (slot valueSlot, ok bool) {
+	if idx := strings.Index(word, "="); strings.HasPrefix(word, "-") && idx >= 0 {
+		slot.flag = word[:idx]
+		slot.wordPrefix = word[:idx+1]
+		slot.valuePrefix = word[idx+1:]
+		ok = true
+		return
+	}
+
+	if len(commandPrefix) < 2 {
+		return
+	}
+	prev := commandPrefix[len(commandPrefix)-1]
+	if prev == "=" && len(commandPrefix) >= 3 {
+		prev = commandPrefix[len(commandPrefix)-2]
+	} else if !strings.HasPrefix(prev, "-") || strings.HasSuffix(prev, "=") {
+		return
+	}
+	slot.flag = prev
+	if word != "=" {
+		// bash puts `=` into separate word, though it doesn't replace it during completion
+		slot.valuePrefix = word
+	}
+	ok = true
+	return
+}
+
+// findValueSlot checks if word being completed is a value of a learned flag that requires one.
+func findValueSlot(completions []datastore.Completion, commandPrefix []string, word string) (completion *datastore.Completion, slot valueSlot) {
+	slot, ok := splitValueSlot(commandPrefix, word)
+	if ok {
+		completion = findFlagWithValue(completions, commandPrefix, slot.flag)
+	}
+	return
+}
+
+// findPositionalIndex returns 1-based index of the word being completed among positional arguments
+// that follow given sub-command.
+// Zero is returned if word is not a positional argument or command doesn't contain sub-command.
+func findPositionalIndex(completions []datastore.Completion, commandPrefix []string, subCommand []string, word string) int {
+	if strings.HasPrefix(word, "-") {
+		return 0
+	}
+
+	start := 1
+	for _, name := range subCommand {
+		for start < len(commandPrefix) && commandPrefix[start] != name {
+			start += 1
+		}
+		if start == len(commandPrefix) {
+			return 0
+		}
+		start += 1
+	}
+
+	index := 1
+	for i := start; i < len(commandPrefix); i += 1 {
+		w := commandPrefix[i]
+		switch {
+		case w == "=":
+			// Skip value separated by bash.
+			i += 1
+		case strings.HasPrefix(w, "-"):
+			if strings.Contains(w, "=") {
+				break
+			}
+			hasValue := findFlagWithValue(completions, commandPrefix[:i], w) != nil
+			if hasValue && i+1 < len(commandPrefix) && commandPrefix[i+1] != "=" {
+				i += 1
+			}
+		default:
+			index += 1
+		}
+	}
+	return index
+}
+
+// listValues returns candidates for values of given kind.
+// Nil is returned for kinds that are better handled by shell itself (files, directories)
+// or cannot be enumerated at all.
+func (s *serverImpl) listValues(kind datastore.ValueKind) []string {
+	switch kind {
+	case datastore.ValueKindHostname:
+		return util.ListHostnames(s.configuration.GetHomeDir())
+	case datastore.ValueKindUser:
+		return util.ListUsers()
+	case datastore.ValueKindGroup:
+		return util.ListGroups()
+	case datastore.ValueKindSignal:
+		return util.ListSignals()
+	case datastore.ValueKindInterface:
+		return util.ListNetworkInterfaces()
+	default:
+		return nil
+	}
+}
+
+func makeCompletionItem(completion *datastore.Completion) (item CompletionItem) {
+	item = CompletionItem{
+		Value:       completion.Flag,
+		Display:     completion.Flag,
+		Description: completion.Description,
+		Kind:        CompletionKindSubCommand,
+		NoSpace:     strings.HasSuffix(completion.Flag, "="),
+	}
+	if strings.HasPrefix(completion.Flag, "-") {
+		item.Kind = CompletionKindFlag
+		switch {
+		case completion.Metavar == "":
+		case item.NoSpace:
+			item.Display += completion.Metavar
+		default:
+			item.Display += " " + completion.Metavar
+		}
+	}
+	item.Group = string(item.Kind)
+	return
+}
+
+func makeValueItem(value, wordPrefix, group string) CompletionItem {
+	return CompletionItem{
+		Value:   wordPrefix + value,
+		Display: value,
+		Kind:    CompletionKindValue,
+		Group:   group,
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dim-an/cod/server"
+	"github.com/dim-an/cod/util"
+	"golang.org/x/sys/unix"
+)
+
+// Shell session is a long-lived `cod api session` process started by shell glue,
+// so completing a command doesn't fork cod and connect to the daemon every time.
+//
+// Each request is a sequence of NUL terminated fields:
+//
+//	name, working directory of the shell, number of exported variables, exported variables as NAME=value,
+//	number of arguments, arguments...
+//
+// Shell sends its exported variables with each request, so commands run by the daemon
+// (e.g. providers) see variables exported after the session is started.
+//
+// Requests:
+//
+//	complete-words    c-word words...  completions in the format of `api complete-words --format v1`
+//	prompt-hook       command          script to source, see `api prompt-hook`
+//	lazy-completions  name             script registering completions of the command, see `api lazy-completions`
+//
+// Shells that cannot run coprocess (fish) start the session with --fifo, then requests are read from fifo "in"
+// and responses are written to fifo "out" of the private directory printed by `cod api session --fifo`.
+// Session keeps both fifos open for reading and writing, so opening them never blocks the shell
+// while the session is running, and removes the directory on exit, so the shell knows it is gone.
+//
+// Response starts with the line "<status> <number of lines>" followed by the lines of response.
+// Status is one of:
+//
+//	ok     request is done
+//	error  request failed, error is already written to stderr (except complete-words)
+const (
+	sessionStatusOk    = "ok"
+	sessionStatusError = "error"
+)
+
+type sessionRequest struct {
+	name string
+	dir  string
+	env  []string
+	args []string
+}
+
+type session struct {
+	config *server.Configuration
+	pid    uint
+	client *server.Client
+}
+
+func apiSessionMain(pid uint, fifoDir string) {
+	cfg, err := server.DefaultConfiguration()
+	verifyFatal(err)
+
+	s := session{
+		config: &cfg,
+		pid:    pid,
+	}
+	defer s.Close()
+
+	var input io.Reader = os.Stdin
+	var output io.Writer = os.Stdout
+	cleanup := func() {}
+	if fifoDir != "" {
+		cleanup = func() {
+			_ = os.RemoveAll(fifoDir)
+		}
+		input, output, err = openSessionFifos(fifoDir)
+		if err != nil {
+			cleanup()
+			fatal(err)
+		}
+	}
+
+	exitSignals := make(chan os.Signal, 1)
+	signal.Notify(exitSignals, unix.SIGTERM, unix.SIGINT, unix.SIGHUP)
+	go func() {
+		<-exitSignals
+		cleanup()
+		os.Exit(0)
+	}()
+	go exitWithShell(int(pid), cleanup)
+
+	err = s.Serve(input, output)
+	cleanup()
+	verifyFatal(err)
+}
+
+// openSessionFifos opens fifos of the directory and tells `cod api session --fifo` that the session is ready.
+func openSessionFifos(dir string) (input io.Reader, output io.Writer, err error) {
+	input, err = os.OpenFile(path.Join(dir, "in"), os.O_RDWR, 0)
+	if err != nil {
+		return
+	}
+	output, err = os.OpenFile(path.Join(dir, "out"), os.O_RDWR, 0)
+	if err != nil {
+		return
+	}
+	ready := os.NewFile(3, "ready")
+	_, err = ready.Write([]byte{'\n'})
+	_ = ready.Close()
+	return
+}
+
+// apiStartFifoSessionMain starts session serving fifos in background and waits until it is ready.
+func apiStartFifoSessionMain(pid uint) {
+	cfg, err := server.DefaultConfiguration()
+	verifyFatal(err)
+
+	runDir := cfg.GetRunDir()
+	err = util.CreatePrivateDir(runDir)
+	verifyFatal(err)
+	dir, err := os.MkdirTemp(runDir, "session-")
+	verifyFatal(err)
+	removeDirAndFail := func(err error) {
+		_ = os.RemoveAll(dir)
+		fatal(err)
+	}
+	for _, name := range []string{"in", "out"} {
+		err = unix.Mkfifo(path.Join(dir, name), 0600)
+		if err != nil {
+			removeDirAndFail(err)
+		}
+	}
+
+	executable, err := os.Executable()
+	verifyFatal(err)
+	readyReader, readyWriter, err := os.Pipe()
+	verifyFatal(err)
+	cmd := exec.Command(executable, "api", "session", "--fifo-dir", dir, "--", strconv.Itoa(int(pid)))
+	// Session must not keep output of the shell command substitution open, stderr is left to the shell.
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{readyWriter}
+	cmd.SysProcAttr = &unix.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	_ = readyWriter.Close()
+	if err != nil {
+		removeDirAndFail(err)
+	}
+
+	_, err = readyReader.Read(make([]byte, 1))
+	if err != nil {
+		_ = cmd.Process.Kill()
+		removeDirAndFail(fmt.Errorf("session is not started: %w", err))
+	}
+	fmt.Println(dir)
+	fmt.Println(cmd.Process.Pid)
+}
+
+// exitWithShell stops the session when the shell exits even if the input is not closed,
+// e.g. it is inherited by a background process started from the shell.
+func exitWithShell(pid int, cleanup func()) {
+	for {
+		if unix.Kill(pid, 0) != nil {
+			cleanup()
+			os.Exit(0)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// Serve processes requests until the shell closes input.
+func (s *session) Serve(input io.Reader, output io.Writer) (err error) {
+	reader := bufio.NewReader(input)
+	writer := bufio.NewWriter(output)
+	for {
+		var req sessionRequest
+		req, err = readSessionRequest(reader)
+		if err == io.EOF {
+			err = nil
+			return
+		} else if err != nil {
+			return
+		}
+
+		status, lines := s.handleRequest(&req)
+		_, err = fmt.Fprintf(writer, "%v %v\n", status, len(lines))
+		if err != nil {
+			return
+		}
+		for _, line := range lines {
+			_, err = fmt.Fprintln(writer, line)
+			if err != nil {
+				return
+			}
+		}
+		err = writer.Flush()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Request sends request to the daemon. Connection is reestablished once if it is broken,
+// e.g. daemon was restarted since previous request.
+func (s *session) Request(req interface{}, rsp interface{}) (err error) {
+	for attempt := 0; ; attempt += 1 {
+		if s.client == nil {
+			s.client, err = connectDaemon(s.config)
+			if err != nil {
+				return
+			}
+		}
+		err = s.client.Request(req, rsp)
+		var errorResponse *server.ErrorResponse
+		if err == nil || errors.As(err, &errorResponse) || attempt > 0 {
+			return
+		}
+		_ = s.client.Close()
+		s.client = nil
+	}
+}
+
+func (s *session) Close() {
+	if s.client != nil {
+		_ = s.client.Close()
+		s.client = nil
+	}
+}
+
+func readSessionRequest(reader *bufio.Reader) (req sessionRequest, err error) {
+	readField := func() (field string) {
+		if err != nil {
+			return
+		}
+		field, err = reader.ReadString(0)
+		if err == io.EOF && len(field) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		field = strings.TrimSuffix(field, "\x00")
+		return
+	}
+
+	readList := func(what string) (list []string) {
+		countField := readField()
+		if err != nil {
+			return
+		}
+		count, parseErr := strconv.Atoi(countField)
+		if parseErr != nil || count < 0 {
+			err = fmt.Errorf("bad number of %v of %v request: %q", what, req.name, countField)
+			return
+		}
+		for i := 0; i < count; i += 1 {
+			list = append(list, readField())
+		}
+		return
+	}
+
+	req.name = readField()
+	if err != nil {
+		return
+	}
+	req.dir = readField()
+	req.env = readList("variables")
+	req.args = readList("arguments")
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+func (s *session) handleRequest(req *sessionRequest) (status string, lines []string) {
+	var err error
+	status = sessionStatusOk
+	switch req.name {
+	case "complete-words":
+		// Shell shows completions right under the command line, warnings would mess it up.
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+		lines, err = s.completeWords(req)
+		if err != nil {
+			return sessionStatusError, nil
+		}
+	case "prompt-hook":
+		lines, err = s.promptHook(req)
+	case "lazy-completions":
+		lines, err = s.lazyCompletions(req)
+	default:
+		err = fmt.Errorf("unknown session request: %v", req.name)
+	}
+
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%v: error: %v\n", path.Base(CodBinaryPath), err)
+		return sessionStatusError, nil
+	}
+	return
+}
+
+func (s *session) completeWords(req *sessionRequest) (lines []string, err error) {
+	if len(req.args) < 2 {
+		err = fmt.Errorf("command line cannot be empty")
+		return
+	}
+	cword, err := strconv.Atoi(req.args[0])
+	if err != nil {
+		return
+	}
+	rsp, err := completeWords(s, s.pid, cword, req.args[1:], req.dir, req.env)
+	if err != nil {
+		return
+	}
+
+	var buffer bytes.Buffer
+	writeCompletionItemsV1(&buffer, rsp.ValueKind, rsp.Items)
+	lines = splitLines(buffer.String())
+	return
+}
+
+func (s *session) promptHook(req *sessionRequest) (lines []string, err error) {
+	if len(req.args) != 1 {
+		err = fmt.Errorf("prompt-hook expects single command, got %v arguments", len(req.args))
+		return
+	}
+	script, err := promptHookScript(s, s.pid, req.args[0], req.dir, req.env)
+	for _, line := range script {
+		lines = append(lines, strings.Split(line, "\n")...)
+	}
+	return
+}
+
+func (s *session) lazyCompletions(req *sessionRequest) (lines []string, err error) {
+	if len(req.args) != 1 {
+		err = fmt.Errorf("lazy-completions expects single command name, got %v arguments", len(req.args))
+		return
+	}
+	script, err := lazyCompletionsScript(s, s.pid, req.args[0])
+	for _, line := range script {
+		lines = append(lines, strings.Split(line, "\n")...)
+	}
+	return
+}
+
+func splitLines(text string) []string {
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
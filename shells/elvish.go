@@ -0,0 +1,126 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shells
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/dim-an/cod/datastore"
+)
+
+//
+// Elvish
+//
+
+// Elvish evaluates init and update scripts in separate namespaces,
+// so cod completer is kept inside arg-completer map under `__cod` key
+// where all scripts can find it.
+type Elvish struct {
+	codCommandPath string
+}
+
+// quoteElvishString quotes string as elvish single quoted string,
+// single quote inside is doubled.
+func quoteElvishString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func (e *Elvish) GetPreamble() (script []string) {
+	codBinaryVar := fmt.Sprintf("var cod-binary = %v", quoteElvishString(e.codCommandPath))
+	scriptText := `
+use str
+
+set edit:completion:arg-completer[__cod] = {|@words|
+	var lines = []
+	try {
+		set lines = [((external $cod-binary) api complete-words --format v1 -- $pid (to-string (- (count $words) 1)) $@words 2> /dev/null)]
+	} catch {
+		return
+	}
+	if (== (count $lines) 0) {
+		return
+	}
+	# First line is a kind of the value being completed,
+	# other lines are: value, display, kind, group, nospace, description separated by tab.
+	var value-kind = $lines[0]
+	for line $lines[1..] {
+		var fields = [(str:split "\t" $line)]
+		var display = $fields[1]
+		if (not-eq $fields[5] '') {
+			set display = $display'  '$fields[5]
+		}
+		var code-suffix = ' '
+		if (eq $fields[4] 1) {
+			set code-suffix = ''
+		}
+		edit:complex-candidate $fields[0] &display=$display &code-suffix=$code-suffix
+	}
+	if (has-value ['' file directory] $value-kind) {
+		edit:complete-filename $words[-1]
+	}
+}
+
+# Hooks cannot be removed from the list, so this one does nothing after cod is deinitialized.
+set edit:after-command = [$@edit:after-command {|m|
+	if (not (has-key $edit:completion:arg-completer __cod)) {
+		return
+	}
+	if (and (eq $m[error] $nil) (not-eq $m[src][code] '')) {
+		try {
+			(external $cod-binary) api postexec -- $pid $m[src][code]
+		} catch { }
+	}
+	try {
+		eval ((external $cod-binary) api poll-updates -- $pid | slurp)
+	} catch { }
+}]
+
+(external $cod-binary) api attach -- $pid elvish
+`
+
+	script = []string{
+		codBinaryVar,
+		scriptText,
+	}
+	return
+}
+
+func (e *Elvish) GenerateCompletions(executablePath string, _ []datastore.Completion) []string {
+	return []string{
+		fmt.Sprintf(
+			"set edit:completion:arg-completer[%v] = $edit:completion:arg-completer[__cod]",
+			quoteElvishString(filepath.Base(executablePath)),
+		),
+	}
+}
+
+func (e *Elvish) ResetCommand(executablePath string) []string {
+	name := quoteElvishString(filepath.Base(executablePath))
+	return []string{
+		fmt.Sprintf(
+			"if (and (has-key $edit:completion:arg-completer %v) (eq $edit:completion:arg-completer[%v] $edit:completion:arg-completer[__cod])) { "+
+				"set edit:completion:arg-completer = (dissoc $edit:completion:arg-completer %v) }",
+			name, name, name,
+		),
+	}
+}
+
+func (e *Elvish) GetDeinitScript() []string {
+	return []string{
+		"set edit:completion:arg-completer = (dissoc $edit:completion:arg-completer __cod)",
+	}
+}
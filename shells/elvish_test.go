@@ -0,0 +1,81 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shells
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuoteElvishString(t *testing.T) {
+	for _, tc := range []struct {
+		value  string
+		quoted string
+	}{
+		{"/usr/bin/cod", "'/usr/bin/cod'"},
+		{"with space", "'with space'"},
+		{"it's", "'it''s'"},
+		{`say "hi"`, `'say "hi"'`},
+		{"two\nlines", "'two\nlines'"},
+		{"tab\there", "'tab\there'"},
+		{`back\slash`, `'back\slash'`},
+		{"a'#b", "'a''#b'"},
+		{"$HOME", "'$HOME'"},
+	} {
+		require.Equal(t, tc.quoted, quoteElvishString(tc.value))
+	}
+}
+
+func TestQuoteElvishStringInShell(t *testing.T) {
+	elvish := lookupShell(t, "elvish")
+	for _, value := range hardToQuoteValues {
+		require.Equal(t, value, runShell(t, elvish, "-norc", "-c", "print "+quoteElvishString(value)))
+	}
+}
+
+func TestElvishScript(t *testing.T) {
+	gen, err := NewShellScriptGenerator("elvish", "/usr/bin/cod")
+	require.NoError(t, err)
+
+	preamble := strings.Join(gen.GetPreamble(), "\n")
+	require.True(t, strings.HasPrefix(preamble, "var cod-binary = '/usr/bin/cod'"))
+	require.Contains(t, preamble, "api attach -- $pid elvish")
+
+	require.Equal(
+		t,
+		[]string{
+			"set edit:completion:arg-completer['foo'] = $edit:completion:arg-completer[__cod]",
+		},
+		gen.GenerateCompletions("/usr/bin/foo", nil),
+	)
+	require.Equal(
+		t,
+		[]string{
+			"if (and (has-key $edit:completion:arg-completer 'foo') " +
+				"(eq $edit:completion:arg-completer['foo'] $edit:completion:arg-completer[__cod])) { " +
+				"set edit:completion:arg-completer = (dissoc $edit:completion:arg-completer 'foo') }",
+		},
+		gen.ResetCommand("/usr/bin/foo"),
+	)
+}
+
+func TestElvishScriptSyntax(t *testing.T) {
+	elvish := lookupShell(t, "elvish")
+	// Editor module exists only in interactive shell, stub namespace lets the script compile.
+	script := append([]string{"var edit: = (ns [&])"}, generateFullScript(t, "elvish", `it's "a b"`)...)
+	runShell(t, elvish, "-norc", "-compileonly", writeScript(t, script))
+}
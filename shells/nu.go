@@ -0,0 +1,125 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shells
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dim-an/cod/datastore"
+)
+
+//
+// Nu
+//
+
+// Nu uses single external completer for all commands,
+// so learned executables don't need to be registered one by one.
+type Nu struct {
+	codCommandPath string
+}
+
+// quoteNuString quotes string as nushell raw string, e.g. r#'foo'#.
+func quoteNuString(s string) string {
+	hashes := "#"
+	for strings.Contains(s, "'"+hashes) {
+		hashes += "#"
+	}
+	return "r" + hashes + "'" + s + "'" + hashes
+}
+
+func (n *Nu) GetPreamble() (script []string) {
+	codBinaryVar := fmt.Sprintf("$env.__COD_BINARY = %v", quoteNuString(n.codCommandPath))
+	scriptText := `
+$env.__cod_recent_command = ''
+
+# Returns null when cod knows nothing about value so nushell falls back to file completion.
+let __cod_complete_nu = {|spans|
+    let result = (^$env.__COD_BINARY api complete-words --format v1 -- $nu.pid (($spans | length) - 1) ...$spans | complete)
+    if $result.exit_code != 0 {
+        return null
+    }
+    # First line is a kind of the value being completed,
+    # other lines are: value, display, kind, group, nospace, description separated by tab.
+    let lines = ($result.stdout | lines)
+    let value_kind = ($lines | get 0? | default '')
+    let items = ($lines | skip 1 | each {|line|
+        let fields = ($line | split row "\t")
+        {value: ($fields | get 0), description: ($fields | get 5? | default '')}
+    })
+    if ($items | is-empty) and ($value_kind in ['' 'file' 'directory']) {
+        null
+    } else {
+        $items
+    }
+}
+
+$env.__cod_prev_completer = ($env.config.completions?.external?.completer?)
+
+$env.config = ($env.config
+    | upsert completions.external.enable true
+    | upsert completions.external.completer {|spans|
+        let items = (do $__cod_complete_nu $spans)
+        if ($items == null) and ($env.__cod_prev_completer? != null) {
+            do $env.__cod_prev_completer $spans
+        } else {
+            $items
+        }
+    }
+    # Hooks cannot be removed from the list, so they do nothing after cod is deinitialized.
+    | upsert hooks.pre_execution (($env.config.hooks?.pre_execution? | default []) | append {||
+        if ($env.__COD_BINARY? == null) {
+            return
+        }
+        $env.__cod_recent_command = (commandline)
+    })
+    | upsert hooks.pre_prompt (($env.config.hooks?.pre_prompt? | default []) | append {||
+        if ($env.__COD_BINARY? == null) {
+            return
+        }
+        if ($env.LAST_EXIT_CODE == 0) and ($env.__cod_recent_command != '') {
+            ^$env.__COD_BINARY api postexec -- $nu.pid $env.__cod_recent_command
+        }
+        $env.__cod_recent_command = ''
+        ^$env.__COD_BINARY api poll-updates -- $nu.pid | ignore
+    })
+)
+
+^$env.__COD_BINARY api attach -- $nu.pid nu
+`
+
+	script = []string{
+		codBinaryVar,
+		scriptText,
+	}
+	return
+}
+
+func (n *Nu) GenerateCompletions(_ string, _ []datastore.Completion) []string {
+	return nil
+}
+
+func (n *Nu) ResetCommand(_ string) []string {
+	return nil
+}
+
+func (n *Nu) GetDeinitScript() []string {
+	return []string{`
+$env.config = ($env.config | upsert completions.external.completer $env.__cod_prev_completer?)
+hide-env __COD_BINARY
+hide-env __cod_recent_command
+hide-env __cod_prev_completer
+`}
+}
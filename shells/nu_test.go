@@ -0,0 +1,66 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shells
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuoteNuString(t *testing.T) {
+	for _, tc := range []struct {
+		value  string
+		quoted string
+	}{
+		{"/usr/bin/cod", `r#'/usr/bin/cod'#`},
+		{"with space", `r#'with space'#`},
+		{"it's", `r#'it's'#`},
+		{`say "hi"`, `r#'say "hi"'#`},
+		{"two\nlines", "r#'two\nlines'#"},
+		{"tab\there", "r#'tab\there'#"},
+		{`back\slash`, `r#'back\slash'#`},
+		{"a'#b", `r##'a'#b'##`},
+		{"$HOME", `r#'$HOME'#`},
+	} {
+		require.Equal(t, tc.quoted, quoteNuString(tc.value))
+	}
+}
+
+func TestQuoteNuStringInShell(t *testing.T) {
+	nu := lookupShell(t, "nu")
+	for _, value := range hardToQuoteValues {
+		require.Equal(t, value, runShell(t, nu, "--no-config-file", "-c", "print -n "+quoteNuString(value)))
+	}
+}
+
+func TestNuScript(t *testing.T) {
+	gen, err := NewShellScriptGenerator("nu", "/usr/bin/cod")
+	require.NoError(t, err)
+
+	preamble := strings.Join(gen.GetPreamble(), "\n")
+	require.True(t, strings.HasPrefix(preamble, `$env.__COD_BINARY = r#'/usr/bin/cod'#`))
+	require.Contains(t, preamble, "api attach -- $nu.pid nu")
+
+	require.Empty(t, gen.GenerateCompletions("/usr/bin/foo", nil))
+	require.Empty(t, gen.ResetCommand("/usr/bin/foo"))
+}
+
+func TestNuScriptSyntax(t *testing.T) {
+	nu := lookupShell(t, "nu")
+	fileName := writeScript(t, generateFullScript(t, "nu", `it's "a b"`))
+	runShell(t, nu, "--no-config-file", "-c", "nu-check --debug "+quoteNuString(fileName))
+}
@@ -31,7 +31,21 @@ func isVariableAssignment(s string) bool {
 }
 
 func ParseSimpleCommand(cmd string) (env, args []string, err error) {
-	tokens, err := Tokenize(cmd)
+	return ParseShellCommand("bash", cmd)
+}
+
+// ParseShellCommand parses simple command using tokenization rules of given shell.
+// PowerShell has its own rules, all other shells are close enough to bash.
+func ParseShellCommand(shell, cmd string) (env, args []string, err error) {
+	var tokens []Token
+	allowEnv := true
+	if shell == "pwsh" {
+		// PowerShell doesn't support `VAR=value cmd` syntax.
+		allowEnv = false
+		tokens, err = TokenizePowerShell(cmd)
+	} else {
+		tokens, err = Tokenize(cmd)
+	}
 	if err != nil {
 		return
 	}
@@ -43,7 +57,7 @@ func ParseSimpleCommand(cmd string) (env, args []string, err error) {
 			env = nil
 			return
 		}
-		if isVariableAssignment(t.Decoded) && len(args) == 0 {
+		if allowEnv && isVariableAssignment(t.Decoded) && len(args) == 0 {
 			env = append(env, t.Decoded)
 		} else {
 			args = append(args, t.Decoded)
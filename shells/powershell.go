@@ -0,0 +1,164 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shells
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/dim-an/cod/datastore"
+)
+
+//
+// PowerShell
+//
+
+// PowerShell registers native argument completer for each learned executable.
+// All of them share single script block stored in `$global:__CodCompleter`.
+type PowerShell struct {
+	codCommandPath string
+}
+
+// quotePowerShellString quotes string as PowerShell verbatim string,
+// single quote inside is doubled.
+func quotePowerShellString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func (p *PowerShell) GetPreamble() (script []string) {
+	codBinaryVar := fmt.Sprintf("$global:__CodBinary = %v", quotePowerShellString(p.codCommandPath))
+	scriptText := `
+# Native commands are started via ProcessStartInfo.ArgumentList,
+# so arguments (including empty ones) are passed exactly as is.
+# Output of the command is returned unless -Foreground is specified,
+# in that case command works with the terminal directly (e.g. to ask user a question).
+function global:__CodRun([string[]]$Arguments, [switch]$Foreground) {
+    $startInfo = [System.Diagnostics.ProcessStartInfo]::new($global:__CodBinary)
+    foreach ($argument in $Arguments) {
+        $startInfo.ArgumentList.Add($argument)
+    }
+    $startInfo.UseShellExecute = $false
+    $startInfo.WorkingDirectory = (Get-Location -PSProvider FileSystem).ProviderPath
+    if (-not $Foreground) {
+        $startInfo.RedirectStandardOutput = $true
+        $startInfo.RedirectStandardError = $true
+    }
+    $process = [System.Diagnostics.Process]::Start($startInfo)
+    if ($Foreground) {
+        $process.WaitForExit()
+        return
+    }
+    $stderr = $process.StandardError.ReadToEndAsync()
+    $stdout = $process.StandardOutput.ReadToEnd()
+    $process.WaitForExit()
+    $null = $stderr.Result
+    if ($process.ExitCode -eq 0) {
+        $stdout
+    }
+}
+
+$global:__CodCompleter = {
+    param($wordToComplete, $commandAst, $cursorPosition)
+
+    $words = @(foreach ($element in $commandAst.CommandElements) {
+        if ($element.Extent.EndOffset -lt $cursorPosition) {
+            if ($element -is [System.Management.Automation.Language.StringConstantExpressionAst]) {
+                $element.Value
+            } else {
+                $element.Extent.Text
+            }
+        }
+    })
+    $words += $wordToComplete
+
+    $output = __CodRun (@('api', 'complete-words', '--format', 'v1', '--', $PID, ($words.Count - 1)) + $words)
+    if (-not $output) {
+        return
+    }
+    # First line is a kind of the value being completed,
+    # other lines are: value, display, kind, group, nospace, description separated by tab.
+    # Nothing is returned when there are no items, so PowerShell falls back to path completion.
+    foreach ($line in ($output -split "\r?\n" | Select-Object -Skip 1)) {
+        if (-not $line) {
+            continue
+        }
+        $fields = $line -split "` + "`" + `t"
+        $value = $fields[0]
+        if ($value -match '[\s''"` + "`" + `$@(){};,|&<>#]') {
+            $value = "'" + ($value -replace "'", "''") + "'"
+        }
+        $display = if ($fields[1]) { $fields[1] } else { $fields[0] }
+        $toolTip = if ($fields[5]) { $fields[5] } else { $display }
+        $resultType = if ($fields[2] -eq 'flag') { 'ParameterName' } else { 'ParameterValue' }
+        [System.Management.Automation.CompletionResult]::new($value, $display, $resultType, $toolTip)
+    }
+}
+
+$global:__CodOriginalPrompt = $function:prompt
+$global:__CodLastHistoryId = (Get-History -Count 1).Id
+
+function global:prompt {
+    $success = $?
+    $lastCommand = Get-History -Count 1
+    if ($lastCommand -and $lastCommand.Id -ne $global:__CodLastHistoryId) {
+        $global:__CodLastHistoryId = $lastCommand.Id
+        if ($success) {
+            __CodRun @('api', 'postexec', '--', $PID, $lastCommand.CommandLine) -Foreground
+        }
+    }
+    $update = __CodRun @('api', 'poll-updates', '--', $PID)
+    if ($update) {
+        Invoke-Expression $update
+    }
+    & $global:__CodOriginalPrompt
+}
+
+__CodRun @('api', 'attach', '--', $PID, 'pwsh') -Foreground
+`
+
+	script = []string{
+		codBinaryVar,
+		scriptText,
+	}
+	return
+}
+
+func (p *PowerShell) GenerateCompletions(executablePath string, _ []datastore.Completion) []string {
+	return []string{
+		fmt.Sprintf(
+			"Register-ArgumentCompleter -Native -CommandName %v -ScriptBlock $global:__CodCompleter",
+			quotePowerShellString(filepath.Base(executablePath)),
+		),
+	}
+}
+
+func (p *PowerShell) ResetCommand(executablePath string) []string {
+	// There is no way to unregister native completer, empty one makes PowerShell use default completion.
+	return []string{
+		fmt.Sprintf(
+			"Register-ArgumentCompleter -Native -CommandName %v -ScriptBlock { }",
+			quotePowerShellString(filepath.Base(executablePath)),
+		),
+	}
+}
+
+func (p *PowerShell) GetDeinitScript() []string {
+	return []string{`
+Set-Item -Path Function:\global:prompt -Value $global:__CodOriginalPrompt
+Remove-Item -Path Function:\global:__CodRun
+Remove-Variable -Scope Global -Name __CodCompleter, __CodOriginalPrompt, __CodLastHistoryId, __CodBinary
+`}
+}
@@ -0,0 +1,42 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package shells
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotePowerShellString(t *testing.T) {
+	require.Equal(t, `'/usr/bin/cod'`, quotePowerShellString("/usr/bin/cod"))
+	require.Equal(t, `'it''s $HOME'`, quotePowerShellString("it's $HOME"))
+}
+
+func TestPowerShellScript(t *testing.T) {
+	gen, err := NewShellScriptGenerator("pwsh", "/usr/bin/cod")
+	require.NoError(t, err)
+
+	preamble := strings.Join(gen.GetPreamble(), "\n")
+	require.True(t, strings.HasPrefix(preamble, `$global:__CodBinary = '/usr/bin/cod'`))
+	require.Contains(t, preamble, "function global:prompt")
+
+	require.Equal(t,
+		[]string{`Register-ArgumentCompleter -Native -CommandName 'foo' -ScriptBlock $global:__CodCompleter`},
+		gen.GenerateCompletions("/usr/bin/foo", nil))
+	require.Equal(t,
+		[]string{`Register-ArgumentCompleter -Native -CommandName 'foo' -ScriptBlock { }`},
+		gen.ResetCommand("/usr/bin/foo"))
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shells
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Values that are hard to quote, shared by quoting tests of all shells.
+var hardToQuoteValues = []string{
+	"/usr/bin/cod",
+	"with space",
+	"it's",
+	`say "hi"`,
+	"two\nlines",
+	"tab\there",
+	`back\slash`,
+	"a'#b",
+	"$HOME",
+}
+
+// lookupShell returns path of the shell binary, test is skipped if the shell is not installed.
+func lookupShell(t *testing.T, name string) string {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		t.Skipf("%v is not installed", name)
+	}
+	return path
+}
+
+// writeScript writes script to temporary file.
+func writeScript(t *testing.T, script []string) (fileName string) {
+	fileName = filepath.Join(t.TempDir(), "script")
+	err := os.WriteFile(fileName, []byte(strings.Join(script, "\n")+"\n"), 0644)
+	require.NoError(t, err)
+	return
+}
+
+// runShell runs the shell and returns its stdout.
+func runShell(t *testing.T, shellPath string, args ...string) string {
+	cmd := exec.Command(shellPath, args...)
+	cmd.Stdin = nil
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	require.NoError(t, err, "stderr: %v", stderr.String())
+	return string(out)
+}
+
+// newTestGenerator returns generator whose cod binary path needs quoting.
+func newTestGenerator(t *testing.T, shell string) ShellScriptGenerator {
+	gen, err := NewShellScriptGenerator(shell, "/opt/it's cod/bin/cod")
+	require.NoError(t, err)
+	return gen
+}
+
+// generateFullScript returns everything the generator emits into the shell for an executable with the name.
+func generateFullScript(t *testing.T, shell, executableName string) []string {
+	gen := newTestGenerator(t, shell)
+
+	var script []string
+	script = append(script, gen.GetPreamble()...)
+	script = append(script, gen.GenerateCompletions("/usr/bin/"+executableName, nil)...)
+	script = append(script, gen.ResetCommand("/usr/bin/"+executableName)...)
+	script = append(script, gen.GetDeinitScript()...)
+	return script
+}
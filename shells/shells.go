@@ -23,10 +23,39 @@ import (
 
 type ShellScriptGenerator interface {
 	GetPreamble() []string
+	// GetDeinitScript returns script that removes hooks and functions installed by preamble.
+	// Completions must be reset with ResetCommand before this script is run.
+	GetDeinitScript() []string
 	GenerateCompletions(executableName string, completions []datastore.Completion) []string
 	ResetCommand(executablePath string) []string
 }
 
+// ProviderScriptGenerator is implemented by generators that complete learned data without calling cod.
+// Such generators need additional completions for executables that have user-defined value providers.
+type ProviderScriptGenerator interface {
+	GenerateProviderCompletions(executablePath string) []string
+}
+
+// GenerationScriptGenerator is implemented by generators whose prompt hook contacts daemon
+// only when content of the generation file is changed.
+type GenerationScriptGenerator interface {
+	GenerationFileScript(fileName string) []string
+}
+
+// LazyScriptGenerator is implemented by generators that can register completions of a command
+// when it is completed for the first time instead of registering all learned executables at init.
+type LazyScriptGenerator interface {
+	// GetLazyScript returns script that installs default completer asking daemon about unknown commands.
+	// stubDir contains files written for CompletionStubGenerator.
+	GetLazyScript(stubDir string) []string
+}
+
+// CompletionStubGenerator is implemented by lazy generators of shells that load completions
+// from a file named after the command. Daemon keeps a stub file for each learned executable name.
+type CompletionStubGenerator interface {
+	CompletionStub(executableName string) (fileName string, content string)
+}
+
 func NewShellScriptGenerator(shell string, codBinary string) (ShellScriptGenerator, error) {
 	switch shell {
 	case "bash":
@@ -41,6 +70,22 @@ func NewShellScriptGenerator(shell string, codBinary string) (ShellScriptGenerat
 		return &Zsh{
 			codBinary,
 		}, nil
+	case "nu":
+		return &Nu{
+			codBinary,
+		}, nil
+	case "elvish":
+		return &Elvish{
+			codBinary,
+		}, nil
+	case "xonsh":
+		return &Xonsh{
+			codBinary,
+		}, nil
+	case "pwsh":
+		return &PowerShell{
+			codBinary,
+		}, nil
 	default:
 		return nil, fmt.Errorf("unknown shell: %v", shell)
 	}
@@ -71,10 +116,97 @@ function __cod_preexec_zsh() {
     __cod_recent_command_zsh="$3"
 }
 
+__cod_session_pid=
+__cod_session_in=
+__cod_session_out=
+__cod_session_status=
+__cod_session_lines=()
+
+# Starts "cod api session" coprocess that serves requests of this shell, so they don't fork cod.
+# Pipes of the coprocess are moved to other descriptors and the coprocess is disowned,
+# so it is not shown in the list of jobs.
+function __cod_session_start() {
+    setopt local_options no_monitor
+    local job
+
+    coproc command $__COD_BINARY api session -- $$
+    __cod_session_pid=$!
+    exec {__cod_session_in}>&p {__cod_session_out}<&p
+    for job in ${(k)jobstates} ; do
+        if [[ ${jobstates[$job]} == *:$__cod_session_pid=* ]] ; then
+            disown %$job
+        fi
+    done
+}
+
+function __cod_session_stop() {
+    if [[ -n $__cod_session_pid ]] ; then
+        kill $__cod_session_pid 2> /dev/null
+        exec {__cod_session_in}>&- {__cod_session_out}<&-
+        __cod_session_pid=
+    fi
+}
+
+# Sends request to the session and reads response into __cod_session_status and __cod_session_lines.
+# Fails if the session is not available, caller is expected to run cod itself then.
+# Broken session is stopped and a new one is started on the next request.
+function __cod_session_request() {
+    setopt local_options local_traps
+    local name="$1" response_status response_count line var
+    local -a env
+    shift
+    __cod_session_status=
+    __cod_session_lines=()
+
+    if [[ -z $__cod_session_pid ]] ; then
+        __cod_session_start
+    fi
+
+    # Exported variables are sent with each request, since they might be changed since the session is started.
+    for var in ${(k)parameters[(R)*export*]} ; do
+        env+=("$var=${(P)var}")
+    done
+
+    # Writing to the session that has just died must not kill the shell with SIGPIPE.
+    trap '' PIPE
+    if ! printf '%s\0' "$name" "$PWD" ${#env} "${env[@]}" $# "$@" 2> /dev/null >&$__cod_session_in ||
+        ! read -r -t 30 -u $__cod_session_out response_status response_count 2> /dev/null ; then
+        __cod_session_stop
+        return 1
+    fi
+    while (( response_count-- > 0 )) && IFS= read -r -u $__cod_session_out line ; do
+        __cod_session_lines+=("$line")
+    done
+    __cod_session_status=$response_status
+    return 0
+}
+
+__cod_generation_file=
+__cod_generation=
+
+# Checks if executed command is a help command and applies pending updates of completions.
+# Daemon is not contacted unless the command asks for help (see help command detection of the daemon)
+# or content of the generation file is changed since the last successful check.
+function __cod_prompt_hook() {
+    local generation
+    read -r generation 2> /dev/null < "$__cod_generation_file"
+    if [[ "$1" != *--help* ]] && [[ -n $generation ]] && [[ $generation == "$__cod_generation" ]] ; then
+        return 0
+    fi
+
+    if __cod_session_request prompt-hook "$1" ; then
+        if [[ $__cod_session_status == ok ]] ; then
+            __cod_generation=$generation
+        fi
+        eval "${(F)__cod_session_lines}"
+    else
+        source <(command $__COD_BINARY api prompt-hook -- $$ "$1")
+    fi
+}
+
 function __cod_postexec_zsh() {
     if [[ "$?" == 0 ]] && [[ -n $__cod_recent_command_zsh ]] ; then
-        command $__COD_BINARY api postexec -- $$ "$__cod_recent_command_zsh"
-        source <(command $__COD_BINARY api poll-updates -- $$)
+        __cod_prompt_hook "$__cod_recent_command_zsh"
     fi
 
     return "$old_exit_code"
@@ -82,25 +214,43 @@ function __cod_postexec_zsh() {
 
 function __cod_add_completions() {
 	# -n :: not override existing completions
-    compdef -n __cod_complete_zsh "$1"
+    compdef -n "$2" "$1"
 }
 
 function __cod_clear_completions() {
-    if [[ "${_comps[$1]}" == __cod_complete_zsh ]] ; then
+    if [[ "${_comps[$1]}" == _cod_* ]] ; then
         compdef -d "$1"
     fi
 }
 
-function __cod_complete_zsh() {
-    local c
-	local cs
-	local c_word
-	c_word=$(($CURRENT - 1))
-	cs=("${(f)$(command $__COD_BINARY api complete-words -- $$ "$c_word" "${words[@]}")}")
-	for c in "${cs[@]}" ; do
-        compadd -- "$c"
-    done
-	_path_files
+# Completes values known to the daemon (e.g. produced by user-defined providers).
+# It is used as an action of generated _arguments specs, _arguments modifies $words,
+# so original command line is taken from __cod_words set by the generated function.
+# Arguments are the command used when daemon knows nothing about the value.
+function __cod_complete_zsh_values() {
+	local line
+	local lines
+	local fields
+	local ret=1
+	if __cod_session_request complete-words "$((__cod_current - 1))" "${__cod_words[@]}" ; then
+		lines=("${__cod_session_lines[@]}")
+	else
+		lines=("${(f)$(command $__COD_BINARY api complete-words --format v1 -- $$ "$((__cod_current - 1))" "${__cod_words[@]}" 2> /dev/null)}")
+	fi
+	# First line is a kind of the value being completed,
+	# other lines are: value, display, kind, group, nospace, description separated by tab.
+	for line in "${(@)lines[2,-1]}" ; do
+		fields=("${(@ps:\t:)line}")
+		# Flags and sub-commands are completed by _arguments itself.
+		# Display of value doesn't contain '--flag=' prefix that _arguments has already moved to IPREFIX.
+		if [[ "${fields[3]}" == value ]] ; then
+			compadd -J "${fields[4]}" -- "${fields[2]}" && ret=0
+		fi
+	done
+	if (( ret )) && (( $# )) ; then
+		"$@" && ret=0
+	fi
+	return ret
 }
 precmd_functions+=("__cod_postexec_zsh")
 preexec_functions+=("__cod_preexec_zsh")
@@ -115,13 +265,75 @@ command $__COD_BINARY api attach -- $$ zsh
 	return
 }
 
-func (z *Zsh) GenerateCompletions(executablePath string, _ []datastore.Completion) (script []string) {
-	script = []string{
-		fmt.Sprintf(
-			"__cod_add_completions %v",
-			quoteArg(filepath.Base(executablePath)),
-		),
-	}
+func (z *Zsh) GenerationFileScript(fileName string) []string {
+	return []string{"__cod_generation_file=" + quoteArg(fileName)}
+}
+
+func (z *Zsh) GetLazyScript(_ string) []string {
+	return []string{`
+typeset -gA __cod_lazy_checked
+__cod_prev_first_completion=${_comps[-first-]-}
+if [[ $__cod_prev_first_completion == __cod_complete_first ]] ; then
+    __cod_prev_first_completion=
+fi
+
+# Registers completions of the command when it is completed for the first time.
+# Each command is checked once, commands learned later are registered by prompt hook.
+# Completion is passed to the previous -first- completer if cod doesn't know the command.
+function __cod_complete_first() {
+    local name=${words[1]:t}
+    local script
+    if (( CURRENT > 1 )) && [[ -n $name ]] && [[ -z ${_comps[$name]-} ]] && [[ -z ${__cod_lazy_checked[$name]-} ]] ; then
+        __cod_lazy_checked[$name]=1
+        if __cod_session_request lazy-completions "$name" ; then
+            script=${(F)__cod_session_lines}
+        else
+            script=$(command $__COD_BINARY api lazy-completions -- $$ "$name" 2> /dev/null)
+        fi
+        eval "$script"
+        if [[ -n ${_comps[$name]-} ]] ; then
+            _compskip=all
+            eval "${_comps[$name]}"
+            return
+        fi
+    fi
+    if [[ -n $__cod_prev_first_completion ]] ; then
+        eval "$__cod_prev_first_completion"
+        return
+    fi
+    return 1
+}
+compdef __cod_complete_first -first-
+`}
+}
+
+func (z *Zsh) GetDeinitScript() []string {
+	return []string{`
+if [[ ${_comps[-first-]-} == __cod_complete_first ]] ; then
+    if [[ -n $__cod_prev_first_completion ]] ; then
+        compdef "$__cod_prev_first_completion" -first-
+    else
+        compdef -d -first-
+    fi
+fi
+precmd_functions=(${precmd_functions:#__cod_postexec_zsh})
+preexec_functions=(${preexec_functions:#__cod_preexec_zsh})
+__cod_session_stop
+unfunction -m '_cod_*' '__cod_*'
+unset __COD_BINARY __cod_recent_command_zsh
+unset __cod_session_pid __cod_session_in __cod_session_out __cod_session_status __cod_session_lines
+unset __cod_generation_file __cod_generation __cod_lazy_checked __cod_prev_first_completion
+`}
+}
+
+func (z *Zsh) GenerateCompletions(executablePath string, completions []datastore.Completion) (script []string) {
+	name := filepath.Base(executablePath)
+	script = generateZshFunctions(name, completions, true)
+	script = append(script, fmt.Sprintf(
+		"__cod_add_completions %v %v",
+		quoteArg(name),
+		zshNodeFunction(name, &completionNode{}),
+	))
 	return
 }
 
@@ -140,10 +352,15 @@ type Fish struct {
 	codCommandPath string
 }
 
-func (f *Fish) GenerateCompletions(executablePath string, _ []datastore.Completion) (shellScript []string) {
+func (f *Fish) GenerateCompletions(executablePath string, completions []datastore.Completion) (shellScript []string) {
+	shellScript = generateFishRules(filepath.Base(executablePath), completions)
+	return
+}
+
+func (f *Fish) GenerateProviderCompletions(executablePath string) (shellScript []string) {
 	shellScript = []string{
-		fmt.Sprintf("complete --command %s --arguments '(__cod_complete_fish)'",
-			quoteArg(filepath.Base(executablePath))),
+		fmt.Sprintf("complete --command %s --arguments '(__cod_complete_fish_values)'",
+			QuoteFishString(filepath.Base(executablePath))),
 	}
 	return
 }
@@ -159,13 +376,101 @@ func (f *Fish) GetPreamble() (lines []string) {
 	lines = []string{
 		fmt.Sprintf("set -g __COD_BINARY %v", quoteArg(f.codCommandPath)),
 		`
-function __cod_complete_fish
+set -g __cod_session_dir
+set -g __cod_session_pid
+set -g __cod_session_status
+set -g __cod_session_lines
+
+# Starts "cod api session" in background, fish cannot run coprocess, so the session serves fifos.
+function __cod_session_start
+    set -l session (command $__COD_BINARY api session --fifo -- %self)
+    or return 1
+    set -g __cod_session_dir $session[1]
+    set -g __cod_session_pid $session[2]
+end
+
+# Session removes its fifos on exit, it cannot do it only if it is killed.
+# Opening fifo nobody serves would block the shell, so it is checked before each request.
+function __cod_session_alive
+    test -n "$__cod_session_dir" -a -p "$__cod_session_dir/in" -a -p "$__cod_session_dir/out"
+    or return 1
+    if test -d /proc/self
+        test -d /proc/$__cod_session_pid
+    end
+end
+
+function __cod_session_stop
+    if test -n "$__cod_session_pid"
+        command kill $__cod_session_pid 2> /dev/null
+    end
+    set -g __cod_session_dir
+    set -g __cod_session_pid
+end
+
+# Sends request to the session and reads response into __cod_session_status and __cod_session_lines.
+# Fails if the session is not available, caller is expected to run cod itself then.
+# Broken session is stopped and a new one is started on the next request.
+function __cod_session_request
+    set -g __cod_session_status
+    set -g __cod_session_lines
+    if not __cod_session_alive
+        __cod_session_stop
+        __cod_session_start
+        or return 1
+    end
+
+    # Exported variables are sent with each request, since they might be changed since the session is started.
+    # Fish exports lists joined with colons for path variables and with spaces for other ones.
+    set -l env
+    for var in (set --names --export)
+        set -l value
+        if string match -q -- '*PATH' $var
+            set value (string join : -- $$var)
+        else
+            set value (string join ' ' -- $$var)
+        end
+        set -a env "$var=$value"
+    end
+
+    set -l header
+    set -l line
+    if printf '%s\0' $argv[1] $PWD (count $env) $env (math (count $argv) - 1) $argv[2..-1] > $__cod_session_dir/in 2> /dev/null
+        begin
+            read --line header
+            set header (string split ' ' -- $header)
+            while string match -qr '^[1-9][0-9]*$' -- "$header[2]"
+                read --line line
+                or break
+                set -a __cod_session_lines "$line"
+                set header[2] (math $header[2] - 1)
+            end
+        end < $__cod_session_dir/out 2> /dev/null
+    end
+    if test (count $header) -ne 2 -o "$header[2]" != 0
+        __cod_session_stop
+        return 1
+    end
+    set -g __cod_session_status $header[1]
+end
+
+# Completes values produced by user-defined providers, everything else is completed by native rules.
+function __cod_complete_fish_values
     set -l words (commandline --current-process --tokenize --cut-at-cursor)
     set -l cword (count $words)
     set -l words $words (commandline --current-token --cut-at-cursor)
-    set -l compreply (command $__COD_BINARY api complete-words -- %self "$cword" $words)
-    for entry in $compreply
-        echo $entry
+    set -l compreply
+    if __cod_session_request complete-words "$cword" $words
+        set compreply $__cod_session_lines
+    else
+        set compreply (command $__COD_BINARY api complete-words --format v1 -- %self "$cword" $words 2> /dev/null)
+    end
+    # First line is a kind of the value being completed,
+    # other lines are: value, display, kind, group, nospace, description separated by tab.
+    for entry in $compreply[2..-1]
+        set -l fields (string split \t -- $entry)
+        if test "$fields[3]" = value
+            printf '%s\t%s\n' $fields[1] $fields[6]
+        end
     end
     return 0
 end
@@ -174,8 +479,16 @@ function __fish_cod_get_completions
 end
 
 function __cod_postexec_fish --on-event fish_postexec
+	set -l exit_status $status
 	set -l cmd "$argv[1]"
-	if test -n "$cmd" -a "$status" -eq 0
+	if test "$exit_status" -ne 0
+		set cmd ""
+	end
+	if __cod_session_request prompt-hook "$cmd"
+		string join \n -- $__cod_session_lines | source
+		return
+	end
+	if test -n "$cmd"
 		command $__COD_BINARY api postexec -- %self "$cmd"
 	end
 	command $__COD_BINARY api poll-updates -- %self | source
@@ -187,6 +500,47 @@ cod api attach -- %self fish
 	return
 }
 
+// GetLazyScript puts directory with completion stubs in front of fish_complete_path,
+// fish loads stub of the command when it is completed for the first time.
+func (f *Fish) GetLazyScript(stubDir string) []string {
+	return []string{
+		fmt.Sprintf("set -g __cod_stub_dir %v", QuoteFishString(stubDir)),
+		`set -g fish_complete_path $__cod_stub_dir (string match --invert -- $__cod_stub_dir $fish_complete_path)`,
+	}
+}
+
+// CompletionStub returns file that registers completions of the executable
+// and loads completions of the same command that are shadowed by the stub.
+func (f *Fish) CompletionStub(executableName string) (fileName string, content string) {
+	fileName = executableName + ".fish"
+	content = fmt.Sprintf(`# Generated by cod, loaded by fish on first completion of the command.
+if __cod_session_request lazy-completions %[1]v
+    string join \n -- $__cod_session_lines | source
+else
+    command $__COD_BINARY api lazy-completions -- %%self %[1]v 2> /dev/null | source
+end
+for dir in $fish_complete_path
+    if test "$dir" != "$__cod_stub_dir" -a -f "$dir"/%[2]v
+        source "$dir"/%[2]v
+        break
+    end
+end
+`, QuoteFishString(executableName), QuoteFishString(fileName))
+	return
+}
+
+func (f *Fish) GetDeinitScript() []string {
+	return []string{`
+if set -q __cod_stub_dir
+    set fish_complete_path (string match --invert -- $__cod_stub_dir $fish_complete_path)
+end
+__cod_session_stop
+functions --erase __cod_complete_fish_values __fish_cod_get_completions __cod_postexec_fish
+functions --erase __cod_session_start __cod_session_alive __cod_session_stop __cod_session_request
+set --erase __COD_BINARY __cod_stub_dir __cod_session_dir __cod_session_pid __cod_session_status __cod_session_lines
+`}
+}
+
 //
 // Bash
 //
@@ -233,6 +587,61 @@ function __cod_unref_trace() {
 	fi
 }
 
+__cod_session_status=
+__cod_session_lines=()
+
+# Starts "cod api session" coprocess that serves requests of this shell, so they don't fork cod.
+# Coprocess is disowned, so it is not shown in the list of jobs.
+function __cod_session_start() {
+	{ coproc __COD_SESSION { command $__COD_BINARY api session -- $$ 2>&3 3>&- ; } ; } 3>&2 2> /dev/null
+	disown "$__COD_SESSION_PID" 2> /dev/null
+}
+
+function __cod_session_stop() {
+	if [ -n "${__COD_SESSION_PID-}" ] ; then
+		kill "$__COD_SESSION_PID" 2> /dev/null
+	fi
+}
+
+# Sends request to the session and reads response into __cod_session_status and __cod_session_lines.
+# Fails if the session is not available, caller is expected to run cod itself then.
+# Broken session is stopped and a new one is started on the next request.
+function __cod_session_request() {
+	local status count line write_status var prev_trap
+	local env=() vars=()
+	__cod_session_status=
+	__cod_session_lines=()
+
+	if [ -z "${__COD_SESSION_PID-}" ] ; then
+		__cod_session_start
+	fi
+
+	# Exported variables are sent with each request, since they might be changed since the session is started.
+	readarray -t vars < <(compgen -e)
+	for var in "${vars[@]}" ; do
+		env+=("$var=${!var}")
+	done
+
+	# Writing to the session that has just died must not kill the shell with SIGPIPE,
+	# PIPE trap of the user is restored afterwards.
+	prev_trap=$(trap -p PIPE)
+	trap '' PIPE
+	printf '%s\0' "$1" "$PWD" "${#env[@]}" "${env[@]}" "$(($# - 1))" "${@:2}" 2> /dev/null >&"${__COD_SESSION[1]}"
+	write_status=$?
+	eval "${prev_trap:-trap - PIPE}"
+
+	if [ "$write_status" != 0 ] || ! read -r -t 30 -u "${__COD_SESSION[0]}" status count 2> /dev/null ; then
+		__cod_session_stop
+		return 1
+	fi
+	while [ "$count" -gt 0 ] && IFS= read -r -u "${__COD_SESSION[0]}" line ; do
+		__cod_session_lines+=("$line")
+		: $((count--))
+	done
+	__cod_session_status=$status
+	return 0
+}
+
 function __cod_add_completions() {
 	$cod_enable_trace && __cod_ref_trace
 
@@ -256,7 +665,30 @@ function __cod_clear_completions() {
 function __cod_complete_bash() {
 	$cod_enable_trace && __cod_ref_trace
 
-	# First we want to get file completions.
+	local FILE_COMPLETIONS
+	local COD_COMPLETIONS=()
+	local COD_LINES
+	local VALUE_KIND
+	local ONLY_NOSPACE=true
+
+	# Generate cod completions.
+	# First line is a kind of the value being completed,
+	# other lines are: value, display, kind, group, nospace, description separated by tab.
+	if __cod_session_request complete-words "$COMP_CWORD" "${COMP_WORDS[@]}" ; then
+		COD_LINES=("${__cod_session_lines[@]}")
+	else
+		readarray -t COD_LINES < <(command $__COD_BINARY api complete-words --format v1 -- $$ "$COMP_CWORD" "${COMP_WORDS[@]}" 2> /dev/null)
+	fi
+	VALUE_KIND="${COD_LINES[0]}"
+
+	local LINE VALUE DISPLAY KIND GROUP NOSPACE DESCRIPTION
+	for LINE in "${COD_LINES[@]:1}" ; do
+		IFS=$'\t' read -r VALUE DISPLAY KIND GROUP NOSPACE DESCRIPTION <<< "$LINE"
+		COD_COMPLETIONS+=("$VALUE")
+		if [ "$NOSPACE" != 1 ] ; then
+			ONLY_NOSPACE=false
+		fi
+	done
 
 	local FILTEROPT
 	if [ -z "$2" ] ; then
@@ -264,27 +696,20 @@ function __cod_complete_bash() {
 		FILTEROPT='.*'
 	fi
 
-	local FILE_COMPLETIONS
-	local COD_COMPLETIONS
-	# Generate file completions
-	readarray -t FILE_COMPLETIONS < <(compgen -f -X "$FILTEROPT" -- "$2")
-
-	# Generate cod completions.
-	readarray -t COD_COMPLETIONS < <(command $__COD_BINARY api complete-words -- $$ "$COMP_CWORD" "${COMP_WORDS[@]}" 2> /dev/null)
+	# Generate file completions only when value might be a file.
+	case "$VALUE_KIND" in
+		''|file)
+			readarray -t FILE_COMPLETIONS < <(compgen -f -X "$FILTEROPT" -- "$2")
+			;;
+		directory)
+			readarray -t FILE_COMPLETIONS < <(compgen -d -X "$FILTEROPT" -- "$2")
+			;;
+	esac
 
 	COMPREPLY=("${FILE_COMPLETIONS[@]}" "${COD_COMPLETIONS[@]}")
 
-	local NAME
-	local ONLY_EQ=true
-	# Now we don't want bash to add trailing space for options that end with '='
-	for NAME in "${COD_COMPLETIONS[@]}" ; do
-		if [ "${NAME: -1}" != "=" ] ; then
-			ONLY_EQ=false
-			break
-		fi
-	done
-
-	if $ONLY_EQ ; then
+	# Now we don't want bash to add trailing space for options like '--output='
+	if $ONLY_NOSPACE ; then
 		compopt -o nospace
 	fi
 
@@ -292,6 +717,30 @@ function __cod_complete_bash() {
 	return 0
 }
 
+__cod_generation_file=
+__cod_generation=
+
+# Checks if executed command is a help command and applies pending updates of completions.
+# Daemon is not contacted unless the command asks for help (see help command detection of the daemon)
+# or content of the generation file is changed since the last successful check.
+function __cod_prompt_hook() {
+	local generation script
+	read -r generation 2> /dev/null < "$__cod_generation_file"
+	if [[ "$1" != *--help* ]] && [ -n "$generation" ] && [ "$generation" = "$__cod_generation" ] ; then
+		return 0
+	fi
+
+	if __cod_session_request prompt-hook "$1" ; then
+		if [ "$__cod_session_status" = ok ] ; then
+			__cod_generation=$generation
+		fi
+		printf -v script '%s\n' "${__cod_session_lines[@]}"
+		eval "$script"
+	else
+		source <(command $__COD_BINARY api prompt-hook -- $$ "$1")
+	fi
+}
+
 __cod_postexec_bash_prev_index=
 __cod_postexec_bash_first_invocation=1
 
@@ -329,8 +778,7 @@ function __cod_postexec_bash() {
 		fi
 
 		command="${fc_out[@]:1}"
-		command $__COD_BINARY api postexec -- $$ "$command"
-		source <(command $__COD_BINARY api poll-updates -- $$)
+		__cod_prompt_hook "$command"
 		break
 	done
 
@@ -346,3 +794,60 @@ PROMPT_COMMAND="__cod_postexec_bash;$PROMPT_COMMAND"
 	}
 	return
 }
+
+func (b *Bash) GenerationFileScript(fileName string) []string {
+	return []string{"__cod_generation_file=" + quoteArg(fileName)}
+}
+
+func (b *Bash) GetLazyScript(_ string) []string {
+	return []string{`
+declare -gA __cod_lazy_checked=()
+__cod_prev_default_completion=$(complete -p -D 2> /dev/null)
+if [[ $__cod_prev_default_completion == *__cod_complete_default* ]] ; then
+	__cod_prev_default_completion=
+fi
+
+# Registers completions of the command when it is completed for the first time and asks bash to retry.
+# Each command is checked once, commands learned later are registered by prompt hook.
+# Completion is passed to the previous default completer if cod doesn't know the command.
+function __cod_complete_default() {
+	local name=${1##*/}
+	local script
+	if [ -n "$name" ] && [ -z "${__cod_lazy_checked[$name]-}" ] ; then
+		__cod_lazy_checked[$name]=1
+		if __cod_session_request lazy-completions "$name" ; then
+			printf -v script '%s\n' "${__cod_session_lines[@]}"
+		else
+			script=$(command $__COD_BINARY api lazy-completions -- $$ "$name" 2> /dev/null)
+		fi
+		eval "$script"
+		if complete -p "$name" &> /dev/null ; then
+			return 124
+		fi
+	fi
+	if [[ $__cod_prev_default_completion =~ -F\ ([^ ]+) ]] ; then
+		"${BASH_REMATCH[1]}" "$@"
+		return
+	fi
+	return 1
+}
+complete -D -o bashdefault -o default -F __cod_complete_default
+`}
+}
+
+func (b *Bash) GetDeinitScript() []string {
+	return []string{`
+if [[ $(complete -p -D 2> /dev/null) == *__cod_complete_default* ]] ; then
+	complete -r -D
+	eval "$__cod_prev_default_completion"
+fi
+PROMPT_COMMAND="${PROMPT_COMMAND//__cod_postexec_bash;/}"
+__cod_session_stop
+unset -f __cod_ref_trace __cod_unref_trace __cod_add_completions __cod_clear_completions __cod_complete_bash __cod_postexec_bash
+unset -f __cod_session_start __cod_session_stop __cod_session_request __cod_prompt_hook
+unset __cod_ref_count __cod_postexec_bash_prev_index __cod_postexec_bash_first_invocation __COD_BINARY
+unset -f __cod_complete_default
+unset __cod_session_status __cod_session_lines __cod_generation_file __cod_generation
+unset __cod_lazy_checked __cod_prev_default_completion
+`}
+}
@@ -0,0 +1,40 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shells
+
+import (
+	"testing"
+)
+
+func TestScriptSyntax(t *testing.T) {
+	for _, tc := range []struct {
+		shell      string
+		noExecFlag string
+	}{
+		{"bash", "-n"},
+		{"zsh", "-n"},
+		{"fish", "--no-execute"},
+	} {
+		t.Run(tc.shell, func(t *testing.T) {
+			shellPath := lookupShell(t, tc.shell)
+			script := generateFullScript(t, tc.shell, "it's")
+			if gen, ok := newTestGenerator(t, tc.shell).(CompletionStubGenerator); ok {
+				_, stub := gen.CompletionStub("it's")
+				script = append(script, stub)
+			}
+			runShell(t, shellPath, tc.noExecFlag, writeScript(t, script))
+		})
+	}
+}
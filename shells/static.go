@@ -0,0 +1,219 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shells
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dim-an/cod/datastore"
+)
+
+// StaticScriptGenerator generates self-contained completion scripts
+// that can be installed into shell completion directory and don't require cod to work.
+type StaticScriptGenerator interface {
+	// StaticFileName returns name of the completion file for given executable name.
+	StaticFileName(executableName string) string
+	GenerateStaticScript(executablePath string, completions []datastore.Completion) string
+}
+
+func NewStaticScriptGenerator(shell string) (StaticScriptGenerator, error) {
+	switch shell {
+	case "bash":
+		return &Bash{}, nil
+	case "fish":
+		return &Fish{}, nil
+	case "zsh":
+		return &Zsh{}, nil
+	default:
+		return nil, fmt.Errorf("static completions are not supported for shell: %v", shell)
+	}
+}
+
+// flagGroup is a set of flags that are aliases of each other (e.g. `-v` and `--verbose`).
+type flagGroup struct {
+	// Flag names without trailing `=`.
+	names []string
+	// Flag requires value.
+	takesValue bool
+	// Names of flags that were learned in `--flag=VALUE` form.
+	joined      map[string]bool
+	metavar     string
+	kind        datastore.ValueKind
+	description string
+}
+
+type subCommandItem struct {
+	name        string
+	description string
+}
+
+// completionNode contains completions of a command or one of its sub-commands.
+type completionNode struct {
+	path        []string
+	flags       []*flagGroup
+	subCommands []subCommandItem
+}
+
+func (n *completionNode) key() string {
+	return strings.Join(n.path, " ")
+}
+
+// inheritedFlags returns flags of the node and all its ancestors, since flags of a command
+// are accepted by its sub-commands too. Flags of the node itself come first.
+func (n *completionNode) inheritedFlags(nodes []*completionNode) (flags []*flagGroup) {
+	seen := map[string]bool{}
+	for depth := len(n.path); depth >= 0; depth -= 1 {
+		ancestor := findCompletionNode(nodes, n.path[:depth])
+		if ancestor == nil {
+			continue
+		}
+	groupLoop:
+		for _, group := range ancestor.flags {
+			for _, name := range group.names {
+				if seen[name] {
+					continue groupLoop
+				}
+			}
+			for _, name := range group.names {
+				seen[name] = true
+			}
+			flags = append(flags, group)
+		}
+	}
+	return
+}
+
+func findCompletionNode(nodes []*completionNode, path []string) *completionNode {
+	key := strings.Join(path, " ")
+	for _, n := range nodes {
+		if n.key() == key {
+			return n
+		}
+	}
+	return nil
+}
+
+func isFlag(s string) bool {
+	return strings.HasPrefix(s, "-") && s != "-"
+}
+
+// buildCompletionTree groups completions by sub-command contexts.
+// Root node goes first, others are sorted by their path.
+// Adjacent flags of the same context sharing description are considered aliases.
+func buildCompletionTree(completions []datastore.Completion) (nodes []*completionNode) {
+	nodeMap := map[string]*completionNode{}
+	getNode := func(path []string) *completionNode {
+		key := strings.Join(path, " ")
+		n, ok := nodeMap[key]
+		if !ok {
+			n = &completionNode{path: path}
+			nodeMap[key] = n
+		}
+		return n
+	}
+	getNode(nil)
+
+	seen := map[string]bool{}
+	var previousGroup *flagGroup
+	var previousNode *completionNode
+	for idx := range completions {
+		c := &completions[idx]
+		path := c.Context.SubCommand
+		n := getNode(path)
+
+		name := strings.TrimSuffix(c.Flag, "=")
+		if name == "" || seen[n.key()+"\x00"+name] {
+			continue
+		}
+		seen[n.key()+"\x00"+name] = true
+
+		if !isFlag(c.Flag) {
+			previousGroup = nil
+			n.subCommands = append(n.subCommands, subCommandItem{
+				name:        c.Flag,
+				description: c.Description,
+			})
+			childPath := append(append([]string{}, path...), c.Flag)
+			getNode(childPath)
+			continue
+		}
+
+		// Aliases are listed on the same help line, so only the group of the previous flag is considered,
+		// unrelated flags sharing generic description like "deprecated" are kept apart.
+		var group *flagGroup
+		if g := previousGroup; g != nil && previousNode == n && c.Description != "" && g.description == c.Description {
+			// Metavar is usually specified only for the last alias, e.g. `-o, --output=FILE`.
+			if g.metavar == c.Metavar || g.metavar == "" || c.Metavar == "" {
+				group = g
+			}
+		}
+		if group == nil {
+			group = &flagGroup{
+				takesValue:  c.Metavar != "",
+				joined:      map[string]bool{},
+				metavar:     c.Metavar,
+				kind:        c.ValueKind(),
+				description: c.Description,
+			}
+			n.flags = append(n.flags, group)
+		}
+		previousGroup, previousNode = group, n
+		group.names = append(group.names, name)
+		if c.Metavar != "" {
+			group.metavar = c.Metavar
+			group.kind = c.ValueKind()
+			group.takesValue = true
+		}
+		if strings.HasSuffix(c.Flag, "=") {
+			group.joined[name] = true
+			group.takesValue = true
+		}
+	}
+
+	for _, n := range nodeMap {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].key() < nodes[j].key()
+	})
+	return
+}
+
+// sanitizeIdentifier makes string suitable to be a part of shell function name.
+// Bytes other than ASCII letters and digits are hex escaped (e.g. `-` becomes `_2d`),
+// so different strings never get the same identifier and `__` never appears inside of it.
+func sanitizeIdentifier(s string) string {
+	var sb strings.Builder
+	for _, c := range []byte(s) {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			sb.WriteByte(c)
+		} else {
+			_, _ = fmt.Fprintf(&sb, "_%02x", c)
+		}
+	}
+	return sb.String()
+}
+
+// singleLine replaces line breaks and tabs so text can be used inside of one line comment or string.
+func singleLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// quoteSingle quotes string with single quotes the way bash and zsh understand.
+func quoteSingle(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
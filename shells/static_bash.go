@@ -0,0 +1,168 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shells
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/dim-an/cod/datastore"
+	"github.com/dim-an/cod/util"
+)
+
+func (b *Bash) StaticFileName(executableName string) string {
+	return executableName
+}
+
+func bashValueCompletion(kind datastore.ValueKind) string {
+	switch kind {
+	case datastore.ValueKindUnknown, datastore.ValueKindFile:
+		return `readarray -t COMPREPLY < <(compgen -f -- "$cur")`
+	case datastore.ValueKindDirectory:
+		return `readarray -t COMPREPLY < <(compgen -d -- "$cur")`
+	case datastore.ValueKindHostname:
+		return `readarray -t COMPREPLY < <(compgen -A hostname -- "$cur")`
+	case datastore.ValueKindUser:
+		return `readarray -t COMPREPLY < <(compgen -u -- "$cur")`
+	case datastore.ValueKindGroup:
+		return `readarray -t COMPREPLY < <(compgen -g -- "$cur")`
+	case datastore.ValueKindSignal:
+		return `readarray -t COMPREPLY < <(compgen -W ` + quoteSingle(strings.Join(util.ListSignals(), " ")) + ` -- "$cur")`
+	case datastore.ValueKindInterface:
+		return `readarray -t COMPREPLY < <(compgen -W "$(command ls /sys/class/net 2> /dev/null)" -- "$cur")`
+	default:
+		return ":"
+	}
+}
+
+// GenerateStaticScript generates bash-completion file.
+// Sub-command context is tracked while walking through the words of command line,
+// values of flags are completed using compgen according to their kind.
+func (b *Bash) GenerateStaticScript(executablePath string, completions []datastore.Completion) string {
+	name := filepath.Base(executablePath)
+	function := "_cod_static_" + sanitizeIdentifier(name)
+	nodes := buildCompletionTree(completions)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# bash completion for %v\n", singleLine(name))
+	fmt.Fprintf(&sb, "# Generated by cod from help of %v, do not edit.\n\n", singleLine(executablePath))
+	fmt.Fprintf(&sb, "%v() {\n", function)
+	sb.WriteString(`	local cur="${COMP_WORDS[COMP_CWORD]}"
+	local prev="${COMP_WORDS[COMP_CWORD-1]}"
+	local context=
+	local key word i
+	local -a words
+
+	# bash puts '=' of '--flag=value' into separate word
+	if [ "$cur" = "=" ] ; then
+		cur=
+	elif [ "$prev" = "=" ] && [ "$COMP_CWORD" -gt 1 ] ; then
+		prev="${COMP_WORDS[COMP_CWORD-2]}"
+	fi
+`)
+
+	var subCommandPaths []string
+	for _, n := range nodes {
+		if len(n.path) > 0 {
+			subCommandPaths = append(subCommandPaths, quoteSingle(n.key()))
+		}
+	}
+	if len(subCommandPaths) > 0 {
+		sb.WriteString(`
+	for ((i = 1; i < COMP_CWORD; i++)) ; do
+		key="${context:+$context }${COMP_WORDS[i]}"
+		case "$key" in
+`)
+		fmt.Fprintf(&sb, "\t\t\t%v)\n", strings.Join(subCommandPaths, "|"))
+		sb.WriteString(`				context="$key"
+				;;
+		esac
+	done
+`)
+	}
+
+	sb.WriteString("\n\tcase \"$context\" in\n")
+	for _, n := range nodes {
+		fmt.Fprintf(&sb, "\t\t%v)\n", quoteSingle(n.key()))
+
+		flags := n.inheritedFlags(nodes)
+		var valueCases []string
+		for _, group := range flags {
+			if !group.takesValue {
+				continue
+			}
+			var patterns []string
+			for _, name := range group.names {
+				patterns = append(patterns, quoteSingle(name))
+			}
+			valueCases = append(valueCases, fmt.Sprintf(
+				"\t\t\t\t%v)\n\t\t\t\t\t%v\n\t\t\t\t\treturn 0\n\t\t\t\t\t;;\n",
+				strings.Join(patterns, "|"),
+				bashValueCompletion(group.kind),
+			))
+		}
+		if len(valueCases) > 0 {
+			sb.WriteString("\t\t\tcase \"$prev\" in\n")
+			for _, c := range valueCases {
+				sb.WriteString(c)
+			}
+			sb.WriteString("\t\t\tesac\n")
+		}
+
+		sb.WriteString("\t\t\twords=(\n")
+		writeWord := func(word, description string) {
+			if description != "" {
+				fmt.Fprintf(&sb, "\t\t\t\t%v # %v\n", quoteSingle(word), singleLine(description))
+			} else {
+				fmt.Fprintf(&sb, "\t\t\t\t%v\n", quoteSingle(word))
+			}
+		}
+		for _, item := range n.subCommands {
+			writeWord(item.name, item.description)
+		}
+		for _, group := range flags {
+			for _, name := range group.names {
+				if group.joined[name] {
+					name += "="
+				}
+				writeWord(name, group.description)
+			}
+		}
+		sb.WriteString("\t\t\t)\n\t\t\t;;\n")
+	}
+	sb.WriteString("\tesac\n")
+
+	sb.WriteString(`
+	COMPREPLY=()
+	for word in "${words[@]}" ; do
+		if [[ "$word" == "$cur"* ]] ; then
+			COMPREPLY+=("$word")
+		fi
+	done
+	if [[ "$cur" != -* ]] ; then
+		readarray -t -O "${#COMPREPLY[@]}" COMPREPLY < <(compgen -f -- "$cur")
+	fi
+
+	# Don't add trailing space after '--flag='
+	if [ "${#COMPREPLY[@]}" -eq 1 ] && [[ "${COMPREPLY[0]}" == -*= ]] ; then
+		compopt -o nospace
+	fi
+	return 0
+}
+`)
+	fmt.Fprintf(&sb, "\ncomplete -o filenames -F %v %v\n", function, quoteSingle(name))
+	return sb.String()
+}
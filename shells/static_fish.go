@@ -0,0 +1,135 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shells
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/dim-an/cod/datastore"
+	"github.com/dim-an/cod/util"
+)
+
+func (f *Fish) StaticFileName(executableName string) string {
+	return executableName + ".fish"
+}
+
+// QuoteFishString quotes string as fish single quoted string.
+func QuoteFishString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `'`, `\'`)
+	return "'" + s + "'"
+}
+
+// fishValueArgs returns options of `complete` builtin that describe value of a flag.
+func fishValueArgs(kind datastore.ValueKind) []string {
+	switch kind {
+	case datastore.ValueKindUnknown, datastore.ValueKindFile:
+		return []string{"--require-parameter", "--force-files"}
+	case datastore.ValueKindDirectory:
+		return []string{"--exclusive", "--arguments", "'(__fish_complete_directories)'"}
+	case datastore.ValueKindHostname:
+		return []string{"--exclusive", "--arguments", "'(__fish_print_hostnames)'"}
+	case datastore.ValueKindUser:
+		return []string{"--exclusive", "--arguments", "'(__fish_complete_users)'"}
+	case datastore.ValueKindGroup:
+		return []string{"--exclusive", "--arguments", "'(__fish_complete_groups)'"}
+	case datastore.ValueKindSignal:
+		return []string{"--exclusive", "--arguments", QuoteFishString(strings.Join(util.ListSignals(), " "))}
+	case datastore.ValueKindInterface:
+		return []string{"--exclusive", "--arguments", "'(__fish_print_interfaces)'"}
+	default:
+		return []string{"--exclusive"}
+	}
+}
+
+// fishFlagArgs returns options of `complete` builtin that describe names of the flag group.
+func fishFlagArgs(group *flagGroup) (args []string) {
+	for _, name := range group.names {
+		switch {
+		case strings.HasPrefix(name, "--"):
+			args = append(args, "--long-option", QuoteFishString(name[2:]))
+		case len(name) == 2:
+			args = append(args, "--short-option", QuoteFishString(name[1:]))
+		default:
+			args = append(args, "--old-option", QuoteFishString(name[1:]))
+		}
+	}
+	return
+}
+
+// fishSubCommandCondition returns condition that is true when all sub-commands of the path were typed.
+func fishSubCommandCondition(path []string) string {
+	var conditions []string
+	for _, p := range path {
+		conditions = append(conditions, "__fish_seen_subcommand_from "+QuoteFishString(p))
+	}
+	return strings.Join(conditions, "; and ")
+}
+
+// generateFishRules generates `complete` commands for every learned flag and sub-command.
+// Each rule is guarded by condition that checks sub-command context.
+func generateFishRules(name string, completions []datastore.Completion) (rules []string) {
+	command := []string{"complete", "--command", QuoteFishString(name)}
+	for _, n := range buildCompletionTree(completions) {
+		if len(n.subCommands) > 0 {
+			var names []string
+			for _, item := range n.subCommands {
+				names = append(names, QuoteFishString(item.name))
+			}
+			condition := "__fish_use_subcommand"
+			if len(n.path) > 0 {
+				condition = fishSubCommandCondition(n.path) + "; and not __fish_seen_subcommand_from " + strings.Join(names, " ")
+			}
+			for _, item := range n.subCommands {
+				rule := append(append([]string{}, command...),
+					"--condition", QuoteFishString(condition),
+					"--arguments", QuoteFishString(item.name),
+				)
+				if item.description != "" {
+					rule = append(rule, "--description", QuoteFishString(singleLine(item.description)))
+				}
+				rules = append(rules, strings.Join(rule, " "))
+			}
+		}
+
+		for _, group := range n.flags {
+			rule := append(append([]string{}, command...), fishFlagArgs(group)...)
+			if len(n.path) > 0 {
+				rule = append(rule, "--condition", QuoteFishString(fishSubCommandCondition(n.path)))
+			}
+			if group.takesValue {
+				rule = append(rule, fishValueArgs(group.kind)...)
+			}
+			if group.description != "" {
+				rule = append(rule, "--description", QuoteFishString(singleLine(group.description)))
+			}
+			rules = append(rules, strings.Join(rule, " "))
+		}
+	}
+	return
+}
+
+// GenerateStaticScript generates fish completion file suitable for `~/.config/fish/completions`.
+func (f *Fish) GenerateStaticScript(executablePath string, completions []datastore.Completion) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Generated by cod from help of %v, do not edit.\n\n", singleLine(executablePath))
+	for _, rule := range generateFishRules(filepath.Base(executablePath), completions) {
+		sb.WriteString(rule)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
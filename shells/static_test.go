@@ -0,0 +1,165 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shells
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dim-an/cod/datastore"
+	"github.com/stretchr/testify/require"
+)
+
+var staticTestCompletions = []datastore.Completion{
+	{Flag: "-o", Metavar: "FILE", Description: "write output"},
+	{Flag: "--output=", Metavar: "FILE", Description: "write output"},
+	{Flag: "--verbose", Description: "be verbose"},
+	{Flag: "build", Description: "build it"},
+	{Flag: "--release", Context: datastore.FlagContext{SubCommand: []string{"build"}}, Description: "optimized build"},
+	{Flag: "--jobs", Metavar: "N", Context: datastore.FlagContext{SubCommand: []string{"build"}}},
+}
+
+func TestBuildCompletionTree(t *testing.T) {
+	nodes := buildCompletionTree(staticTestCompletions)
+	require.Len(t, nodes, 2)
+
+	root := nodes[0]
+	require.Equal(t, "", root.key())
+	require.Equal(t, []subCommandItem{{"build", "build it"}}, root.subCommands)
+	require.Len(t, root.flags, 2)
+	require.Equal(t, []string{"-o", "--output"}, root.flags[0].names)
+	require.Equal(t, map[string]bool{"--output": true}, root.flags[0].joined)
+	require.Equal(t, datastore.ValueKindFile, root.flags[0].kind)
+	require.True(t, root.flags[0].takesValue)
+	require.False(t, root.flags[1].takesValue)
+
+	build := nodes[1]
+	require.Equal(t, "build", build.key())
+	var names []string
+	for _, group := range build.inheritedFlags(nodes) {
+		names = append(names, group.names...)
+	}
+	require.Equal(t, []string{"--release", "--jobs", "-o", "--output", "--verbose"}, names)
+}
+
+func TestBuildCompletionTreeNonAdjacentAliases(t *testing.T) {
+	nodes := buildCompletionTree([]datastore.Completion{
+		{Flag: "--old-format", Description: "deprecated"},
+		{Flag: "--old-output", Description: "deprecated"},
+		{Flag: "--verbose", Description: "be verbose"},
+		{Flag: "--legacy", Description: "deprecated"},
+	})
+	require.Len(t, nodes, 1)
+
+	var groups [][]string
+	for _, group := range nodes[0].flags {
+		groups = append(groups, group.names)
+	}
+	// Consecutive flags are merged, the same description further on the page doesn't make an alias.
+	require.Equal(t, [][]string{{"--old-format", "--old-output"}, {"--verbose"}, {"--legacy"}}, groups)
+}
+
+func TestStaticScripts(t *testing.T) {
+	gen, err := NewStaticScriptGenerator("bash")
+	require.NoError(t, err)
+	require.Equal(t, "foo", gen.StaticFileName("foo"))
+	script := gen.GenerateStaticScript("/usr/bin/foo", staticTestCompletions)
+	require.Contains(t, script, "'--output=' # write output\n")
+	require.Contains(t, script, "\t\t'build')\n")
+	require.Contains(t, script, "complete -o filenames -F _cod_static_foo 'foo'\n")
+
+	gen, err = NewStaticScriptGenerator("zsh")
+	require.NoError(t, err)
+	require.Equal(t, "_foo", gen.StaticFileName("foo"))
+	script = gen.GenerateStaticScript("/usr/bin/foo", staticTestCompletions)
+	require.Contains(t, script, "#compdef foo\n")
+	require.Contains(t, script, "'(-o --output)--output=[write output]:FILE:_files'")
+	require.Contains(t, script, "'--jobs:N: '")
+	require.Contains(t, script, "_cod_foo__build && ret=0")
+
+	gen, err = NewStaticScriptGenerator("fish")
+	require.NoError(t, err)
+	require.Equal(t, "foo.fish", gen.StaticFileName("foo"))
+	script = gen.GenerateStaticScript("/usr/bin/foo", staticTestCompletions)
+	require.Contains(t, script, "complete --command 'foo' --condition '__fish_use_subcommand' --arguments 'build' --description 'build it'\n")
+	require.Contains(t, script, "complete --command 'foo' --long-option 'release' --condition '__fish_seen_subcommand_from \\'build\\'' --description 'optimized build'\n")
+
+	_, err = NewStaticScriptGenerator("nu")
+	require.Error(t, err)
+}
+
+func TestQuoteFishString(t *testing.T) {
+	require.Equal(t, `'foo'`, QuoteFishString("foo"))
+	require.Equal(t, `'it\'s \\n'`, QuoteFishString(`it's \n`))
+}
+
+func TestZshGenerateCompletions(t *testing.T) {
+	gen, err := NewShellScriptGenerator("zsh", "/usr/bin/cod")
+	require.NoError(t, err)
+
+	script := gen.GenerateCompletions("/usr/bin/foo", staticTestCompletions)
+	require.Len(t, script, 3)
+	require.Contains(t, script[0], "_cod_foo() {\n")
+	require.Contains(t, script[0], "__cod_words=(\"${words[@]}\")\n")
+	require.Contains(t, script[0], "'(-o --output)--output=[write output]:FILE:__cod_complete_zsh_values _files'")
+	require.Contains(t, script[1], "_cod_foo__build() {\n")
+	require.Contains(t, script[1], "'--jobs:N:__cod_complete_zsh_values'")
+	require.NotContains(t, script[1], "__cod_words=")
+	require.Equal(t, "__cod_add_completions foo _cod_foo", script[2])
+}
+
+func TestZshNodeFunctionNames(t *testing.T) {
+	names := make(map[string]string)
+	for _, tc := range []struct {
+		name string
+		path []string
+	}{
+		{"foo-bar", nil},
+		{"foo_bar", nil},
+		{"foo.bar", nil},
+		{"a", []string{"b"}},
+		{"a__b", nil},
+		{"a", []string{"b__c"}},
+		{"a", []string{"b", "c"}},
+	} {
+		function := zshNodeFunction(tc.name, &completionNode{path: tc.path})
+		require.Regexp(t, "^[A-Za-z0-9_]+$", function)
+		key := fmt.Sprintf("%q %q", tc.name, tc.path)
+		other, ok := names[function]
+		require.False(t, ok, "%v and %v are both mapped to %v", key, other, function)
+		names[function] = key
+	}
+	require.Equal(t, "_cod_foo_2dbar", zshNodeFunction("foo-bar", &completionNode{}))
+	require.Equal(t, "_cod_a__b", zshNodeFunction("a", &completionNode{path: []string{"b"}}))
+}
+
+func TestFishGenerateCompletions(t *testing.T) {
+	gen, err := NewShellScriptGenerator("fish", "/usr/bin/cod")
+	require.NoError(t, err)
+
+	script := gen.GenerateCompletions("/usr/bin/foo", staticTestCompletions)
+	require.Contains(t, script, "complete --command 'foo' --short-option 'o' --long-option 'output' --require-parameter --force-files --description 'write output'")
+	require.Contains(t, script, "complete --command 'foo' --long-option 'jobs' --condition '__fish_seen_subcommand_from \\'build\\'' --exclusive")
+	for _, line := range script {
+		require.NotContains(t, line, "__cod_complete_fish_values")
+	}
+
+	providerGen, ok := gen.(ProviderScriptGenerator)
+	require.True(t, ok)
+	require.Equal(t,
+		[]string{"complete --command 'foo' --arguments '(__cod_complete_fish_values)'"},
+		providerGen.GenerateProviderCompletions("/usr/bin/foo"),
+	)
+}
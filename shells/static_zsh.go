@@ -0,0 +1,175 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shells
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/dim-an/cod/datastore"
+)
+
+func (z *Zsh) StaticFileName(executableName string) string {
+	return "_" + executableName
+}
+
+// https://zsh.sourceforge.io/Doc/Release/Completion-System.html#Completion-Functions
+func zshValueAction(kind datastore.ValueKind) string {
+	switch kind {
+	case datastore.ValueKindUnknown, datastore.ValueKindFile:
+		return "_files"
+	case datastore.ValueKindDirectory:
+		return "_files -/"
+	case datastore.ValueKindHostname:
+		return "_hosts"
+	case datastore.ValueKindUser:
+		return "_users"
+	case datastore.ValueKindGroup:
+		return "_groups"
+	case datastore.ValueKindSignal:
+		return "_signals"
+	case datastore.ValueKindInterface:
+		return "_net_interfaces"
+	default:
+		return " "
+	}
+}
+
+// zshEscapeSpec escapes characters that have special meaning inside _arguments and _describe specs.
+func zshEscapeSpec(s string, special string) string {
+	var sb strings.Builder
+	for _, c := range []byte(singleLine(s)) {
+		if c == '\\' || strings.IndexByte(special, c) >= 0 {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// zshFlagSpecs returns _arguments specs for flag group, aliases exclude each other.
+func zshFlagSpecs(group *flagGroup, valueAction func(datastore.ValueKind) string) (specs []string) {
+	exclusion := ""
+	if len(group.names) > 1 {
+		exclusion = "(" + strings.Join(group.names, " ") + ")"
+	}
+	for _, name := range group.names {
+		spec := exclusion + name
+		if group.joined[name] {
+			spec += "="
+		}
+		if group.description != "" {
+			spec += "[" + zshEscapeSpec(group.description, "[]") + "]"
+		}
+		if group.takesValue {
+			message := group.metavar
+			if message == "" {
+				message = "value"
+			}
+			spec += ":" + zshEscapeSpec(message, ":") + ":" + valueAction(group.kind)
+		}
+		specs = append(specs, spec)
+	}
+	return
+}
+
+func zshNodeFunction(name string, n *completionNode) string {
+	function := "_cod_" + sanitizeIdentifier(name)
+	for _, p := range n.path {
+		function += "__" + sanitizeIdentifier(p)
+	}
+	return function
+}
+
+// generateZshFunctions generates completion function for each sub-command,
+// the one for executable itself goes first.
+// Functions use `_arguments` with `->state` actions to dispatch sub-commands.
+// When `dynamic` is set values are requested from cod daemon first, native zsh completers are used as fallback.
+func generateZshFunctions(name string, completions []datastore.Completion, dynamic bool) (functions []string) {
+	valueAction := zshValueAction
+	if dynamic {
+		valueAction = func(kind datastore.ValueKind) string {
+			return strings.TrimSpace("__cod_complete_zsh_values " + zshValueAction(kind))
+		}
+	}
+	nodes := buildCompletionTree(completions)
+	for _, n := range nodes {
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%v() {\n", zshNodeFunction(name, n))
+		sb.WriteString("\tlocal curcontext=\"$curcontext\" state state_descr line ret=1\n")
+		sb.WriteString("\ttypeset -A opt_args\n")
+		if dynamic && len(n.path) == 0 {
+			sb.WriteString("\tlocal -a __cod_words\n")
+			sb.WriteString("\t__cod_words=(\"${words[@]}\")\n")
+			sb.WriteString("\tlocal -i __cod_current=$CURRENT\n")
+		}
+		sb.WriteString("\n\t_arguments -C -s -S")
+		for _, group := range n.inheritedFlags(nodes) {
+			for _, spec := range zshFlagSpecs(group, valueAction) {
+				fmt.Fprintf(&sb, " \\\n\t\t%v", quoteSingle(spec))
+			}
+		}
+		fileAction := valueAction(datastore.ValueKindFile)
+		if len(n.subCommands) == 0 {
+			fmt.Fprintf(&sb, " \\\n\t\t%v && ret=0\n", quoteSingle("*: :"+fileAction))
+		} else {
+			sb.WriteString(" \\\n\t\t': :->subcommand' \\\n\t\t'*:: :->argument' && ret=0\n\n")
+			sb.WriteString("\tcase \"$state\" in\n")
+			sb.WriteString("\t\tsubcommand)\n")
+			sb.WriteString("\t\t\tlocal -a subcommands\n")
+			sb.WriteString("\t\t\tsubcommands=(\n")
+			for _, item := range n.subCommands {
+				entry := zshEscapeSpec(item.name, ":")
+				if item.description != "" {
+					entry += ":" + singleLine(item.description)
+				}
+				fmt.Fprintf(&sb, "\t\t\t\t%v\n", quoteSingle(entry))
+			}
+			sb.WriteString("\t\t\t)\n")
+			sb.WriteString("\t\t\t_describe -t subcommands subcommand subcommands && ret=0\n")
+			fmt.Fprintf(&sb, "\t\t\t%v && ret=0\n", fileAction)
+			sb.WriteString("\t\t\t;;\n")
+			sb.WriteString("\t\targument)\n")
+			sb.WriteString("\t\t\tcase \"${words[1]}\" in\n")
+			for _, item := range n.subCommands {
+				child := &completionNode{path: append(append([]string{}, n.path...), item.name)}
+				fmt.Fprintf(&sb, "\t\t\t\t%v)\n\t\t\t\t\t%v && ret=0\n\t\t\t\t\t;;\n", quoteSingle(item.name), zshNodeFunction(name, child))
+			}
+			fmt.Fprintf(&sb, "\t\t\t\t*)\n\t\t\t\t\t%v && ret=0\n\t\t\t\t\t;;\n", fileAction)
+			sb.WriteString("\t\t\tesac\n")
+			sb.WriteString("\t\t\t;;\n")
+			sb.WriteString("\tesac\n")
+		}
+		sb.WriteString("\treturn ret\n}\n")
+		functions = append(functions, sb.String())
+	}
+	return
+}
+
+// GenerateStaticScript generates zsh completion function suitable for autoloading from `fpath`.
+func (z *Zsh) GenerateStaticScript(executablePath string, completions []datastore.Completion) string {
+	name := filepath.Base(executablePath)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "#compdef %v\n", quoteArg(name))
+	fmt.Fprintf(&sb, "# Generated by cod from help of %v, do not edit.\n", singleLine(executablePath))
+	for _, f := range generateZshFunctions(name, completions, false) {
+		sb.WriteString("\n")
+		sb.WriteString(f)
+	}
+	fmt.Fprintf(&sb, "\n%v \"$@\"\n", zshNodeFunction(name, &completionNode{}))
+	return sb.String()
+}
@@ -0,0 +1,208 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shells
+
+import (
+	"strings"
+
+	"github.com/dim-an/cod/shells/asciitable"
+)
+
+// TokenizePowerShell splits PowerShell command line into tokens.
+// Variables, subexpressions and operators are marked as scary since cod cannot evaluate them.
+// https://learn.microsoft.com/en-us/powershell/module/microsoft.powershell.core/about/about_quoting_rules
+func TokenizePowerShell(command string) (toks []Token, err error) {
+	t := powerShellTokenizer{
+		command: command,
+	}
+	t.tokenize()
+	toks = t.result
+	return
+}
+
+type powerShellTokenizer struct {
+	command  string
+	pos      int
+	builder  strings.Builder
+	result   []Token
+	curBegin int
+	nonEmpty bool
+	scary    bool
+	broken   bool
+}
+
+func (t *powerShellTokenizer) readByte() (c byte, ok bool) {
+	if t.pos >= len(t.command) {
+		return
+	}
+	c = t.command[t.pos]
+	t.pos += 1
+	ok = true
+	return
+}
+
+func (t *powerShellTokenizer) tokenize() {
+	for {
+		c, ok := t.readByte()
+		if !ok {
+			break
+		}
+		switch c {
+		case '`':
+			c, ok = t.readByte()
+			if !ok {
+				t.broken = true
+				t.emitWord(t.pos)
+				return
+			}
+			if c != '\n' {
+				t.builder.WriteByte(c)
+			}
+		case ' ', '\t':
+			t.emitWord(t.pos - 1)
+		case '\'':
+			if !t.parseSingleQuote() {
+				return
+			}
+		case '"':
+			if !t.parseDoubleQuote() {
+				return
+			}
+		case '$', '@':
+			// Variable, subexpression or splatting.
+			t.scary = true
+			t.builder.WriteByte(c)
+		case '#':
+			if t.builder.Len() == 0 && !t.nonEmpty {
+				// Rest of the line is a comment.
+				t.emitWord(t.pos - 1)
+				return
+			}
+			t.builder.WriteByte(c)
+		case '\n', '\r', '|', '&', ';', '(', ')', '{', '}', '<', '>', ',':
+			t.emitWord(t.pos - 1)
+			t.curBegin = t.pos - 1
+
+			t.scary = true
+			t.builder.WriteByte(c)
+			t.emitWord(t.pos)
+		default:
+			t.builder.WriteByte(c)
+		}
+	}
+	t.emitWord(t.pos)
+}
+
+// parseSingleQuote parses verbatim string, doubled single quote inside of it means single quote.
+// Returns false if string is not terminated.
+func (t *powerShellTokenizer) parseSingleQuote() bool {
+	t.nonEmpty = true
+	for {
+		c, ok := t.readByte()
+		if !ok {
+			t.broken = true
+			t.emitWord(t.pos)
+			return false
+		}
+		if c == '\'' {
+			if t.pos < len(t.command) && t.command[t.pos] == '\'' {
+				t.pos += 1
+			} else {
+				return true
+			}
+		}
+		t.builder.WriteByte(c)
+	}
+}
+
+// parseDoubleQuote parses expandable string.
+// Returns false if string is not terminated.
+func (t *powerShellTokenizer) parseDoubleQuote() bool {
+	t.nonEmpty = true
+	for {
+		c, ok := t.readByte()
+		if !ok {
+			t.broken = true
+			t.emitWord(t.pos)
+			return false
+		}
+		switch c {
+		case '"':
+			if t.pos < len(t.command) && t.command[t.pos] == '"' {
+				t.pos += 1
+				t.builder.WriteByte('"')
+			} else {
+				return true
+			}
+		case '$':
+			t.scary = true
+			t.builder.WriteByte(c)
+		case '`':
+			c, ok = t.readByte()
+			if !ok {
+				t.broken = true
+				t.emitWord(t.pos)
+				return false
+			}
+			t.builder.WriteByte(decodePowerShellEscape(c))
+		default:
+			t.builder.WriteByte(c)
+		}
+	}
+}
+
+// https://learn.microsoft.com/en-us/powershell/module/microsoft.powershell.core/about/about_special_characters
+func decodePowerShellEscape(c byte) byte {
+	switch c {
+	case '0':
+		return 0
+	case 'a':
+		return asciitable.BEL
+	case 'b':
+		return asciitable.BS
+	case 'e':
+		return asciitable.ESC
+	case 'f':
+		return asciitable.FF
+	case 'n':
+		return asciitable.LF
+	case 'r':
+		return asciitable.CR
+	case 't':
+		return asciitable.TAB
+	case 'v':
+		return asciitable.VT
+	default:
+		return c
+	}
+}
+
+// emitWord finishes current token, `end` is the position right after the token in the original string.
+func (t *powerShellTokenizer) emitWord(end int) {
+	if t.builder.Len() > 0 || t.nonEmpty {
+		t.result = append(t.result, Token{
+			Decoded:   t.builder.String(),
+			OrigBegin: t.curBegin,
+			OrigEnd:   end,
+			IsBroken:  t.broken,
+			IsScary:   t.scary,
+		})
+		t.builder.Reset()
+	}
+	t.curBegin = t.pos
+	t.nonEmpty = false
+	t.scary = false
+	t.broken = false
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shells
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenizePowerShellStrings(t *testing.T) {
+	check := func(cmd string, expectedArgs []string) {
+		tokens, err := TokenizePowerShell(cmd)
+		var args []string
+		for _, tok := range tokens {
+			args = append(args, tok.Decoded)
+			require.False(t, tok.IsBroken, cmd)
+			require.False(t, tok.IsScary, cmd)
+		}
+
+		require.Nil(t, err)
+		require.Equal(t, expectedArgs, args)
+	}
+
+	// word splitting
+	check(`echo foo bar`, []string{"echo", "foo", "bar"})
+	check("echo foo\t bar", []string{"echo", "foo", "bar"})
+
+	// backtick escaping
+	check("echo foo` bar", []string{"echo", "foo bar"})
+	check("echo foo`\nbar", []string{"echo", "foobar"})
+	check(`echo C:\foo\bar`, []string{"echo", `C:\foo\bar`})
+
+	// single quotes
+	check(`echo 'foo bar'`, []string{"echo", "foo bar"})
+	check(`echo 'it''s'`, []string{"echo", "it's"})
+	check("echo 'foo`nbar'", []string{"echo", "foo`nbar"})
+	check(`echo '' ''`, []string{"echo", "", ""})
+
+	// double quotes
+	check(`echo "foo bar"`, []string{"echo", "foo bar"})
+	check(`echo "say ""hi"""`, []string{"echo", `say "hi"`})
+	check("echo \"foo`tbar`\"\"", []string{"echo", "foo\tbar\""})
+	check(`echo ""`, []string{"echo", ""})
+
+	// comments
+	check(`echo foo # bar`, []string{"echo", "foo"})
+	check(`echo foo#bar`, []string{"echo", "foo#bar"})
+}
+
+func TestTokenizePowerShellScary(t *testing.T) {
+	scary := func(cmd string) (res []bool) {
+		tokens, err := TokenizePowerShell(cmd)
+		require.NoError(t, err)
+		for _, tok := range tokens {
+			res = append(res, tok.IsScary)
+		}
+		return
+	}
+
+	require.Equal(t, []bool{false, true}, scary(`echo $HOME`))
+	require.Equal(t, []bool{false, true}, scary(`echo "$HOME"`))
+	require.Equal(t, []bool{false, false}, scary(`echo '$HOME'`))
+	require.Equal(t, []bool{false, true}, scary(`foo @args`))
+	require.Equal(t, []bool{false, false, true, false}, scary(`foo --help | less`))
+	require.Equal(t, []bool{false, false, true, false}, scary(`foo a; bar`))
+}
+
+func TestTokenizePowerShellPositions(t *testing.T) {
+	tokens, err := TokenizePowerShell(`foo  'b c'|x`)
+	require.NoError(t, err)
+	var positions [][2]int
+	for _, tok := range tokens {
+		positions = append(positions, [2]int{tok.OrigBegin, tok.OrigEnd})
+	}
+	require.Equal(t, [][2]int{{0, 3}, {5, 10}, {10, 11}, {11, 12}}, positions)
+}
+
+func TestParsePowerShellCommand(t *testing.T) {
+	env, args, err := ParseShellCommand("pwsh", `FOO=bar foo --help`)
+	require.NoError(t, err)
+	require.Nil(t, env)
+	require.Equal(t, []string{"FOO=bar", "foo", "--help"}, args)
+
+	_, _, err = ParseShellCommand("pwsh", `foo $(bar) --help`)
+	require.ErrorIs(t, err, ErrCommandNotSimple)
+
+	env, args, err = ParseShellCommand("bash", `FOO=bar foo --help`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"FOO=bar"}, env)
+	require.Equal(t, []string{"foo", "--help"}, args)
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shells
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/dim-an/cod/datastore"
+)
+
+//
+// Xonsh
+//
+
+// Xonsh completers are global, so cod completer checks
+// whether the command is in the set of learned ones.
+type Xonsh struct {
+	codCommandPath string
+}
+
+// quotePythonString quotes string as python string literal.
+// Escapes produced by strconv.Quote are subset of the ones supported by python.
+func quotePythonString(s string) string {
+	return strconv.Quote(s)
+}
+
+func (x *Xonsh) GetPreamble() (script []string) {
+	codBinaryVar := fmt.Sprintf("__cod_binary = %v", quotePythonString(x.codCommandPath))
+	scriptText := `
+import os as __cod_os
+import subprocess as __cod_subprocess
+from xonsh.completers.tools import contextual_command_completer as __cod_contextual_command_completer
+from xonsh.completers.tools import RichCompletion as __cod_RichCompletion
+
+__cod_commands = set()
+
+
+def __cod_run(*args, **kwargs):
+    return __cod_subprocess.run(
+        [__cod_binary, *args],
+        env=__xonsh__.env.detype(),
+        cwd=__cod_os.getcwd(),
+        **kwargs,
+    )
+
+
+@__cod_contextual_command_completer
+def __cod_complete(context):
+    if context.arg_index == 0 or __cod_os.path.basename(context.args[0].value) not in __cod_commands:
+        return None
+    words = [arg.value for arg in context.args[:context.arg_index]] + [context.prefix]
+    result = __cod_run(
+        "api", "complete-words", "--format", "v1", "--", str(__cod_os.getpid()), str(context.arg_index), *words,
+        stdout=__cod_subprocess.PIPE,
+        stderr=__cod_subprocess.DEVNULL,
+        text=True,
+    )
+    lines = result.stdout.splitlines()
+    if result.returncode != 0 or not lines:
+        return None
+    # First line is a kind of the value being completed,
+    # other lines are: value, display, kind, group, nospace, description separated by tab.
+    value_kind = lines[0]
+    items = set()
+    for line in lines[1:]:
+        value, display, kind, group, nospace, description = line.split("\t")
+        items.add(__cod_RichCompletion(value, display=display, description=description, append_space=nospace != "1"))
+    if not items and value_kind in ("", "file", "directory"):
+        # Let path completer do its job.
+        return None
+    return items
+
+
+@events.on_postcommand
+def __cod_postcommand(cmd, rtn, out, ts, **kwargs):
+    if rtn == 0 and cmd.strip():
+        __cod_run("api", "postexec", "--", str(__cod_os.getpid()), cmd.strip())
+    update = __cod_run("api", "poll-updates", "--", str(__cod_os.getpid()), stdout=__cod_subprocess.PIPE, text=True)
+    if update.stdout:
+        execx(update.stdout, "exec", __xonsh__.ctx, filename="cod")
+
+
+completer add cod __cod_complete start
+
+__cod_run("api", "attach", "--", str(__cod_os.getpid()), "xonsh")
+`
+
+	script = []string{
+		codBinaryVar,
+		scriptText,
+	}
+	return
+}
+
+func (x *Xonsh) GenerateCompletions(executablePath string, _ []datastore.Completion) []string {
+	return []string{
+		fmt.Sprintf("__cod_commands.add(%v)", quotePythonString(filepath.Base(executablePath))),
+	}
+}
+
+func (x *Xonsh) ResetCommand(executablePath string) []string {
+	return []string{
+		fmt.Sprintf("__cod_commands.discard(%v)", quotePythonString(filepath.Base(executablePath))),
+	}
+}
+
+func (x *Xonsh) GetDeinitScript() []string {
+	return []string{`
+completer remove cod
+events.on_postcommand.remove(__cod_postcommand)
+del __cod_commands, __cod_run, __cod_complete, __cod_postcommand, __cod_binary
+del __cod_os, __cod_subprocess, __cod_contextual_command_completer, __cod_RichCompletion
+`}
+}
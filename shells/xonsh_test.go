@@ -0,0 +1,73 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shells
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotePythonString(t *testing.T) {
+	for _, tc := range []struct {
+		value  string
+		quoted string
+	}{
+		{"/usr/bin/cod", `"/usr/bin/cod"`},
+		{"with space", `"with space"`},
+		{"it's", `"it's"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{"two\nlines", `"two\nlines"`},
+		{"tab\there", `"tab\there"`},
+		{`back\slash`, `"back\\slash"`},
+		{"a'#b", `"a'#b"`},
+		{"$HOME", `"$HOME"`},
+	} {
+		require.Equal(t, tc.quoted, quotePythonString(tc.value))
+	}
+}
+
+func TestQuotePythonStringInShell(t *testing.T) {
+	python := lookupShell(t, "python3")
+	for _, value := range hardToQuoteValues {
+		require.Equal(t, value, runShell(t, python, "-c", "import sys; sys.stdout.write("+quotePythonString(value)+")"))
+	}
+}
+
+func TestXonshScript(t *testing.T) {
+	gen, err := NewShellScriptGenerator("xonsh", "/usr/bin/cod")
+	require.NoError(t, err)
+
+	preamble := strings.Join(gen.GetPreamble(), "\n")
+	require.True(t, strings.HasPrefix(preamble, `__cod_binary = "/usr/bin/cod"`))
+	require.Contains(t, preamble, "completer add cod __cod_complete start")
+
+	require.Equal(t, []string{`__cod_commands.add("foo")`}, gen.GenerateCompletions("/usr/bin/foo", nil))
+	require.Equal(t, []string{`__cod_commands.discard("foo")`}, gen.ResetCommand("/usr/bin/foo"))
+}
+
+func TestXonshScriptSyntax(t *testing.T) {
+	python := lookupShell(t, "python3")
+	// Script is python except for `completer` commands that are xonsh subprocess mode.
+	var script []string
+	for _, line := range strings.Split(strings.Join(generateFullScript(t, "xonsh", `it's "a b"`), "\n"), "\n") {
+		if strings.HasPrefix(line, "completer ") {
+			line = "# " + line
+		}
+		script = append(script, line)
+	}
+	runShell(t, python, "-m", "py_compile", writeScript(t, script))
+}
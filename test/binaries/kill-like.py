@@ -0,0 +1,13 @@
+#!/usr/bin/env python3
+
+"""
+Usage: kill-like [OPTION]... PID...
+
+  -s, --signal SIGNAL   signal to send
+  -o, --output=FILE     write report to FILE
+  -v, --verbose         be verbose
+"""
+import sys
+
+if __name__ == "__main__":
+    print(__doc__, file=sys.stderr)
@@ -0,0 +1,124 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dim-an/cod/datastore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	wb.RunCodCmd("learn", "--", "binaries/kill-like.py", "--help")
+	wb.RunCodCmd("learn", "--", "binaries/argparse-subcommand.py", "--help")
+
+	out := wb.RunCodCmd("export")
+	bundle, err := datastore.ReadBundle(strings.NewReader(out))
+	require.NoError(t, err)
+	require.Len(t, bundle.HelpPages, 2)
+	for _, page := range bundle.HelpPages {
+		require.Empty(t, page.Command.Env)
+		require.NotEmpty(t, page.Completions)
+		require.False(t, page.LearnedAt.IsZero())
+	}
+
+	var killLikeId string
+	for id, command := range wb.ParseCodListMap(wb.RunCodCmd("list")) {
+		if command == "binaries/kill-like.py --help" {
+			killLikeId = strconv.Itoa(id)
+		}
+	}
+	out = wb.RunCodCmd("export", killLikeId)
+	selected, err := datastore.ReadBundle(strings.NewReader(out))
+	require.NoError(t, err)
+	require.Len(t, selected.HelpPages, 1)
+	require.True(t, strings.HasSuffix(selected.HelpPages[0].ExecutablePath, "/binaries/kill-like.py"))
+
+	bundleFile := wb.InTmpDataPath("bundle.json")
+	writeBundle := func(bundle *datastore.Bundle) {
+		var buf bytes.Buffer
+		require.NoError(t, datastore.WriteBundle(&buf, bundle))
+		require.NoError(t, os.WriteFile(bundleFile, buf.Bytes(), 0644))
+	}
+	writeBundle(bundle)
+
+	wb.RunCodCmd("remove", "/**")
+	require.Empty(t, wb.RunCodCmd("list"))
+
+	shellPid := strconv.Itoa(wb.LaunchFakeShell())
+	wb.RunCodCmd("init", shellPid, "bash")
+
+	out = wb.RunCodCmd("import", bundleFile)
+	require.Equal(t, "2 new, 0 updated, 0 skipped\n", out)
+	require.Equal(t,
+		[]string{"binaries/argparse-subcommand.py --help", "binaries/kill-like.py --help"},
+		wb.ParseCodListCommands(wb.RunCodCmd("list")),
+	)
+	out = wb.RunCodCmd("api", "complete-words", "--", shellPid, "1", "binaries/kill-like.py", "--")
+	require.Contains(t, out, "--signal")
+
+	// Attached shells get completions of imported commands.
+	out = wb.RunCodCmd("api", "poll-updates", shellPid)
+	require.Contains(t, out, "__cod_add_completions kill-like.py\n")
+	require.Contains(t, out, "__cod_add_completions argparse-subcommand.py\n")
+
+	out = wb.RunCodCmd("import", bundleFile)
+	require.Equal(t, "0 new, 0 updated, 2 skipped\n", out)
+
+	out = wb.RunCodCmd("import", "--strategy", "overwrite", bundleFile)
+	require.Equal(t, "0 new, 2 updated, 0 skipped\n", out)
+
+	bundle.HelpPages[0].LearnedAt = time.Now().Add(time.Hour)
+	writeBundle(bundle)
+	out = wb.RunCodCmd("import", "--strategy", "newer-wins", bundleFile)
+	require.Equal(t, "0 new, 1 updated, 1 skipped\n", out)
+
+	// Imported commands get environment of the importing client, so they can be updated.
+	wb.RunCodCmd("update", "/**")
+	require.Len(t, wb.ParseCodListCommands(wb.RunCodCmd("list")), 2)
+
+	// Trust lets cod run commands without asking, so it is imported only if asked explicitly.
+	exportedPolicies := func() (policies []datastore.Policy) {
+		exported, err := datastore.ReadBundle(strings.NewReader(wb.RunCodCmd("export")))
+		require.NoError(t, err)
+		for _, page := range exported.HelpPages {
+			policies = append(policies, page.Policy)
+		}
+		return
+	}
+	for i := range bundle.HelpPages {
+		bundle.HelpPages[i].Policy = datastore.PolicyTrust
+	}
+	writeBundle(bundle)
+	wb.RunCodCmd("import", "--strategy", "overwrite", bundleFile)
+	require.Equal(t, []datastore.Policy{datastore.PolicyUnknown, datastore.PolicyUnknown}, exportedPolicies())
+	wb.RunCodCmd("import", "--strategy", "overwrite", "--keep-trust", bundleFile)
+	require.Equal(t, []datastore.Policy{datastore.PolicyTrust, datastore.PolicyTrust}, exportedPolicies())
+
+	bundle.Version = datastore.BundleVersion + 1
+	writeBundle(bundle)
+	out, err = wb.UncheckedRunCodCmd("import", bundleFile)
+	require.Error(t, err)
+	require.Contains(t, out, "please upgrade cod")
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBasenameCollisions(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	venvBin := wb.InTmpDataPath("venv/bin")
+	usrBin := wb.InTmpDataPath("usr/bin")
+	for _, dir := range []string{venvBin, usrBin} {
+		err := os.MkdirAll(dir, 0755)
+		require.NoError(t, err)
+		wb.CopyFile("binaries/kill-like.py", filepath.Join(dir, "tool"))
+	}
+
+	wb.WriteUserConfiguration(fmt.Sprintf(`
+[[prefer]]
+name = "tool"
+executable = "%v/tool"
+`, usrBin))
+
+	pathVar := func(dirs ...string) map[string]string {
+		dirs = append(dirs, os.Getenv("PATH"))
+		return map[string]string{"PATH": strings.Join(dirs, string(os.PathListSeparator))}
+	}
+	venvFirst := pathVar(venvBin, usrBin)
+
+	shellPid := strconv.Itoa(wb.LaunchFakeShell())
+	wb.RunCodCmd("init", shellPid, "bash")
+	wb.RunCodCmd("learn", "--", filepath.Join(venvBin, "tool"), "--help")
+
+	out := wb.RunCodCmdModifiedEnv(venvFirst, "list")
+	require.Equal(t, fmt.Sprintf("1\t%v/tool --help\n", venvBin), out)
+
+	// Active executable is not learned, completions of the same-named one are used.
+	// Warning is shown once by the shell on its next poll, output of completion is hidden by shell glue.
+	warning := fmt.Sprintf("warn: %v/tool is not learned, using completions of %v/tool\n", usrBin, venvBin)
+	for i := 0; i < 2; i += 1 {
+		out = wb.RunCodCmdModifiedEnv(pathVar(usrBin), "api", "complete-words", "--", shellPid, "1", "tool", "--sig")
+		require.Equal(t, "--signal\n", out)
+	}
+	out = wb.RunCodCmd("api", "poll-updates", shellPid)
+	require.Equal(t, 1, strings.Count(out, warning), out)
+	out = wb.RunCodCmdModifiedEnv(pathVar(usrBin), "api", "complete-words", "--", shellPid, "1", "tool", "--sig")
+	require.Equal(t, "--signal\n", out)
+	out = wb.RunCodCmd("api", "poll-updates", shellPid)
+	require.NotContains(t, out, warning)
+
+	// Shell that is not attached gets warning with completions.
+	out = wb.RunCodCmdModifiedEnv(pathVar(usrBin), "api", "complete-words", "--", "1", "1", "tool", "--sig")
+	require.Contains(t, out, warning)
+
+	out = wb.RunCodCmdModifiedEnv(pathVar(usrBin), "list")
+	require.Equal(t, fmt.Sprintf("1\t%v/tool --help\t(fallback)\n", venvBin), out)
+
+	// Preferred executable wins even if it is not first in PATH.
+	wb.RunCodCmd("learn", "--", filepath.Join(usrBin, "tool"), "--help")
+	out = wb.RunCodCmdModifiedEnv(venvFirst, "list")
+	lines := wb.SplitLines(out)
+	require.ElementsMatch(t, []string{
+		fmt.Sprintf("1\t%v/tool --help\t(shadowed)", venvBin),
+		fmt.Sprintf("2\t%v/tool --help\t(preferred)", usrBin),
+	}, lines)
+
+	out = wb.RunCodCmdModifiedEnv(venvFirst, "api", "complete-words", "--", shellPid, "1", "tool", "--sig")
+	require.Equal(t, "--signal\n", out)
+}
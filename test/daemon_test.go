@@ -0,0 +1,136 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemonStatus(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	out, err := wb.UncheckedRunCodCmd("daemon", "status")
+	require.Error(t, err)
+	require.Equal(t, "cod: daemon is not running\n", out)
+
+	shellPid := strconv.Itoa(wb.LaunchFakeShell())
+	wb.RunCodCmd("init", shellPid, "bash")
+	wb.RunCodCmd("learn", "--", "binaries/kill-like.py", "--help")
+	_, err = wb.UncheckedRunCodCmd("deinit", shellPid, "zsh")
+	require.Error(t, err)
+
+	out = wb.RunCodCmd("daemon", "status")
+	require.Contains(t, out, fmt.Sprintf("pid:           %v\n", wb.GetDaemonPid()))
+	require.Contains(t, out, "help pages:    1\n")
+	require.Contains(t, out, "pending jobs:  0\n")
+	require.Contains(t, out, fmt.Sprintf("shells:        1\n  %v\tbash\n", shellPid))
+	require.Contains(t, out, "last errors:   1\n")
+	require.Contains(t, out, "DeinitScriptRequest\tshell "+shellPid+" was initialized as bash\n")
+}
+
+func TestDaemonStopRestart(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	shellPid := strconv.Itoa(wb.LaunchFakeShell())
+	wb.RunCodCmd("init", shellPid, "bash")
+	wb.RunCodCmd("learn", "--", "binaries/kill-like.py", "--help")
+	oldDaemonPid := wb.GetDaemonPid()
+
+	out := wb.RunCodCmd("daemon", "restart")
+	require.Equal(t, "cod: daemon is restarted\n", out)
+	require.NotEqual(t, oldDaemonPid, wb.GetDaemonPid())
+
+	out = wb.RunCodCmd("api", "list-clients")
+	require.Equal(t, shellPid+"\tbash\n", out)
+	out = wb.RunCodCmd("list")
+	require.Contains(t, out, "kill-like.py --help")
+
+	out = wb.RunCodCmd("daemon", "stop")
+	require.Equal(t, "cod: daemon is stopped\n", out)
+
+	out = wb.RunCodCmd("daemon", "stop")
+	require.Equal(t, "cod: daemon is not running\n", out)
+}
+
+func TestDaemonWithoutShells(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+	wb.WriteUserConfiguration("idle-timeout = 500\n")
+
+	// Daemon is started by commands that need it.
+	wb.RunCodCmd("learn", "--", "binaries/kill-like.py", "--help")
+	out := wb.RunCodCmd("list")
+	require.Contains(t, out, "kill-like.py --help")
+	out = wb.RunCodCmd("api", "list-clients")
+	require.Empty(t, out)
+
+	require.True(t, wb.WaitDaemonExit(), "daemon didn't exit after idle timeout")
+}
+
+func TestSocketActivation(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	// Test plays the role of service manager: it listens the socket and passes it to the daemon.
+	runDir := wb.getRunDir()
+	require.NoError(t, os.MkdirAll(runDir, 0700))
+	socketFile := filepath.Join(runDir, "cod.sock")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketFile, Net: "unix"})
+	require.NoError(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+	listenerFile, err := listener.File()
+	require.NoError(t, err)
+	defer func() {
+		_ = listenerFile.Close()
+	}()
+
+	startDaemon := func() *exec.Cmd {
+		codCmd := wb.NewCodCmd()
+		// LISTEN_PID must be pid of the daemon, so it is set by the shell that is replaced by the daemon.
+		cmd := exec.Command("sh", "-c", `LISTEN_PID=$$ LISTEN_FDS=1 exec "$0" daemon --foreground`, wb.codBinary)
+		cmd.Env = codCmd.Env
+		cmd.ExtraFiles = []*os.File{listenerFile}
+		require.NoError(t, cmd.Start())
+		return cmd
+	}
+
+	for i := 0; i < 2; i += 1 {
+		cmd := startDaemon()
+		wb.RunCodCmd("learn", "--", "binaries/kill-like.py", "--help")
+		out := wb.RunCodCmd("list")
+		require.Contains(t, out, "kill-like.py --help")
+		require.Equal(t, cmd.Process.Pid, wb.GetDaemonPid())
+
+		out = wb.RunCodCmd("daemon", "stop")
+		require.Equal(t, "cod: daemon is stopped\n", out)
+		require.NoError(t, cmd.Wait())
+
+		// Socket belongs to the service manager, it is kept by the daemon.
+		_, err = os.Stat(socketFile)
+		require.NoError(t, err)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeinit(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	shellPid := strconv.Itoa(wb.LaunchFakeShell())
+	otherShellPid := strconv.Itoa(wb.LaunchFakeShell())
+	wb.RunCodCmd("init", shellPid, "bash")
+	wb.RunCodCmd("init", otherShellPid, "zsh")
+	wb.RunCodCmd("learn", "--", "binaries/kill-like.py", "--help")
+
+	_, err := wb.UncheckedRunCodCmd("deinit", shellPid, "zsh")
+	require.Error(t, err)
+
+	out := wb.RunCodCmd("deinit", shellPid, "bash")
+	lines := wb.SplitLines(out)
+	require.Equal(t, "__cod_clear_completions kill-like.py", lines[0])
+	require.Contains(t, out, "unset -f __cod_ref_trace")
+
+	out = wb.RunCodCmd("api", "list-clients")
+	require.Equal(t, fmt.Sprintf("%v\tzsh\n", otherShellPid), out)
+
+	_, err = wb.UncheckedRunCodCmd("deinit", shellPid, "bash")
+	require.Error(t, err)
+}
+
+func TestUninstall(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	shellPid := strconv.Itoa(wb.LaunchFakeShell())
+	wb.RunCodCmd("init", shellPid, "bash")
+	wb.RunCodCmd("learn", "--", "binaries/kill-like.py", "--help")
+	wb.WriteUserConfiguration("")
+
+	out := wb.RunCodCmd("uninstall", "--purge", "--yes")
+	require.Contains(t, out, fmt.Sprintf("bash shell (pid %v) is still attached", shellPid))
+	require.Contains(t, out, "cod: daemon is stopped\n")
+
+	for _, dir := range []string{wb.getDataHome(), wb.getConfigHome()} {
+		_, err := os.Stat(filepath.Join(dir, "cod"))
+		require.True(t, os.IsNotExist(err), "%v is not removed", dir)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportCompletions(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	shellPid := strconv.Itoa(wb.LaunchFakeShell())
+	wb.RunCodCmd("init", shellPid, "bash")
+	wb.RunCodCmd("learn", "--", "binaries/argparse-subcommand.py", "--help")
+	wb.RunCodCmd("learn", "--", "binaries/kill-like.py", "--help")
+
+	outDir := wb.InTmpDataPath("zsh")
+	out := wb.RunCodCmd("export-completions", "--shell", "zsh", "--out", outDir)
+	require.Equal(t, []string{
+		filepath.Join(outDir, "_argparse-subcommand.py"),
+		filepath.Join(outDir, "_kill-like.py"),
+	}, wb.SplitLines(out))
+
+	killLike, err := filepath.Abs("binaries/kill-like.py")
+	require.NoError(t, err)
+	outDir = wb.InTmpDataPath("fish")
+	out = wb.RunCodCmd("export-completions", "--shell", "fish", "--out", outDir, killLike)
+	require.Equal(t, []string{filepath.Join(outDir, "kill-like.py.fish")}, wb.SplitLines(out))
+	script, err := os.ReadFile(filepath.Join(outDir, "kill-like.py.fish"))
+	require.NoError(t, err)
+	require.Contains(t, string(script), "complete --command 'kill-like.py' --short-option 'v' --long-option 'verbose' --description 'be verbose'\n")
+
+	outDir = wb.InTmpDataPath("bash")
+	wb.RunCodCmd("export-completions", "--shell", "bash", "--out", outDir)
+	script, err = os.ReadFile(filepath.Join(outDir, "argparse-subcommand.py"))
+	require.NoError(t, err)
+	require.Contains(t, string(script), "'sub-command1' # some help\n")
+	require.NotContains(t, string(script), "__COD_BINARY")
+}
+
+func TestExportCompletionsPreferredExecutable(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	usrBin := wb.InTmpDataPath("usr/bin")
+	venvBin := wb.InTmpDataPath("venv/bin")
+	for _, dir := range []string{usrBin, venvBin} {
+		err := os.MkdirAll(dir, 0755)
+		require.NoError(t, err)
+		wb.CopyFile("binaries/kill-like.py", filepath.Join(dir, "tool"))
+		wb.RunCodCmd("learn", "--", filepath.Join(dir, "tool"), "--help")
+	}
+
+	// Without preference the first executable is exported.
+	outDir := wb.InTmpDataPath("fish")
+	out := wb.RunCodCmd("export-completions", "--shell", "fish", "--out", outDir)
+	require.Contains(t, out, fmt.Sprintf("skipping %v/tool: tool.fish is already exported from %v/tool", venvBin, usrBin))
+
+	// Executable preferred in configuration is exported like it is used for completion by the daemon.
+	wb.WriteUserConfiguration(fmt.Sprintf(`
+[[prefer]]
+name = "tool"
+executable = "%v/tool"
+`, venvBin))
+	skipped := fmt.Sprintf("skipping %v/tool: tool.fish is already exported from %v/tool", usrBin, venvBin)
+	exported := wb.WaitFor(func() bool {
+		out = wb.RunCodCmd("export-completions", "--shell", "fish", "--out", outDir)
+		return strings.Contains(out, skipped)
+	})
+	require.True(t, exported, "output: %q", out)
+	require.Contains(t, out, filepath.Join(outDir, "tool.fish"))
+}
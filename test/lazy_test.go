@@ -0,0 +1,65 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLazyInit(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	shellPid := strconv.Itoa(wb.LaunchFakeShell())
+	wb.RunCodCmd("init", shellPid, "bash")
+	wb.RunCodCmd("learn", "--", "binaries/kill-like.py", "--help")
+
+	lazyShellPid := strconv.Itoa(wb.LaunchFakeShell())
+	out := wb.RunCodCmd("init", "--lazy", lazyShellPid, "bash")
+	require.NotContains(t, out, "__cod_add_completions kill-like.py")
+	require.Contains(t, out, "complete -D -o bashdefault -o default -F __cod_complete_default")
+
+	out = wb.RunCodCmd("api", "lazy-completions", "--", lazyShellPid, "kill-like.py")
+	require.Equal(t, []string{"__cod_add_completions kill-like.py"}, wb.SplitLines(out))
+
+	out = wb.RunCodCmd("api", "lazy-completions", "--", lazyShellPid, "/some/dir/kill-like.py")
+	require.Equal(t, []string{"__cod_add_completions kill-like.py"}, wb.SplitLines(out))
+
+	out = wb.RunCodCmd("api", "lazy-completions", "--", lazyShellPid, "unknown-command")
+	require.Empty(t, out)
+
+	// Fish loads stubs of learned commands from the stub directory.
+	fishShellPid := strconv.Itoa(wb.LaunchFakeShell())
+	out = wb.RunCodCmd("init", "--lazy", fishShellPid, "fish")
+	require.NotContains(t, out, "complete --command 'kill-like.py'")
+	stubDir := filepath.Join(wb.getRunDir(), "completion-stubs", "fish")
+	require.Contains(t, out, "set -g __cod_stub_dir '"+stubDir+"'")
+	stub, err := os.ReadFile(filepath.Join(stubDir, "kill-like.py.fish"))
+	require.NoError(t, err)
+	require.Contains(t, string(stub), "api lazy-completions -- %self 'kill-like.py'")
+
+	out = wb.RunCodCmd("api", "lazy-completions", "--", fishShellPid, "kill-like.py")
+	require.Contains(t, out, "complete --command 'kill-like.py'")
+
+	// Shells without default completer register everything at init.
+	nuShellPid := strconv.Itoa(wb.LaunchFakeShell())
+	out = wb.RunCodCmd("init", "--lazy", nuShellPid, "nu")
+	require.Contains(t, out, "lazy registration of completions is not supported by nu")
+}
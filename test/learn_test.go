@@ -293,3 +293,42 @@ func TestLearnDefaultSubCommand(t *testing.T) {
 		"--sub-command2-flag",
 	}, lines)
 }
+
+func TestCompleteFlagValues(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	shellPid := strconv.Itoa(wb.LaunchFakeShell())
+	wb.RunCodCmd("init", shellPid, "bash")
+
+	wb.RunCodCmd("learn", "--", "binaries/kill-like.py", "--help")
+
+	getCompletions := func(args ...string) []string {
+		runCodCmdArgs := []string{
+			"api", "complete-words", "--format", "v1", "--",
+			shellPid,
+			strconv.Itoa(len(args) - 1),
+		}
+		runCodCmdArgs = append(runCodCmdArgs, args...)
+		out := wb.RunCodCmd(runCodCmdArgs...)
+		return wb.ParseCompleteWordsV1(out)
+	}
+
+	lines := getCompletions("binaries/kill-like.py", "--signal", "KI")
+	require.Equal(t, []string{"signal", "KILL"}, lines)
+
+	lines = getCompletions("binaries/kill-like.py", "--signal=KI")
+	require.Equal(t, []string{"signal", "--signal=KILL"}, lines)
+
+	lines = getCompletions("binaries/kill-like.py", "--signal", "=", "KI")
+	require.Equal(t, []string{"signal", "KILL"}, lines)
+
+	lines = getCompletions("binaries/kill-like.py", "-o", "")
+	require.Equal(t, []string{"file"}, lines)
+
+	lines = getCompletions("binaries/kill-like.py", "-v", "--verb")
+	require.Equal(t, []string{"", "--verbose"}, lines)
+
+	out := wb.RunCodCmd("api", "complete-words", "--format", "v1", "--", shellPid, "1", "binaries/kill-like.py", "--out")
+	require.Equal(t, "\n--output=\t--output=FILE\tflag\tflag\t1\twrite report to FILE\n", out)
+}
@@ -30,6 +30,9 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 type Workbench struct {
@@ -118,6 +121,15 @@ func (wb *Workbench) CopyFile(src, dst string) {
 	require.Nil(wb.t, err)
 }
 
+func (wb *Workbench) WriteUserConfiguration(text string) {
+	configDir := filepath.Join(wb.getConfigHome(), "cod")
+	err := os.MkdirAll(configDir, 0755)
+	require.Nil(wb.t, err)
+
+	err = os.WriteFile(filepath.Join(configDir, "config.toml"), []byte(text), 0644)
+	require.Nil(wb.t, err)
+}
+
 func (wb *Workbench) InTmpDataPath(path string) string {
 	return filepath.Join(wb.currentTestTmpDir, path)
 }
@@ -177,6 +189,16 @@ func (wb *Workbench) RunCodCmdModifiedEnv(env map[string]string, args ...string)
 	return output
 }
 
+// RunCodCmdWithRuntimeDir runs cod with XDG_RUNTIME_DIR other than the one of the test,
+// cod keeps runtime files in the data directory if runtimeDir is empty.
+func (wb *Workbench) RunCodCmdWithRuntimeDir(runtimeDir string, args ...string) string {
+	cmd := wb.NewCodCmd(args...)
+	cmd.Env = append(cmd.Env, fmt.Sprintf("XDG_RUNTIME_DIR=%v", runtimeDir))
+	output, err := cmd.CombinedOutput()
+	require.NoError(wb.t, err, "output: %q", output)
+	return string(output)
+}
+
 func (wb *Workbench) NewCodCmd(args ...string) exec.Cmd {
 	cmd := exec.Cmd{}
 	cmd.Path = wb.codBinary
@@ -184,6 +206,7 @@ func (wb *Workbench) NewCodCmd(args ...string) exec.Cmd {
 	cmd.Env = os.Environ()
 	cmd.Env = append(cmd.Env, fmt.Sprintf("XDG_CONFIG_HOME=%v", wb.getConfigHome()))
 	cmd.Env = append(cmd.Env, fmt.Sprintf("XDG_DATA_HOME=%v", wb.getDataHome()))
+	cmd.Env = append(cmd.Env, fmt.Sprintf("XDG_RUNTIME_DIR=%v", wb.getRuntimeDir()))
 
 	return cmd
 }
@@ -196,6 +219,15 @@ func (wb *Workbench) getDataHome() string {
 	return filepath.Join(wb.currentTestWorkDir, "data")
 }
 
+func (wb *Workbench) getRuntimeDir() string {
+	return filepath.Join(wb.currentTestWorkDir, "run")
+}
+
+// getRunDir returns directory with socket and other runtime files of the daemon.
+func (wb *Workbench) getRunDir() string {
+	return filepath.Join(wb.getRuntimeDir(), "cod")
+}
+
 func (wb *Workbench) Close() {
 	if wb.t.Failed() {
 		log.Printf("Printing logs of failed test")
@@ -242,7 +274,7 @@ func (wb *Workbench) Close() {
 }
 
 func (wb *Workbench) GetDaemonPid() int {
-	lockFile := filepath.Join(wb.getDataHome(), "cod", "var", "cod.lock")
+	lockFile := filepath.Join(wb.getRunDir(), "cod.lock")
 	f, err := os.Open(lockFile)
 	require.NoError(wb.t, err)
 	text, err := ioutil.ReadAll(f)
@@ -252,6 +284,34 @@ func (wb *Workbench) GetDaemonPid() int {
 	return pid
 }
 
+// WaitDaemonExit waits until lock file is released by the daemon.
+func (wb *Workbench) WaitDaemonExit() (exited bool) {
+	lockFile := filepath.Join(wb.getRunDir(), "cod.lock")
+	return wb.WaitFor(func() bool {
+		fd, err := unix.Open(lockFile, os.O_RDONLY, 0)
+		require.NoError(wb.t, err)
+		err = unix.Flock(fd, unix.LOCK_EX|unix.LOCK_NB)
+		_ = unix.Close(fd)
+		return err == nil
+	})
+}
+
+// waitTimeout is generous, since daemon built with race detector is several times slower
+// and sleeps for a second before it exits.
+const waitTimeout = time.Second * 10
+
+// WaitFor polls condition until it holds or waitTimeout expires.
+func (wb *Workbench) WaitFor(condition func() bool) bool {
+	deadline := time.Now().Add(waitTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	return true
+}
+
 func (wb *Workbench) SplitLines(s string) []string {
 	var res []string
 	scanner := bufio.NewScanner(strings.NewReader(s))
@@ -262,6 +322,19 @@ func (wb *Workbench) SplitLines(s string) []string {
 	return res
 }
 
+// ParseCompleteWordsV1 parses output of `cod api complete-words --format v1`.
+// It returns value kind followed by values of completion items.
+func (wb *Workbench) ParseCompleteWordsV1(s string) (res []string) {
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	res = append(res, lines[0])
+	for _, line := range lines[1:] {
+		fields := strings.Split(line, "\t")
+		require.Len(wb.t, fields, 6)
+		res = append(res, fields[0])
+	}
+	return
+}
+
 func (wb *Workbench) ParseCodListMap(s string) map[int]string {
 	res := make(map[int]string)
 	scanner := bufio.NewScanner(strings.NewReader(s))
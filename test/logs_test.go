@@ -0,0 +1,52 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogs(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+	wb.WriteUserConfiguration("log-level = \"debug\"\n")
+
+	shellPid := strconv.Itoa(wb.LaunchFakeShell())
+	wb.RunCodCmd("init", shellPid, "bash")
+	wb.RunCodCmdModifiedEnv(
+		map[string]string{"COD_TEST_SECRET": "secret-value"},
+		"learn", "--", "binaries/kill-like.py", "--help",
+	)
+
+	out := wb.RunCodCmd("logs", "--lines=-1")
+	require.Contains(t, out, "msg=\"Starting daemon\"")
+	require.Contains(t, out, "level=DEBUG msg=\"Received request\"")
+	require.Contains(t, out, "COD_TEST_SECRET=<redacted>")
+	require.NotContains(t, out, "secret-value")
+	require.Regexp(t, `level=INFO msg="Request done" pid=\d+ req=\d+ request=AddHelpPageRequest duration=`, out)
+
+	out = wb.RunCodCmd("logs", "--lines", "1")
+	require.Len(t, wb.SplitLines(out), 1)
+
+	_, err := wb.UncheckedRunCodCmd("deinit", shellPid, "zsh")
+	require.Error(t, err)
+	out = wb.RunCodCmd("logs", "--level", "warn")
+	require.NotContains(t, out, "level=INFO")
+	require.NotContains(t, out, "level=DEBUG")
+	require.Contains(t, out, "level=WARN msg=\"Request failed\"")
+}
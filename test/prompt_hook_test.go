@@ -0,0 +1,74 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromptHook(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	catPath, err := filepath.Abs("binaries/cat.py")
+	require.NoError(t, err)
+	wb.WriteUserConfiguration(fmt.Sprintf("[[rule]]\nexecutable = %q\npolicy = 'trust'\n", catPath))
+
+	shellPid := strconv.Itoa(wb.LaunchFakeShell())
+	initScript := wb.RunCodCmd("init", shellPid, "bash")
+
+	generationFile := filepath.Join(wb.getRunDir(), "cod.generation")
+	require.Contains(t, initScript, "\n__cod_generation_file="+generationFile+"\n")
+	readGeneration := func() string {
+		data, err := os.ReadFile(generationFile)
+		require.NoError(t, err)
+		return string(data)
+	}
+	generation := readGeneration()
+
+	out := wb.RunCodCmd("api", "prompt-hook", shellPid)
+	require.Equal(t, "", out)
+	require.Equal(t, generation, readGeneration())
+
+	wb.RunCodCmd("learn", "--", "binaries/kill-like.py", "--help")
+	require.NotEqual(t, generation, readGeneration())
+
+	out = wb.RunCodCmd("api", "prompt-hook", shellPid, "binaries/kill-like.py -s KILL 1")
+	require.Equal(t, "__cod_clear_completions kill-like.py\n__cod_add_completions kill-like.py\n", out)
+
+	out = wb.RunCodCmd("api", "prompt-hook", shellPid, "no-such-binary --help")
+	require.Equal(t, "", out)
+
+	out = wb.RunCodCmd("api", "prompt-hook", shellPid, "binaries/naval-fate.py --help")
+	require.Equal(t, fmt.Sprintf(
+		"command $__COD_BINARY api postexec -- %v 'binaries/naval-fate.py --help'\n"+
+			"source <(command $__COD_BINARY api poll-updates -- %v)\n",
+		shellPid, shellPid,
+	), out)
+
+	out = wb.RunCodCmd("api", "prompt-hook", shellPid, "binaries/cat.py --help")
+	require.Equal(t,
+		"printf '%s\\n' '\x1b[32mcod: learned completions: \"-A\" \"--show-all\" \"-b\" and 16 more\x1b[0m'\n"+
+			"__cod_clear_completions cat.py\n"+
+			"__cod_add_completions cat.py\n",
+		out,
+	)
+}
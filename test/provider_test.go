@@ -0,0 +1,89 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProviders(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	counterFile := wb.InTmpDataPath("counter")
+	wb.WriteUserConfiguration(fmt.Sprintf(`
+[[provider]]
+executable = "kill-like.py"
+flag = "--signal"
+command = "echo run >> %v; printf 'one\ntwo\nthree\n'"
+
+[[provider]]
+executable = "kill-like.py"
+positional = 1
+command = "echo $COD_TEST_PROVIDER_VALUE"
+ttl = 0
+`, counterFile))
+
+	shellPid := strconv.Itoa(wb.LaunchFakeShell())
+	wb.RunCodCmd("init", shellPid, "bash")
+	wb.RunCodCmd("learn", "--", "binaries/kill-like.py", "--help")
+
+	getCompletions := func(env map[string]string, args ...string) []string {
+		runCodCmdArgs := []string{
+			"api", "complete-words", "--format", "v1", "--",
+			shellPid,
+			strconv.Itoa(len(args) - 1),
+		}
+		runCodCmdArgs = append(runCodCmdArgs, args...)
+		out := wb.RunCodCmdModifiedEnv(env, runCodCmdArgs...)
+		return wb.ParseCompleteWordsV1(out)
+	}
+
+	lines := getCompletions(nil, "binaries/kill-like.py", "--signal", "t")
+	require.Equal(t, []string{"plain", "two", "three"}, lines)
+
+	lines = getCompletions(nil, "binaries/kill-like.py", "--signal=o")
+	require.Equal(t, []string{"plain", "--signal=one"}, lines)
+
+	// Second run must be cached.
+	counter, err := os.ReadFile(counterFile)
+	require.NoError(t, err)
+	require.Equal(t, "run\n", string(counter))
+
+	// Provider is rerun when environment changes.
+	kubeEnv := map[string]string{"KUBECONFIG": "/tmp/other-cluster"}
+	lines = getCompletions(kubeEnv, "binaries/kill-like.py", "--signal", "t")
+	require.Equal(t, []string{"plain", "two", "three"}, lines)
+	lines = getCompletions(kubeEnv, "binaries/kill-like.py", "--signal", "o")
+	require.Equal(t, []string{"plain", "one"}, lines)
+	counter, err = os.ReadFile(counterFile)
+	require.NoError(t, err)
+	require.Equal(t, "run\nrun\n", string(counter))
+
+	env := map[string]string{"COD_TEST_PROVIDER_VALUE": "foo"}
+	lines = getCompletions(env, "binaries/kill-like.py", "-v", "")
+	require.Equal(t, []string{"plain", "foo", "-s", "--signal", "-o", "--output=", "-v", "--verbose"}, lines)
+
+	lines = getCompletions(env, "binaries/kill-like.py", "-s", "HUP", "")
+	require.Equal(t, []string{"plain", "foo", "-s", "--signal", "-o", "--output=", "-v", "--verbose"}, lines)
+
+	lines = getCompletions(env, "binaries/kill-like.py", "bar", "")
+	require.Equal(t, []string{"", "-s", "--signal", "-o", "--output=", "-v", "--verbose"}, lines)
+}
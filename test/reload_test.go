@@ -0,0 +1,83 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestReloadUserConfiguration(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	writeProviderConfiguration := func(value string) {
+		wb.WriteUserConfiguration(fmt.Sprintf(`
+[[provider]]
+executable = "kill-like.py"
+flag = "--signal"
+command = "echo %v"
+ttl = 0
+`, value))
+	}
+	writeProviderConfiguration("one")
+
+	shellPid := strconv.Itoa(wb.LaunchFakeShell())
+	wb.RunCodCmd("init", shellPid, "bash")
+	wb.RunCodCmd("learn", "--", "binaries/kill-like.py", "--help")
+	wb.RunCodCmd("api", "poll-updates", "--", shellPid)
+
+	getCompletions := func() string {
+		return wb.RunCodCmd("api", "complete-words", "--", shellPid, "2", "binaries/kill-like.py", "--signal", "")
+	}
+	waitFor := func(condition func() bool) {
+		if !wb.WaitFor(condition) {
+			t.Fatal("timeout while waiting for configuration reload")
+		}
+	}
+	require.Equal(t, "one\n", getCompletions())
+
+	// Change of config file is noticed without restarting the daemon.
+	writeProviderConfiguration("two")
+	waitFor(func() bool {
+		return getCompletions() == "two\n"
+	})
+
+	// Broken configuration is not applied, shells are warned about it.
+	wb.WriteUserConfiguration("[[provider]\n")
+	var out string
+	waitFor(func() bool {
+		out = wb.RunCodCmd("api", "poll-updates", "--", shellPid)
+		return strings.Contains(out, "warn: cannot reload configuration, previous one is used")
+	})
+	require.Equal(t, "two\n", getCompletions())
+
+	// Warning is shown once.
+	out = wb.RunCodCmd("api", "poll-updates", "--", shellPid)
+	require.NotContains(t, out, "warn:")
+
+	// SIGHUP reloads configuration too.
+	err := unix.Kill(wb.GetDaemonPid(), unix.SIGHUP)
+	require.NoError(t, err)
+	waitFor(func() bool {
+		out = wb.RunCodCmd("api", "poll-updates", "--", shellPid)
+		return strings.Contains(out, "warn: cannot reload configuration, previous one is used")
+	})
+}
@@ -0,0 +1,221 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+type shellSession struct {
+	t      *testing.T
+	input  io.Writer
+	output *bufio.Reader
+	// Variables exported by the shell in addition to the environment of the test.
+	env []string
+}
+
+func (s *shellSession) Request(name string, args ...string) (status string, lines []string) {
+	dir, err := os.Getwd()
+	require.NoError(s.t, err)
+
+	env := append(os.Environ(), s.env...)
+	fields := []string{name, dir, strconv.Itoa(len(env))}
+	fields = append(fields, env...)
+	fields = append(fields, strconv.Itoa(len(args)))
+	fields = append(fields, args...)
+	_, err = io.WriteString(s.input, strings.Join(fields, "\x00")+"\x00")
+	require.NoError(s.t, err)
+
+	header, err := s.output.ReadString('\n')
+	require.NoError(s.t, err)
+	var count int
+	_, err = fmt.Sscanf(header, "%s %d\n", &status, &count)
+	require.NoError(s.t, err, "bad header: %q", header)
+	for i := 0; i < count; i += 1 {
+		var line string
+		line, err = s.output.ReadString('\n')
+		require.NoError(s.t, err)
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+	return
+}
+
+func TestShellSession(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	shellPid := strconv.Itoa(wb.LaunchFakeShell())
+	wb.RunCodCmd("init", shellPid, "bash")
+	wb.RunCodCmd("learn", "--", "binaries/kill-like.py", "--help")
+
+	cmd := wb.NewCodCmd("api", "session", "--", shellPid)
+	input, err := cmd.StdinPipe()
+	require.NoError(t, err)
+	output, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	session := shellSession{
+		t:      t,
+		input:  input,
+		output: bufio.NewReader(output),
+	}
+
+	status, lines := session.Request("complete-words", "1", "binaries/kill-like.py", "--s")
+	require.Equal(t, "ok", status)
+	require.Equal(t, []string{"", "--signal"}, wb.ParseCompleteWordsV1(strings.Join(lines, "\n")))
+
+	status, lines = session.Request("prompt-hook", "")
+	require.Equal(t, "ok", status)
+	require.Equal(t, []string{"__cod_clear_completions kill-like.py", "__cod_add_completions kill-like.py"}, lines)
+
+	status, lines = session.Request("prompt-hook", "binaries/kill-like.py --help")
+	require.Equal(t, "ok", status)
+	require.Equal(t, []string{
+		fmt.Sprintf("command $__COD_BINARY api postexec -- %v 'binaries/kill-like.py --help'", shellPid),
+		fmt.Sprintf("source <(command $__COD_BINARY api poll-updates -- %v)", shellPid),
+	}, lines)
+
+	status, lines = session.Request("prompt-hook", "binaries/kill-like.py -s KILL 1")
+	require.Equal(t, "ok", status)
+	require.Empty(t, lines)
+
+	status, _ = session.Request("unknown-request")
+	require.Equal(t, "error", status)
+
+	// Session reconnects to the restarted daemon.
+	wb.RunCodCmd("daemon", "restart")
+	status, lines = session.Request("complete-words", "1", "binaries/kill-like.py", "--v")
+	require.Equal(t, "ok", status)
+	require.Equal(t, []string{"", "--verbose"}, wb.ParseCompleteWordsV1(strings.Join(lines, "\n")))
+
+	require.NoError(t, input.Close())
+	require.NoError(t, cmd.Wait())
+}
+
+const sessionProviderConfiguration = `
+[[provider]]
+executable = "kill-like.py"
+positional = 1
+command = "echo ${COD_TEST_PROVIDER_VALUE:-unset}"
+ttl = 0
+`
+
+func TestShellSessionEnvironment(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+	wb.WriteUserConfiguration(sessionProviderConfiguration)
+	wb.RunCodCmd("learn", "--", "binaries/kill-like.py", "--help")
+
+	// Bash glue sends variables exported after the session is started with each request.
+	cmd := wb.NewCodCmd()
+	script := `
+source <("$0" init $$ bash)
+trap 'echo user trap' PIPE
+complete_value() {
+	__cod_session_request complete-words 1 binaries/kill-like.py "" || echo "session failed"
+	printf '%s\n' "${__cod_session_lines[@]:1}" | cut -f1 | grep -v '^-'
+	echo "session $__COD_SESSION_PID"
+}
+complete_value
+export COD_TEST_PROVIDER_VALUE=exported-later
+complete_value
+trap -p PIPE
+`
+	bash := exec.Command("bash", "-c", script, wb.codBinary)
+	bash.Env = cmd.Env
+	out, err := bash.CombinedOutput()
+	require.NoError(t, err, "output: %q", out)
+	lines := wb.SplitLines(string(out))
+	require.Len(t, lines, 5)
+	require.Equal(t, "unset", lines[0])
+	require.Equal(t, "exported-later", lines[2])
+	// Both requests are served by the same session.
+	require.Equal(t, lines[1], lines[3])
+	// Requests don't reset PIPE trap of the user.
+	require.Equal(t, "trap -- 'echo user trap' SIGPIPE", lines[4])
+}
+
+func TestFifoShellSession(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+	wb.WriteUserConfiguration(sessionProviderConfiguration)
+
+	shellPid := strconv.Itoa(wb.LaunchFakeShell())
+	wb.RunCodCmd("init", shellPid, "fish")
+	wb.RunCodCmd("learn", "--", "binaries/kill-like.py", "--help")
+
+	// Session inherits stderr, so only stdout is read like in command substitution of fish.
+	cmd := wb.NewCodCmd("api", "session", "--fifo", "--", shellPid)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	require.NoError(t, err)
+	fields := wb.SplitLines(string(out))
+	require.Len(t, fields, 2)
+	dir := fields[0]
+	sessionPid, err := strconv.Atoi(fields[1])
+	require.NoError(t, err)
+
+	// Shell opens fifos for each request.
+	request := func(env []string, name string, args ...string) (status string, lines []string) {
+		input, err := os.OpenFile(filepath.Join(dir, "in"), os.O_WRONLY, 0)
+		require.NoError(t, err)
+		output, err := os.Open(filepath.Join(dir, "out"))
+		require.NoError(t, err)
+		defer func() {
+			_ = output.Close()
+		}()
+		session := shellSession{
+			t:      t,
+			input:  input,
+			output: bufio.NewReader(output),
+			env:    env,
+		}
+		status, lines = session.Request(name, args...)
+		require.NoError(t, input.Close())
+		return
+	}
+
+	status, lines := request(nil, "complete-words", "1", "binaries/kill-like.py", "u")
+	require.Equal(t, "ok", status)
+	require.Equal(t, []string{"plain", "unset"}, wb.ParseCompleteWordsV1(strings.Join(lines, "\n")))
+
+	status, lines = request([]string{"COD_TEST_PROVIDER_VALUE=exported-later"}, "complete-words", "1", "binaries/kill-like.py", "e")
+	require.Equal(t, "ok", status)
+	require.Equal(t, []string{"plain", "exported-later"}, wb.ParseCompleteWordsV1(strings.Join(lines, "\n")))
+
+	// Prompt hook script is written for fish.
+	status, lines = request(nil, "prompt-hook", "binaries/kill-like.py --help")
+	require.Equal(t, "ok", status)
+	require.Contains(t, lines, fmt.Sprintf("command $__COD_BINARY api poll-updates -- %v | source", shellPid))
+
+	// Session removes fifos on exit, so the shell doesn't open fifos nobody serves.
+	require.NoError(t, unix.Kill(sessionPid, unix.SIGTERM))
+	removed := wb.WaitFor(func() bool {
+		_, err := os.Stat(dir)
+		return os.IsNotExist(err)
+	})
+	require.True(t, removed, "fifos are not removed")
+}
@@ -18,7 +18,6 @@ import (
 	"errors"
 	"strconv"
 	"testing"
-	"time"
 
 	"github.com/stretchr/testify/require"
 	"golang.org/x/sys/unix"
@@ -43,6 +42,7 @@ func TestAttachDetach(t *testing.T) {
 	defer wb.Close()
 
 	shellPid := wb.LaunchFakeShell()
+	wb.WriteUserConfiguration("idle-timeout = 0\n")
 
 	wb.RunCodCmd("init", strconv.Itoa(shellPid), "bash")
 	daemonPid := wb.GetDaemonPid()
@@ -59,15 +59,10 @@ func TestAttachDetach(t *testing.T) {
 
 	wb.KillFakeShell(shellPid)
 
-	daemonExists := true
-	for i := 0; i < 200; i += 1 {
-		daemonExists = checkProcessExists(daemonPid)
-		if !daemonExists {
-			break
-		}
-		time.Sleep(time.Millisecond * 10)
-	}
-	if daemonExists {
+	exited := wb.WaitFor(func() bool {
+		return !checkProcessExists(daemonPid)
+	})
+	if !exited {
 		t.Fatal("timeout while waiting for daemon to exit")
 	}
 }
@@ -0,0 +1,108 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSocketPermissions(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	shellPid := strconv.Itoa(wb.LaunchFakeShell())
+	wb.RunCodCmd("init", shellPid, "bash")
+
+	stat, err := os.Stat(wb.getRunDir())
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0700), stat.Mode().Perm())
+
+	stat, err = os.Stat(filepath.Join(wb.getRunDir(), "cod.sock"))
+	require.NoError(t, err)
+	require.Equal(t, os.ModeSocket, stat.Mode().Type())
+	require.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+}
+
+func TestLongSocketPath(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	runtimeDir := filepath.Join(wb.getRuntimeDir(), strings.Repeat("long-directory-name", 6))
+	require.NoError(t, os.MkdirAll(runtimeDir, 0700))
+
+	wb.RunCodCmdWithRuntimeDir(runtimeDir, "learn", "--", "binaries/kill-like.py", "--help")
+	out := wb.RunCodCmdWithRuntimeDir(runtimeDir, "list")
+	require.Contains(t, out, "kill-like.py --help")
+
+	out = wb.RunCodCmdWithRuntimeDir(runtimeDir, "daemon", "status")
+	if runtime.GOOS == "linux" {
+		require.Contains(t, out, "socket:        @cod-")
+	} else {
+		require.Contains(t, out, "socket:        /tmp/cod-")
+	}
+
+	out = wb.RunCodCmdWithRuntimeDir(runtimeDir, "daemon", "stop")
+	require.Equal(t, "cod: daemon is stopped\n", out)
+}
+
+func TestLegacyRunDirHandover(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	// Daemon without XDG_RUNTIME_DIR keeps runtime files in the data directory like older versions of cod.
+	legacyRunDir := filepath.Join(wb.getDataHome(), "cod", "var")
+	shellPid := strconv.Itoa(wb.LaunchFakeShell())
+	wb.RunCodCmdWithRuntimeDir("", "init", shellPid, "bash")
+	_, err := os.Stat(filepath.Join(legacyRunDir, "cod.sock"))
+	require.NoError(t, err)
+
+	out := wb.RunCodCmd("api", "list-clients")
+	require.Equal(t, shellPid+"\tbash\n", out)
+
+	out = wb.RunCodCmd("daemon", "status")
+	require.Contains(t, out, "socket:        "+filepath.Join(wb.getRunDir(), "cod.sock")+"\n")
+
+	target, err := os.Readlink(filepath.Join(legacyRunDir, "cod.generation"))
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(wb.getRunDir(), "cod.generation"), target)
+}
+
+func TestSingleDaemonPerDatabase(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	wb.RunCodCmd("learn", "--", "binaries/kill-like.py", "--help")
+	daemonPid := wb.GetDaemonPid()
+
+	otherRuntimeDir := filepath.Join(wb.getRuntimeDir(), "other")
+	require.NoError(t, os.MkdirAll(otherRuntimeDir, 0700))
+	for _, runtimeDir := range []string{"", otherRuntimeDir} {
+		cmd := wb.NewCodCmd("list")
+		cmd.Env = append(cmd.Env, "XDG_RUNTIME_DIR="+runtimeDir)
+		out, err := cmd.CombinedOutput()
+		require.Error(t, err)
+		require.Contains(t, string(out), "is used by daemon (pid "+strconv.Itoa(daemonPid)+")")
+	}
+
+	out := wb.RunCodCmd("list")
+	require.Contains(t, out, "kill-like.py --help")
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+	wb.WriteUserConfiguration("metrics-textfile = true\n")
+
+	shellPid := strconv.Itoa(wb.LaunchFakeShell())
+	wb.RunCodCmd("init", shellPid, "bash")
+	wb.RunCodCmd("learn", "--", "binaries/kill-like.py", "--help")
+	wb.RunCodCmd("learn", "--", "binaries/argparse-subcommand.py", "--help")
+	_, err := wb.UncheckedRunCodCmd("learn", "--", "binaries/no-such-binary", "--help")
+	require.Error(t, err)
+	wb.RunCodCmd("api", "complete-words", "--", shellPid, "1", "binaries/kill-like.py", "--")
+
+	out := wb.RunCodCmd("stats")
+	fields := make(map[string][]string)
+	for _, line := range wb.SplitLines(out) {
+		f := strings.Fields(line)
+		if len(f) > 0 {
+			fields[f[0]] = f[1:]
+		}
+	}
+	require.Equal(t, []string{"3", "1"}, fields["AddHelpPageRequest"][:2])
+	require.Equal(t, []string{"1", "0"}, fields["CompleteWordsRequest"][:2])
+	require.Equal(t, []string{"1", "1"}, fields["argparse"])
+	require.Equal(t, []string{"1", "0"}, fields["default"])
+	require.Contains(t, out, "learn failures: 1\n")
+	require.Contains(t, out, "update failures: 0\n")
+	killLikePath, err := filepath.Abs("binaries/kill-like.py")
+	require.NoError(t, err)
+	require.Equal(t, []string{"1"}, fields[killLikePath])
+
+	out = wb.RunCodCmd("stats", "--prometheus")
+	require.Contains(t, out, "cod_requests_total{request=\"AddHelpPageRequest\"} 3\n")
+	require.Contains(t, out, "cod_request_errors_total{request=\"AddHelpPageRequest\"} 1\n")
+	require.Contains(t, out, "cod_request_duration_seconds_bucket{request=\"CompleteWordsRequest\",le=\"+Inf\"} 1\n")
+	require.Contains(t, out, "cod_parser_results_total{parser=\"argparse\",result=\"failure\"} 1\n")
+	require.Contains(t, out, "cod_learn_failures_total 1\n")
+	require.Contains(t, out, "cod_help_pages{executable=\""+killLikePath+"\"} 1\n")
+
+	// Textfile is written when daemon exits.
+	wb.RunCodCmd("daemon", "stop")
+	require.True(t, wb.WaitDaemonExit())
+	data, err := os.ReadFile(filepath.Join(wb.getDataHome(), "cod", "metrics", "cod.prom"))
+	require.NoError(t, err)
+	require.Contains(t, string(data), "cod_learn_failures_total 1\n")
+}
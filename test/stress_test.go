@@ -0,0 +1,108 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestConcurrentCompletions completes command lines from many shells while learned commands are updated.
+// Run it with daemon built with `-race` (see `make test-race`) to check the daemon for data races.
+func TestConcurrentCompletions(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	shellCount := 8
+	completionCount := 20
+
+	var shellPids []string
+	for i := 0; i < shellCount; i += 1 {
+		shellPid := strconv.Itoa(wb.LaunchFakeShell())
+		wb.RunCodCmd("init", shellPid, "bash")
+		shellPids = append(shellPids, shellPid)
+	}
+	wb.RunCodCmd("learn", "--", "binaries/kill-like.py", "--help")
+	wb.RunCodCmd("learn", "--", "binaries/naval-fate.py", "--help")
+
+	expected := wb.RunCodCmd("api", "complete-words", "--", shellPids[0], "1", "binaries/kill-like.py", "--")
+	require.Contains(t, expected, "--signal\n")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, shellCount*completionCount+1)
+	var completionsDone int32
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for updates := 0; updates < 2 || atomic.LoadInt32(&completionsDone) < int32(shellCount); updates += 1 {
+			out, err := wb.UncheckedRunCodCmd("update", "kill-like.py", "naval-fate.py")
+			if err != nil {
+				errs <- fmt.Errorf("update failed: %w; output: %q", err, out)
+				return
+			}
+		}
+	}()
+
+	for _, shellPid := range shellPids {
+		wg.Add(1)
+		go func(shellPid string) {
+			defer wg.Done()
+			defer atomic.AddInt32(&completionsDone, 1)
+			for i := 0; i < completionCount; i += 1 {
+				out, err := wb.UncheckedRunCodCmd("api", "complete-words", "--", shellPid, "1", "binaries/kill-like.py", "--")
+				if err != nil {
+					errs <- fmt.Errorf("completion in shell %v failed: %w; output: %q", shellPid, err, out)
+					return
+				}
+				if out != expected {
+					errs <- fmt.Errorf("unexpected completions in shell %v: %q", shellPid, out)
+					return
+				}
+				out, err = wb.UncheckedRunCodCmd("api", "poll-updates", "--", shellPid)
+				if err != nil {
+					errs <- fmt.Errorf("poll in shell %v failed: %w; output: %q", shellPid, err, out)
+					return
+				}
+			}
+		}(shellPid)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	out := wb.RunCodCmd("list")
+	require.Len(t, wb.SplitLines(out), 2)
+
+	logFiles, err := filepath.Glob(filepath.Join(wb.getDataHome(), "cod", "log", "*.log"))
+	require.NoError(t, err)
+	require.NotEmpty(t, logFiles)
+	for _, logFile := range logFiles {
+		data, err := os.ReadFile(logFile)
+		require.NoError(t, err)
+		require.Contains(t, string(data), "Starting daemon")
+		require.False(t, strings.Contains(string(data), "DATA RACE"), "data race is detected, see %v", logFile)
+	}
+}
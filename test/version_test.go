@@ -0,0 +1,89 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionHandshake(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	shellPid := strconv.Itoa(wb.LaunchFakeShell())
+	wb.RunCodCmd("init", shellPid, "bash")
+
+	out := wb.RunCodCmd("api", "version")
+	lines := wb.SplitLines(out)
+	require.Len(t, lines, 2)
+
+	client := strings.Split(lines[0], "\t")
+	daemon := strings.Split(lines[1], "\t")
+	require.Equal(t, "client", client[0])
+	require.Equal(t, "daemon", daemon[0])
+	require.Equal(t, client[1], daemon[1])
+	require.Equal(t, client[2], daemon[2])
+
+	out = wb.RunCodCmd("api", "list-clients")
+	require.Equal(t, shellPid+"\tbash\n", out)
+}
+
+func TestUpgradeDaemonOfOlderProtocol(t *testing.T) {
+	wb := SetupWorkbench(t)
+	defer wb.Close()
+
+	oldBinary := buildOldProtocolBinary(t)
+	runOldCodCmd := func(args ...string) string {
+		cmd := wb.NewCodCmd(args...)
+		cmd.Path = oldBinary
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, "output: %q", output)
+		return string(output)
+	}
+	shellPid := strconv.Itoa(wb.LaunchFakeShell())
+	runOldCodCmd("init", shellPid, "bash")
+	out := runOldCodCmd("api", "list-clients")
+	require.Equal(t, shellPid+"\tbash\n", out)
+	oldDaemonPid := wb.GetDaemonPid()
+
+	// Old daemon rejects request of the new binary and hands over its shells to the new daemon.
+	out = wb.RunCodCmd("api", "list-clients")
+	require.Equal(t, shellPid+"\tbash\n", out)
+	require.NotEqual(t, oldDaemonPid, wb.GetDaemonPid())
+
+	out = wb.RunCodCmd("api", "version")
+	lines := wb.SplitLines(out)
+	require.Len(t, lines, 2)
+	client := strings.Split(lines[0], "\t")
+	daemon := strings.Split(lines[1], "\t")
+	require.Equal(t, client[1], daemon[1])
+}
+
+// buildOldProtocolBinary builds cod speaking protocol of older release.
+func buildOldProtocolBinary(t *testing.T) string {
+	// Directories of cod are named after its binary.
+	binary := filepath.Join(t.TempDir(), "cod")
+	cmd := exec.Command("go", "build", "-tags", "cod_old_protocol", "-o", binary, ".")
+	cmd.Dir = ".."
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, "output: %q", output)
+	return binary
+}
@@ -0,0 +1,125 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// RotatingFile is a writer appending to a file that is rotated when it grows bigger than maxSize.
+// Rotated files are renamed to "<name>.1", "<name>.2" and so on, the oldest one is removed
+// when there are more than backups of them. backups must be positive.
+type RotatingFile struct {
+	name    string
+	maxSize int64
+	backups int
+	// Descriptor that is redirected to the current file after each rotation, -1 if none.
+	redirectFd int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+func NewRotatingFile(name string, maxSize int64, backups int) (f *RotatingFile, err error) {
+	f = &RotatingFile{
+		name:       name,
+		maxSize:    maxSize,
+		backups:    backups,
+		redirectFd: -1,
+	}
+	err = f.open()
+	if err != nil {
+		f = nil
+	}
+	return
+}
+
+// RedirectFd makes fd refer to the current file now and after each rotation,
+// e.g. stderr of the daemon, so panics are written to the log.
+func (f *RotatingFile) RedirectFd(fd int) (err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.redirectFd = fd
+	err = unix.Dup2(int(f.file.Fd()), fd)
+	return
+}
+
+func (f *RotatingFile) Write(p []byte) (n int, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		err = f.rotate()
+		if err != nil {
+			return
+		}
+	}
+	n, err = f.file.Write(p)
+	f.size += int64(n)
+	return
+}
+
+func (f *RotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.Close()
+}
+
+func (f *RotatingFile) open() (err error) {
+	file, err := os.OpenFile(f.name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return
+	}
+	f.file = file
+	f.size = stat.Size()
+	if f.redirectFd >= 0 {
+		err = unix.Dup2(int(f.file.Fd()), f.redirectFd)
+	}
+	return
+}
+
+func (f *RotatingFile) rotate() (err error) {
+	err = f.file.Close()
+	if err != nil {
+		return
+	}
+	for i := f.backups - 1; i >= 0; i -= 1 {
+		err = os.Rename(BackupName(f.name, i), BackupName(f.name, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return
+		}
+	}
+	err = f.open()
+	return
+}
+
+// BackupName returns name of i-th rotated file, i = 0 is the current file.
+func BackupName(name string, i int) string {
+	if i == 0 {
+		return name
+	}
+	return fmt.Sprintf("%v.%v", name, i)
+}
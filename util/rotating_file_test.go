@@ -0,0 +1,63 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.log")
+	readFile := func(name string) string {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	f, err := NewRotatingFile(name, 10, 2)
+	require.NoError(t, err)
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err = f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+
+	require.Equal(t, "fourth\n", readFile(name))
+	require.Equal(t, "third\n", readFile(name+".1"))
+	require.Equal(t, "second\n", readFile(name+".2"))
+	_, err = os.Stat(name + ".3")
+	require.True(t, os.IsNotExist(err))
+
+	// Size of existing file is taken into account.
+	f, err = NewRotatingFile(name, 10, 2)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("fifth\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Equal(t, "fifth\n", readFile(name))
+	require.Equal(t, "fourth\n", readFile(name+".1"))
+
+	// Line longer than limit is not split.
+	f, err = NewRotatingFile(name, 10, 2)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("very long line\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Equal(t, "very long line\n", readFile(name))
+}
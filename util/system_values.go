@@ -0,0 +1,145 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Functions in this file list values that are used to complete flags expecting
+// host names, user names, groups etc.
+
+func readLines(fileName string, parse func(r io.Reader) []string) []string {
+	f, err := os.Open(fileName)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("cannot open %s: %v", fileName, err)
+		}
+		return nil
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return parse(f)
+}
+
+func scanFields(r io.Reader, f func(fields []string) []string) (res []string) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.IndexRune(line, '#'); idx >= 0 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		res = append(res, f(fields)...)
+	}
+	return
+}
+
+// ParseHostsFile extracts host names from the file in /etc/hosts format.
+func ParseHostsFile(r io.Reader) []string {
+	return scanFields(r, func(fields []string) []string {
+		return fields[1:]
+	})
+}
+
+// ParseSshConfigHosts extracts host aliases from `Host` lines of ssh config.
+// Patterns (e.g. `Host *.example.com`) are skipped.
+func ParseSshConfigHosts(r io.Reader) []string {
+	return scanFields(r, func(fields []string) (res []string) {
+		if !strings.EqualFold(fields[0], "host") {
+			return
+		}
+		for _, h := range fields[1:] {
+			if !strings.ContainsAny(h, "*?!") {
+				res = append(res, h)
+			}
+		}
+		return
+	})
+}
+
+// ParseColonSeparatedNames extracts the first column of /etc/passwd or /etc/group like files.
+func ParseColonSeparatedNames(r io.Reader) (res []string) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := strings.SplitN(line, ":", 2)[0]
+		if len(name) > 0 {
+			res = append(res, name)
+		}
+	}
+	return
+}
+
+func ListHostnames(homeDir string) (res []string) {
+	res = append(res, readLines("/etc/hosts", ParseHostsFile)...)
+	if len(homeDir) > 0 {
+		res = append(res, readLines(filepath.Join(homeDir, ".ssh", "config"), ParseSshConfigHosts)...)
+	}
+	return sortUniq(res)
+}
+
+func ListUsers() []string {
+	return sortUniq(readLines("/etc/passwd", ParseColonSeparatedNames))
+}
+
+func ListGroups() []string {
+	return sortUniq(readLines("/etc/group", ParseColonSeparatedNames))
+}
+
+func ListSignals() (res []string) {
+	for s := syscall.Signal(1); s < 32; s += 1 {
+		name := unix.SignalName(s)
+		if len(name) > 0 {
+			res = append(res, strings.TrimPrefix(name, "SIG"))
+		}
+	}
+	return
+}
+
+func ListNetworkInterfaces() (res []string) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		log.Printf("cannot list network interfaces: %v", err)
+		return
+	}
+	for _, i := range interfaces {
+		res = append(res, i.Name)
+	}
+	return
+}
+
+func sortUniq(s []string) []string {
+	sort.Strings(s)
+	return slices.Compact(s)
+}
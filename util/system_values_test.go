@@ -0,0 +1,65 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHostsFile(t *testing.T) {
+	hosts := `# comment
+127.0.0.1	localhost
+::1     localhost ip6-localhost ip6-loopback
+
+10.0.0.1 build-server # our CI
+`
+	require.Equal(t,
+		[]string{"localhost", "localhost", "ip6-localhost", "ip6-loopback", "build-server"},
+		ParseHostsFile(strings.NewReader(hosts)),
+	)
+}
+
+func TestParseSshConfigHosts(t *testing.T) {
+	config := `Host github
+    HostName github.com
+Host *.internal bastion
+  User admin
+host dev !prod
+`
+	require.Equal(t,
+		[]string{"github", "bastion", "dev"},
+		ParseSshConfigHosts(strings.NewReader(config)),
+	)
+}
+
+func TestParseColonSeparatedNames(t *testing.T) {
+	passwd := `root:x:0:0:root:/root:/bin/bash
+# comment
+daemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin
+`
+	require.Equal(t,
+		[]string{"root", "daemon"},
+		ParseColonSeparatedNames(strings.NewReader(passwd)),
+	)
+}
+
+func TestListSignals(t *testing.T) {
+	signals := ListSignals()
+	require.Contains(t, signals, "HUP")
+	require.Contains(t, signals, "TERM")
+}
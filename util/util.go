@@ -20,7 +20,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"sort"
+	"syscall"
 )
 
 var ErrNotImplemented = fmt.Errorf("not implemented")
@@ -52,6 +54,35 @@ func CreateDirIfNotExists(dir string) (err error) {
 	return
 }
 
+// CreatePrivateDir creates directory accessible only by the current user,
+// permissions of existing directory are fixed if it is owned by the current user.
+func CreatePrivateDir(dir string) (err error) {
+	err = os.MkdirAll(filepath.Dir(dir), 0755)
+	if err != nil {
+		return
+	}
+	err = os.Mkdir(dir, 0700)
+	if err != nil && !os.IsExist(err) {
+		return
+	}
+	stat, err := os.Lstat(dir)
+	if err != nil {
+		return
+	}
+	if !stat.IsDir() {
+		err = fmt.Errorf("%v is not a directory", dir)
+		return
+	}
+	if sys, ok := stat.Sys().(*syscall.Stat_t); ok && int(sys.Uid) != os.Getuid() {
+		err = fmt.Errorf("%v is owned by other user (uid %v)", dir, sys.Uid)
+		return
+	}
+	if stat.Mode().Perm() != 0700 {
+		err = os.Chmod(dir, 0700)
+	}
+	return
+}
+
 type Warning struct {
 	Warning string
 }
@@ -0,0 +1,47 @@
+// Copyright 2020 Dmitry Ermolov
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreatePrivateDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "parent", "run")
+
+	err := CreatePrivateDir(dir)
+	require.NoError(t, err)
+	stat, err := os.Stat(dir)
+	require.NoError(t, err)
+	require.True(t, stat.IsDir())
+	require.Equal(t, os.FileMode(0700), stat.Mode().Perm())
+
+	// Permissions of existing directory are fixed.
+	require.NoError(t, os.Chmod(dir, 0755))
+	err = CreatePrivateDir(dir)
+	require.NoError(t, err)
+	stat, err = os.Stat(dir)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0700), stat.Mode().Perm())
+
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0600))
+	err = CreatePrivateDir(file)
+	require.Error(t, err)
+}